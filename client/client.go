@@ -1,18 +1,52 @@
 package client
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/muxxer/diverdriver/client/ipcclient"
 	"github.com/muxxer/diverdriver/client/remoteclient"
 	"github.com/muxxer/diverdriver/common"
 	"github.com/muxxer/diverdriver/utils"
 )
 
+// Initialize creates a client for a diverDriver path, or for remote POW servers if the path is a comma separated
+// list of http(s) URLs. The requests are distributed round-robin over several remote POW servers.
 func Initialize(diverDriverPath string, writeTimeOutMs int64, readTimeOutMs int) *common.DiverClient {
 	p := &common.DiverClient{DiverDriverPath: diverDriverPath, WriteTimeOutMs: writeTimeOutMs, ReadTimeOutMs: readTimeOutMs}
-	if utils.IsValidRemoteURL(p.DiverDriverPath) {
+	if utils.IsValidRemoteURLList(p.DiverDriverPath) {
 		p.PowClientImplementation = remoteclient.RemoteClient
 	} else {
 		p.PowClientImplementation = ipcclient.IpcClient
 	}
 	return p
 }
+
+// InitializeAndConnect creates a client like Initialize and checks that the diverDriver is reachable
+// Local diverDriver paths are checked with Ping, remote POW URLs with GetPowInfo.
+// An error is returned if the check fails or the context is done before the check finished.
+func InitializeAndConnect(ctx context.Context, diverDriverPath string, writeTimeOutMs int64, readTimeOutMs int) (*common.DiverClient, error) {
+	p := Initialize(diverDriverPath, writeTimeOutMs, readTimeOutMs)
+
+	checkDone := make(chan error, 1)
+	go func() {
+		var err error
+		if utils.IsValidRemoteURLList(p.DiverDriverPath) {
+			_, _, _, err = p.GetPowInfo()
+		} else {
+			_, err = p.Ping()
+		}
+		checkDone <- err
+	}()
+
+	select {
+	case err := <-checkDone:
+		if err != nil {
+			return nil, fmt.Errorf("diverDriver \"%v\" not reachable: %v", diverDriverPath, err)
+		}
+		return p, nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -1,10 +1,21 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
 	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/server/ipc"
+	"github.com/spf13/viper"
 )
 
 const (
@@ -83,3 +94,388 @@ func TestRemotePOW(t *testing.T) {
 		t.Logf("Client received: %v", response)
 	}
 }
+
+func TestTestServer(t *testing.T) {
+	path, stop := ipcserver.NewTestServer(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if mwm > MWM {
+			return "", errors.New("MWM not supported")
+		}
+		return trytes, nil
+	})
+	defer stop()
+
+	diverClient := Initialize(path, 500, 5000)
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := diverClient.PowFunc(data, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != data {
+		t.Errorf("Unexpected response %v", response)
+	}
+
+	// Errors of the POW function are returned to the client
+	if _, err := diverClient.PowFunc(data, MWM+1); err == nil || err.Error() != "MWM not supported" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestGetPowInfoCache(t *testing.T) {
+	path, stop := ipcserver.NewTestServer(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+
+	diverClient := Initialize(path, 500, 5000)
+	serverVersion, _, _, err := diverClient.GetPowInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+
+	// The cached result is returned without asking the stopped server
+	cachedVersion, _, _, err := diverClient.GetPowInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cachedVersion != serverVersion {
+		t.Errorf("Unexpected cached server version %v, expected %v", cachedVersion, serverVersion)
+	}
+
+	diverClient.InvalidatePowInfo()
+	if _, _, _, err := diverClient.GetPowInfo(); err == nil {
+		t.Error("Expected an error after the cache was invalidated")
+	}
+}
+
+func TestExpectedPow(t *testing.T) {
+	path, stop := ipcserver.NewTestServer(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes[:27], nil
+	})
+	defer stop()
+
+	diverClient := Initialize(path, 500, 5000)
+	diverClient.ExpectedPowType = "TestPow"
+	if _, err := diverClient.PowFunc(giota.Trytes(transaction), MWM); err != nil {
+		t.Fatal(err)
+	}
+
+	diverClient = Initialize(path, 500, 5000)
+	diverClient.ExpectedPowType = "OtherPow"
+	_, err := diverClient.PowFunc(giota.Trytes(transaction), MWM)
+	var mismatch *common.ErrPowMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected ErrPowMismatch, got %v", err)
+	}
+	if mismatch.PowType != "TestPow" || mismatch.ExpectedPowType != "OtherPow" {
+		t.Errorf("Unexpected mismatch %+v", mismatch)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	var running, maxRunning int32
+	release := make(chan struct{})
+	diverClient := &common.DiverClient{
+		MaxConcurrency: 2,
+		PowClientImplementation: &common.ClientAPI{
+			PowFuncDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (giota.Trytes, error) {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+						break
+					}
+				}
+				<-release
+				return trytes, nil
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := diverClient.PowFunc("ABC9", MWM); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// The requests beyond the limit wait for a free slot
+	for atomic.LoadInt32(&running) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := diverClient.PowFuncContext(ctx, "ABC9", MWM); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded while all slots are used, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	if maxRunning != 2 {
+		t.Errorf("Unexpected number of concurrent requests %d, expected 2", maxRunning)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	var pings, failures int32 = 0, 3
+	diverClient := &common.DiverClient{
+		PowClientImplementation: &common.ClientAPI{
+			PingDefinition: func(p *common.DiverClient) (time.Duration, error) {
+				if atomic.AddInt32(&pings, 1) <= atomic.LoadInt32(&failures) {
+					return 0, errors.New("connection refused")
+				}
+				return time.Millisecond, nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := diverClient.WaitReady(ctx, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if pings != 4 {
+		t.Errorf("Unexpected number of pings %d, expected 4", pings)
+	}
+
+	// A server that never answers times out with the error of the last ping
+	atomic.StoreInt32(&failures, 1<<30)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := diverClient.WaitReady(ctx, time.Millisecond)
+	var notReady *common.ErrNotReady
+	if !errors.As(err, &notReady) {
+		t.Fatalf("Expected ErrNotReady, got %v", err)
+	}
+	if !notReady.Timeout() || !errors.Is(err, context.DeadlineExceeded) || notReady.LastPingErr.Error() != "connection refused" {
+		t.Errorf("Unexpected error %+v", notReady)
+	}
+}
+
+func TestVerifyPoW(t *testing.T) {
+	bogusNonce := giota.Trytes(strings.Repeat("9", common.TransactionTrinarySize-common.NonceTrinaryOffset))
+	nonce := bogusNonce
+	diverClient := &common.DiverClient{
+		PowClientImplementation: &common.ClientAPI{
+			PowFuncDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (giota.Trytes, error) {
+				return nonce, nil
+			},
+		},
+	}
+
+	data := giota.Trytes(transaction)
+
+	// Without VerifyPoW the result is trusted
+	if _, err := diverClient.PowFunc(data, MWM); err != nil {
+		t.Fatal(err)
+	}
+
+	diverClient.VerifyPoW = true
+	_, err := diverClient.PowFunc(data, MWM)
+	var invalidNonce *common.ErrInvalidNonce
+	if !errors.As(err, &invalidNonce) || invalidNonce.MinWeightMagnitude != MWM || invalidNonce.Nonce != bogusNonce {
+		t.Errorf("Expected ErrInvalidNonce, got %v", err)
+	}
+
+	// A nonce found by a real POW is accepted
+	nonce, err = giota.PowGo(data, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := diverClient.PowFunc(data, 9); err != nil || result != nonce {
+		t.Errorf("Valid nonce was rejected: %v, %v", result, err)
+	}
+}
+
+func TestPowFuncFullInvalidLength(t *testing.T) {
+	diverClient := &common.DiverClient{
+		PowClientImplementation: &common.ClientAPI{
+			PowFuncFullDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (giota.Trytes, error) {
+				return "ABC9", nil
+			},
+		},
+	}
+
+	// The length is checked with and without VerifyPoW
+	for _, verifyPoW := range []bool{false, true} {
+		diverClient.VerifyPoW = verifyPoW
+		if _, err := diverClient.PowFuncFull(giota.Trytes(transaction), MWM); err == nil {
+			t.Errorf("VerifyPoW %v: a truncated transaction was accepted", verifyPoW)
+		}
+	}
+}
+
+func TestPowTransaction(t *testing.T) {
+	bogusNonce := giota.Trytes(strings.Repeat("9", common.TransactionTrinarySize-common.NonceTrinaryOffset))
+	nonce := bogusNonce
+	diverClient := &common.DiverClient{
+		PowClientImplementation: &common.ClientAPI{
+			PowFuncDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (giota.Trytes, error) {
+				return nonce, nil
+			},
+		},
+	}
+
+	tx, err := giota.NewTransaction(giota.Trytes(transaction))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hash is checked even without VerifyPoW
+	_, err = diverClient.PowTransaction(tx, 9)
+	var invalidNonce *common.ErrInvalidNonce
+	if !errors.As(err, &invalidNonce) || invalidNonce.Nonce != bogusNonce {
+		t.Errorf("Expected ErrInvalidNonce, got %v", err)
+	}
+
+	nonce, err = giota.PowGo(tx.Trytes(), 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := diverClient.PowTransaction(tx, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Nonce != nonce {
+		t.Errorf("Unexpected nonce %v, expected %v", result.Nonce, nonce)
+	}
+
+	if _, err := diverClient.PowTransaction(nil, 9); err == nil {
+		t.Error("Nil transaction was accepted")
+	}
+}
+
+func TestInitializeFallback(t *testing.T) {
+	path, stop := ipcserver.NewTestServer(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer stop()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first endpoint is not available, so the request is sent to the second one
+	diverClient := InitializeFallback([]string{filepath.Join(t.TempDir(), "missing.sock"), path}, 500, 5000)
+	response, err := diverClient.PowFunc(data, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != data {
+		t.Errorf("Unexpected response %v", response)
+	}
+
+	// The error of the last endpoint is returned, if all endpoints fail
+	diverClient = InitializeFallback([]string{path + ".missing1", path + ".missing2"}, 500, 5000)
+	if _, err := diverClient.PowFunc(data, MWM); err == nil || !strings.Contains(err.Error(), "missing2") {
+		t.Errorf("Expected the error of the last endpoint, got %v", err)
+	}
+}
+
+func TestInitializeFallbackSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := ipcserver.Listen(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	config.Set("server.authKey", "secret")
+
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+	server := ipcserver.NewServer(ln, config, "TestPow", "")
+	server.Start()
+	defer server.Stop()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key set on the fallback client is used by the clients of the endpoints
+	diverClient := InitializeFallback([]string{filepath.Join(t.TempDir(), "missing.sock"), path}, 500, 5000)
+	diverClient.AuthKey = "secret"
+	if _, err := diverClient.PowFunc(data, MWM); err != nil {
+		t.Fatal(err)
+	}
+
+	diverClient = InitializeFallback([]string{path}, 500, 5000)
+	if _, err := diverClient.PowFunc(data, MWM); err == nil {
+		t.Error("POW request without authentication was accepted")
+	}
+}
+
+func TestInitializeAndConnect(t *testing.T) {
+	path, stop := ipcserver.NewTestServer(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer stop()
+
+	if _, err := InitializeAndConnect(context.Background(), path, 500, 5000); err != nil {
+		t.Errorf("Connecting to the running server failed: %v", err)
+	}
+
+	missingPath := filepath.Join(t.TempDir(), "missing.sock")
+	if _, err := InitializeAndConnect(context.Background(), missingPath, 500, 5000); err == nil || !strings.Contains(err.Error(), missingPath) {
+		t.Errorf("Expected an error for a missing server, got %v", err)
+	}
+}
+
+func TestCloseNoFDLeak(t *testing.T) {
+	if _, err := ioutil.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("Open file descriptors can't be counted on this platform")
+	}
+
+	path, stop := ipcserver.NewTestServer(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer stop()
+
+	countFDs := func() int {
+		fds, _ := ioutil.ReadDir("/proc/self/fd")
+		return len(fds)
+	}
+
+	// Warm up, so lazily opened descriptors of the runtime are not counted
+	warmup := Initialize(path, 500, 5000)
+	warmup.Ping()
+	warmup.Close()
+	before := countFDs()
+
+	for i := 0; i < 100; i++ {
+		diverClient := InitializeFallback([]string{path}, 500, 5000)
+		if _, err := diverClient.Ping(); err != nil {
+			t.Fatal(err)
+		}
+		if err := diverClient.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := diverClient.Ping(); err != common.ErrClientClosed {
+			t.Fatalf("Expected %v after Close, got %v", common.ErrClientClosed, err)
+		}
+	}
+
+	// The server closes its side of the connections in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for countFDs() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := countFDs(); after > before {
+		t.Errorf("%d file descriptors leaked", after-before)
+	}
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+)
+
+// InitializeFallback creates a client that sends every request to the given endpoints in order, until one of them succeeds
+// The endpoints can be a mix of diverDriver paths and remote POW URLs, e.g. a local FPGA with a remote POW service as fallback.
+// If all endpoints fail, the error of the last one is returned.
+// The settings of the returned client, e.g. AuthKey, TLSConfig or RetryPolicy, are passed to the clients of the endpoints
+// with the first request, so they have to be set before it like MaxConcurrency (see copyFallbackSettings).
+func InitializeFallback(diverDriverPaths []string, writeTimeOutMs int64, readTimeOutMs int) *common.DiverClient {
+	clients := make([]*common.DiverClient, len(diverDriverPaths))
+	for i, path := range diverDriverPaths {
+		clients[i] = Initialize(path, writeTimeOutMs, readTimeOutMs)
+	}
+
+	return &common.DiverClient{
+		PowClientImplementation: newFallbackClientAPI(clients),
+		DiverDriverPath:         strings.Join(diverDriverPaths, ", "),
+		WriteTimeOutMs:          writeTimeOutMs,
+		ReadTimeOutMs:           readTimeOutMs,
+	}
+}
+
+var errNoEndpoints = errors.New("No endpoints configured")
+
+// copyFallbackSettings passes the settings of the fallback client p to the client c of an endpoint
+// DialFunc belongs to a single path and is not passed. MaxConcurrency, ExpectedPowType and ExpectedPowVersion
+// are applied by the fallback client itself, and every endpoint keeps the state of its own CircuitBreaker.
+func copyFallbackSettings(c *common.DiverClient, p *common.DiverClient) {
+	c.TLSConfig = p.TLSConfig
+	c.AuthKey = p.AuthKey
+	c.WriteTimeOutMs = p.WriteTimeOutMs
+	c.ReadTimeOutMs = p.ReadTimeOutMs
+	c.CommandTimeOutsMs = p.CommandTimeOutsMs
+	c.ReadBufferSize = p.ReadBufferSize
+	c.MaxFrameLength = p.MaxFrameLength
+	c.ChunkedResponses = p.ChunkedResponses
+	c.MaxResponseLength = p.MaxResponseLength
+	c.RetryPolicy = p.RetryPolicy
+	c.CircuitBreaker.Threshold = p.CircuitBreaker.Threshold
+	c.CircuitBreaker.Cooldown = p.CircuitBreaker.Cooldown
+	c.MaxMinWeightMagnitude = p.MaxMinWeightMagnitude
+	c.PowBackend = p.PowBackend
+	c.PackedTrytes = p.PackedTrytes
+	c.FrameVersion = p.FrameVersion
+	c.SkipReqIDCheck = p.SkipReqIDCheck
+	c.VerifyPoW = p.VerifyPoW
+	c.Crc8 = p.Crc8
+	c.PowInfoCacheTTL = p.PowInfoCacheTTL
+	c.Tracer = p.Tracer
+	c.KeepAlive = p.KeepAlive
+	c.OnNotification = p.OnNotification
+}
+
+// newFallbackClientAPI creates a ClientAPI that tries the clients in order
+func newFallbackClientAPI(clients []*common.DiverClient) *common.ClientAPI {
+	// The settings are copied only once, the clients of the endpoints are used concurrently afterwards
+	var settingsOnce sync.Once
+
+	// try calls f for every client until it succeeds and returns the last error otherwise
+	try := func(p *common.DiverClient, f func(c *common.DiverClient) error) error {
+		settingsOnce.Do(func() {
+			for _, c := range clients {
+				copyFallbackSettings(c, p)
+			}
+		})
+
+		err := errNoEndpoints
+		for _, c := range clients {
+			if err = f(c); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+
+	return &common.ClientAPI{
+		PowFuncDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				result, err = c.PowFunc(trytes, minWeightMagnitude)
+				return err
+			})
+			return result, err
+		},
+		PowFuncFullDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (transaction giota.Trytes, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				transaction, err = c.PowFuncFull(trytes, minWeightMagnitude)
+				return err
+			})
+			return transaction, err
+		},
+		PowFuncTimedDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, duration time.Duration, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				result, duration, err = c.PowFuncTimed(trytes, minWeightMagnitude)
+				return err
+			})
+			return result, duration, err
+		},
+		PowFuncHighPriorityDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				result, err = c.PowFuncHighPriority(trytes, minWeightMagnitude)
+				return err
+			})
+			return result, err
+		},
+		PowFuncRawDefinition: func(p *common.DiverClient, trytes []byte, minWeightMagnitude int) (result []byte, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				result, err = c.PowFuncRaw(trytes, minWeightMagnitude)
+				return err
+			})
+			return result, err
+		},
+		PowFuncDryRunDefinition: func(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				result, err = c.PowFuncDryRun(trytes, minWeightMagnitude)
+				return err
+			})
+			return result, err
+		},
+		PowFuncContextDefinition: func(ctx context.Context, p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				if ctx.Err() != nil {
+					// Don't try the remaining endpoints for a cancelled request
+					return ctx.Err()
+				}
+				result, err = c.PowFuncContext(ctx, trytes, minWeightMagnitude)
+				return err
+			})
+			return result, err
+		},
+		GetPowInfoDefinition: func(p *common.DiverClient) (ServerVersion string, PowType string, PowVersion string, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				ServerVersion, PowType, PowVersion, err = c.GetPowInfo()
+				return err
+			})
+			return ServerVersion, PowType, PowVersion, err
+		},
+		GetVersionsDefinition: func(p *common.DiverClient) (Versions common.Versions, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				Versions, err = c.Versions()
+				return err
+			})
+			return Versions, err
+		},
+		GetServerInfoDefinition: func(p *common.DiverClient) (ServerInfo common.ServerInfo, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				ServerInfo, err = c.GetServerInfo()
+				return err
+			})
+			return ServerInfo, err
+		},
+		GetStatsDefinition: func(p *common.DiverClient) (Stats common.Stats, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				Stats, err = c.GetStats()
+				return err
+			})
+			return Stats, err
+		},
+		GetStatsAndResetDefinition: func(p *common.DiverClient) (Stats common.Stats, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				Stats, err = c.GetStatsAndReset()
+				return err
+			})
+			return Stats, err
+		},
+		PingDefinition: func(p *common.DiverClient) (RoundTrip time.Duration, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				RoundTrip, err = c.Ping()
+				return err
+			})
+			return RoundTrip, err
+		},
+		SelfTestDefinition: func(p *common.DiverClient) (Passed bool, Duration time.Duration, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				Passed, Duration, err = c.SelfTest()
+				return err
+			})
+			return Passed, Duration, err
+		},
+		GetCapabilitiesDefinition: func(p *common.DiverClient) (Commands []byte, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				Commands, err = c.GetCapabilities()
+				return err
+			})
+			return Commands, err
+		},
+		ListConnectionsDefinition: func(p *common.DiverClient) (Connections []common.ConnectionInfo, Error error) {
+			err := try(p, func(c *common.DiverClient) (err error) {
+				Connections, err = c.ListConnections()
+				return err
+			})
+			return Connections, err
+		},
+		CloseDefinition: func(p *common.DiverClient) error {
+			var err error
+			for _, c := range clients {
+				if closeErr := c.Close(); closeErr != nil {
+					err = closeErr
+				}
+			}
+			return err
+		},
+	}
+}
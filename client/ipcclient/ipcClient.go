@@ -1,9 +1,16 @@
 package ipcclient
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/iotaledger/giota"
@@ -12,30 +19,63 @@ import (
 	"github.com/sigurn/crc8"
 )
 
+// maxResendAttempts is the number of IpcCmdResend requests after a POW response failed the checksum check
+const maxResendAttempts = 2
+
 var (
 	IpcClient = &common.ClientAPI{
-		PowFuncDefinition:    PowFunc,
-		GetPowInfoDefinition: GetPowInfo,
+		PowFuncDefinition:             PowFunc,
+		PowFuncFullDefinition:         PowFuncFull,
+		PowFuncTimedDefinition:        PowFuncTimed,
+		PowFuncHighPriorityDefinition: PowFuncHighPriority,
+		PowFuncDryRunDefinition:       PowFuncDryRun,
+		PowFuncRawDefinition:          PowFuncRaw,
+		PowFuncContextDefinition:      PowFuncContext,
+		GetPowInfoDefinition:          GetPowInfo,
+		GetVersionsDefinition:         GetVersions,
+		GetServerInfoDefinition:       GetServerInfo,
+		GetStatsDefinition:            GetStats,
+		GetStatsAndResetDefinition:    GetStatsAndReset,
+		PingDefinition:                Ping,
+		SelfTestDefinition:            SelfTest,
+		GetCapabilitiesDefinition:     GetCapabilities,
+		ListConnectionsDefinition:     ListConnections,
 	}
 )
 
 func getServerVersion(p *common.DiverClient) (serverVersion string, Error error) {
-	serverVersionBytes, err := sendIpcFrameV1ToServer(p, ipccommon.IpcCmdGetServerVersion, nil)
+	serverVersionBytes, err := sendIpcFrameToServer(p, ipccommon.IpcCmdGetServerVersion, nil)
 	return string(serverVersionBytes), err
 }
 
 func getPowType(p *common.DiverClient) (powType string, Error error) {
-	powTypeBytes, err := sendIpcFrameV1ToServer(p, ipccommon.IpcCmdGetPowType, nil)
+	powTypeBytes, err := sendIpcFrameToServer(p, ipccommon.IpcCmdGetPowType, nil)
 	return string(powTypeBytes), err
 }
 
 func getPowVersion(p *common.DiverClient) (powVersion string, Error error) {
-	powVersionBytes, err := sendIpcFrameV1ToServer(p, ipccommon.IpcCmdGetPowVersion, nil)
+	powVersionBytes, err := sendIpcFrameToServer(p, ipccommon.IpcCmdGetPowVersion, nil)
 	return string(powVersionBytes), err
 }
 
-// GetPowInfo returns information about the diverDriver version, POW hardware type, and POW hardware version
+// GetPowInfo returns the versions of the diverDriver and the used POW implementation in a single request
+// If the server reports via IpcCmdGetCapabilities that it doesn't support IpcCmdGetPowInfo, the versions are requested one by one
 func GetPowInfo(p *common.DiverClient) (ServerVersion string, PowType string, PowVersion string, Error error) {
+	powInfo, err := sendIpcFrameToServer(p, ipccommon.IpcCmdGetPowInfo, nil)
+	if err == nil {
+		return ipccommon.DecodePowInfo(powInfo)
+	}
+
+	var serverErr *common.ErrServerError
+	if !errors.As(err, &serverErr) {
+		return "", "", "", err
+	}
+	if commands, capErr := GetCapabilities(p); capErr == nil && common.HasCapability(commands, ipccommon.IpcCmdGetPowInfo) {
+		// The command is supported, the error was caused by the request itself
+		return "", "", "", err
+	}
+
+	// Fallback for older servers
 	serverVersion, err := getServerVersion(p)
 	if err != nil {
 		return "", "", "", err
@@ -54,10 +94,148 @@ func GetPowInfo(p *common.DiverClient) (ServerVersion string, PowType string, Po
 	return serverVersion, powType, powVersion, nil
 }
 
+// GetVersions returns the versions of the diverDriver, the IPC protocol and the used POW implementation
+// If the server answers that it doesn't know IpcCmdGetVersions, the versions are requested one by one.
+// All other errors (e.g. a timeout) are returned, so an unresponsive server is not asked three more times.
+func GetVersions(p *common.DiverClient) (Versions common.Versions, Error error) {
+	versionsBytes, err := sendIpcFrameToServer(p, ipccommon.IpcCmdGetVersions, nil)
+	if err == nil {
+		var versions common.Versions
+		err = json.Unmarshal(versionsBytes, &versions)
+		return versions, err
+	}
+	if !common.IsUnknownCommand(err) {
+		return common.Versions{}, err
+	}
+
+	// Fallback for older servers
+	serverVersion, err := getServerVersion(p)
+	if err != nil {
+		return common.Versions{}, err
+	}
+
+	powType, err := getPowType(p)
+	if err != nil {
+		return common.Versions{}, err
+	}
+
+	powVersion, err := getPowVersion(p)
+	if err != nil {
+		return common.Versions{}, err
+	}
+
+	return common.Versions{ServerVersion: serverVersion, ProtocolVersion: ipccommon.FrameVersionV1, PowType: powType, PowVersion: powVersion}, nil
+}
+
+// GetServerInfo returns the version and the build of the diverDriver
+// If the server reports via IpcCmdGetCapabilities that it doesn't support IpcCmdGetServerInfo, only the version is requested
+func GetServerInfo(p *common.DiverClient) (ServerInfo common.ServerInfo, Error error) {
+	serverInfoBytes, err := sendIpcFrameToServer(p, ipccommon.IpcCmdGetServerInfo, nil)
+	if err == nil {
+		var serverInfo common.ServerInfo
+		err = json.Unmarshal(serverInfoBytes, &serverInfo)
+		return serverInfo, err
+	}
+
+	var serverErr *common.ErrServerError
+	if !errors.As(err, &serverErr) {
+		return common.ServerInfo{}, err
+	}
+	if commands, capErr := GetCapabilities(p); capErr == nil && common.HasCapability(commands, ipccommon.IpcCmdGetServerInfo) {
+		// The command is supported, the error was caused by the request itself
+		return common.ServerInfo{}, err
+	}
+
+	// Fallback for older servers
+	serverVersion, err := getServerVersion(p)
+	if err != nil {
+		return common.ServerInfo{}, err
+	}
+
+	return common.ServerInfo{Version: serverVersion}, nil
+}
+
+// GetStats returns the POW statistics of the diverDriver
+func GetStats(p *common.DiverClient) (Stats common.Stats, Error error) {
+	return getStats(p, nil)
+}
+
+// GetStatsAndReset returns the POW statistics of the diverDriver and sets its counters to zero
+func GetStatsAndReset(p *common.DiverClient) (Stats common.Stats, Error error) {
+	return getStats(p, []byte{ipccommon.StatsFlagReset})
+}
+
+func getStats(p *common.DiverClient, flags []byte) (Stats common.Stats, Error error) {
+	statsBytes, err := sendIpcFrameToServer(p, ipccommon.IpcCmdGetStats, flags)
+	if err != nil {
+		return common.Stats{}, err
+	}
+
+	var stats common.Stats
+	err = json.Unmarshal(statsBytes, &stats)
+	return stats, err
+}
+
+// GetCapabilities returns the IPC commands supported by the diverDriver
+func GetCapabilities(p *common.DiverClient) (Commands []byte, Error error) {
+	capabilities, err := sendIpcFrameToServer(p, ipccommon.IpcCmdGetCapabilities, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(capabilities) < 1 {
+		return nil, errors.New("Capabilities without frame version received")
+	}
+
+	// The first byte is the highest frame version of the server
+	return capabilities[1:], nil
+}
+
+// Ping checks if the diverDriver is alive without doing POW and returns the round trip time
+func Ping(p *common.DiverClient) (RoundTrip time.Duration, Error error) {
+	ts := time.Now()
+	response, err := sendIpcFrameToServer(p, ipccommon.IpcCmdPing, nil)
+	if err != nil {
+		return 0, err
+	}
+	roundTrip := time.Since(ts)
+
+	if !strings.HasPrefix(string(response), "pong") {
+		return 0, fmt.Errorf("Unexpected ping response: %s", response)
+	}
+	return roundTrip, nil
+}
+
+// SelfTest lets the diverDriver do the POW of common.SelfTestTransaction and verify the nonce
+func SelfTest(p *common.DiverClient) (Passed bool, Duration time.Duration, Error error) {
+	response, err := sendIpcFrameToServer(p, ipccommon.IpcCmdSelfTest, nil)
+	if err != nil {
+		return false, 0, err
+	}
+
+	passed, durationMs, err := ipccommon.DecodeSelfTestResponse(response)
+	if err != nil {
+		return false, 0, err
+	}
+	return passed, time.Duration(durationMs) * time.Millisecond, nil
+}
+
+// ListConnections returns the client connections of the diverDriver, including the connection of this client
+func ListConnections(p *common.DiverClient) (Connections []common.ConnectionInfo, Error error) {
+	connectionsBytes, err := sendIpcFrameToServer(p, ipccommon.IpcCmdListConnections, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var connections []common.ConnectionInfo
+	err = json.Unmarshal(connectionsBytes, &connections)
+	return connections, err
+}
+
 // PowFunc does the POW
 func PowFunc(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
-	if (minWeightMagnitude < 0) || (minWeightMagnitude > 243) {
-		return "", fmt.Errorf("minWeightMagnitude out of range [0-243]: %v", minWeightMagnitude)
+	if err := checkMinWeightMagnitude(p, minWeightMagnitude); err != nil {
+		return "", err
 	}
 
 	result, err := doPow(p, trytes, minWeightMagnitude)
@@ -68,81 +246,547 @@ func PowFunc(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int)
 	return result, err
 }
 
+// PowFuncFull does the POW like PowFunc and returns the transaction with the nonce received from the diverDriver
+func PowFuncFull(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (transaction giota.Trytes, Error error) {
+	nonce, err := PowFunc(p, trytes, minWeightMagnitude)
+	if err != nil {
+		return "", err
+	}
+
+	return common.SpliceNonce(trytes, nonce)
+}
+
+// PowFuncTimed does the POW like PowFunc and returns the duration of the POW measured by the diverDriver
+// The duration is only sent with frame version 2, so the request always uses frame version 2
+func PowFuncTimed(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, duration time.Duration, Error error) {
+	if err := checkMinWeightMagnitude(p, minWeightMagnitude); err != nil {
+		return "", 0, err
+	}
+
+	version := ipccommon.FrameVersionV2
+	data, err := (&ipccommon.PowRequest{MWM: minWeightMagnitude, Flags: powRequestFlags(p, ipccommon.PowFlagTimed), Backend: p.PowBackend, Trytes: trytes}).Encode(version)
+	if err != nil {
+		return "", 0, err
+	}
+
+	response, err := sendIpcFrameWithIDToServer(p, version, nextRequestID(p, version), ipccommon.IpcCmdPowFunc, data)
+	if err != nil {
+		return "", 0, err
+	}
+
+	durationMs, resultString, err := ipccommon.DecodeTimedPowResponse(response)
+	if err != nil {
+		return "", 0, err
+	}
+
+	result, err = giota.ToTrytes(resultString)
+	if err != nil {
+		return "", 0, err
+	}
+	return result, time.Duration(durationMs) * time.Millisecond, nil
+}
+
+// PowFuncHighPriority does the POW like PowFunc with the high priority flag
+// The flag is only sent with frame version 2, so the request always uses frame version 2
+func PowFuncHighPriority(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	if err := checkMinWeightMagnitude(p, minWeightMagnitude); err != nil {
+		return "", err
+	}
+
+	version := ipccommon.FrameVersionV2
+	return doPowWithFlags(p, version, nextRequestID(p, version), trytes, minWeightMagnitude, ipccommon.PowFlagHighPriority)
+}
+
+// PowFuncRaw does the POW like PowFunc without converting the trytes of the request and the nonce of the response
+// The trytes are always sent unpacked, PackedTrytes of the client is ignored.
+func PowFuncRaw(p *common.DiverClient, trytes []byte, minWeightMagnitude int) (result []byte, Error error) {
+	if err := checkMinWeightMagnitude(p, minWeightMagnitude); err != nil {
+		return nil, err
+	}
+
+	version := frameVersion(p)
+	if !ipccommon.IsSupportedFrameVersion(version) {
+		return nil, fmt.Errorf("Unsupported frame version! Version: %X", version)
+	}
+
+	// The request without trytes only contains the header, the trytes are appended as they are
+	header, err := (&ipccommon.PowRequest{MWM: minWeightMagnitude, Backend: p.PowBackend}).Encode(version)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, len(header)+len(trytes))
+	data = append(append(data, header...), trytes...)
+
+	return sendIpcFrameWithIDToServer(p, version, nextRequestID(p, version), ipccommon.IpcCmdPowFunc, data)
+}
+
+// PowFuncDryRun sends the request like PowFunc, but the diverDriver only validates it and answers with a placeholder nonce
+func PowFuncDryRun(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	if err := checkMinWeightMagnitude(p, minWeightMagnitude); err != nil {
+		return "", err
+	}
+
+	version := frameVersion(p)
+	data, err := (&ipccommon.PowRequest{MWM: minWeightMagnitude, Flags: powRequestFlags(p, 0), Backend: p.PowBackend, Trytes: trytes}).Encode(version)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := sendIpcFrameWithIDToServer(p, version, nextRequestID(p, version), ipccommon.IpcCmdPowFuncDryRun, data)
+	if err != nil {
+		return "", err
+	}
+
+	return giota.ToTrytes(string(response))
+}
+
+// PowFuncContext does the POW like PowFunc
+// If the context is cancelled before the result is received, the POW is cancelled on the diverDriver.
+// The diverDriver only cancels requests of the same connection, so the connection is kept open until the cancel is answered.
+func PowFuncContext(ctx context.Context, p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	if err := checkMinWeightMagnitude(p, minWeightMagnitude); err != nil {
+		return "", err
+	}
+
+	version := frameVersion(p)
+	if !ipccommon.IsSupportedFrameVersion(version) {
+		return "", fmt.Errorf("Unsupported frame version! Version: %X", version)
+	}
+	reqID := nextRequestID(p, version)
+
+	crc8Table, err := ipccommon.Crc8TableByName(p.Crc8)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := (&ipccommon.PowRequest{MWM: minWeightMagnitude, Flags: powRequestFlags(p, 0), Backend: p.PowBackend, Trytes: trytes}).Encode(version)
+	if err != nil {
+		return "", err
+	}
+	requestMsg, err := ipccommon.NewIpcMessage(version, reqID, ipccommon.IpcCmdPowFunc, data)
+	if err != nil {
+		return "", err
+	}
+	requestMsg.SetCrc8Table(crc8Table)
+
+	c, err := connect(p, p.ReadTimeOutMsFor(ipccommon.IpcCmdPowFunc))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	_, err = requestMsg.WriteTo(c)
+	if p.Tracer != nil {
+		p.Tracer.WriteDone(err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The frames are received in the background, so the cancel can be sent while the POW is running
+	type receivedFrame struct {
+		frame *ipccommon.IpcFrame
+		err   error
+	}
+	// A single reader for all frames, the answers of the POW request and of the cancel may arrive in the same read
+	reader := newFrameReader(c, p.MaxFrameLength, p.ReadBufferSize, crc8Table, p.Tracer)
+	frames := make(chan receivedFrame)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			frame, err := receiveFrame(reader, maxChunkedResponseLength(p), p.Tracer, p.OnNotification)
+			select {
+			case frames <- receivedFrame{frame: frame, err: err}:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case received := <-frames:
+		frame, err := received.frame, received.err
+		var checksumErr *common.ErrChecksumMismatch
+		if errors.As(err, &checksumErr) {
+			// The POW is already done, so the response is requested again instead of repeating the POW
+			if resentFrame, resendErr := resendResponse(p, version, reqID, data); resendErr == nil && resentFrame.Command == ipccommon.IpcCmdResponse {
+				frame, err = resentFrame, nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+
+		response, err := evaluateResponse(p, frame, version, reqID)
+		if err != nil {
+			return "", err
+		}
+		return giota.ToTrytes(string(response))
+
+	case <-ctx.Done():
+	}
+
+	cancelReqID := nextRequestID(p, version)
+	if err := cancelPow(c, version, cancelReqID, reqID, crc8Table); err != nil {
+		return "", fmt.Errorf("%v, POW could not be cancelled: %v", ctx.Err(), err)
+	}
+
+	// The answer of the POW request itself ("POW cancelled" or a result that was already on its way) is skipped
+	for received := range frames {
+		if received.err != nil {
+			return "", fmt.Errorf("%v, POW could not be cancelled: %v", ctx.Err(), received.err)
+		}
+		if received.frame.ReqID != cancelReqID {
+			continue
+		}
+		if _, err := evaluateResponse(p, received.frame, version, cancelReqID); err != nil {
+			return "", fmt.Errorf("%v, POW could not be cancelled: %v", ctx.Err(), err)
+		}
+		break
+	}
+	return "", ctx.Err()
+}
+
+// cancelPow sends the cancel of the running POW request with the given ReqID on the connection of the request
+func cancelPow(c net.Conn, version byte, cancelReqID uint16, reqID uint16, crc8Table *crc8.Table) error {
+	data := []byte{byte(reqID)}
+	if version != ipccommon.FrameVersionV1 {
+		data = []byte{byte(reqID >> 8), byte(reqID)}
+	}
+
+	cancelMsg, err := ipccommon.NewIpcMessage(version, cancelReqID, ipccommon.IpcCmdCancelPow, data)
+	if err != nil {
+		return err
+	}
+	cancelMsg.SetCrc8Table(crc8Table)
+
+	_, err = cancelMsg.WriteTo(c)
+	return err
+}
+
+// checkMinWeightMagnitude checks the MWM against the maximum of the client
+func checkMinWeightMagnitude(p *common.DiverClient, minWeightMagnitude int) error {
+	maxMinWeightMagnitude := p.MaxMinWeightMagnitude
+	if maxMinWeightMagnitude <= 0 {
+		maxMinWeightMagnitude = common.DefaultMaxMinWeightMagnitude
+	}
+
+	if (minWeightMagnitude < 0) || (minWeightMagnitude > maxMinWeightMagnitude) {
+		return fmt.Errorf("minWeightMagnitude out of range [0-%d]: %v", maxMinWeightMagnitude, minWeightMagnitude)
+	}
+	return nil
+}
+
 func doPow(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (giota.Trytes, error) {
-	data := []byte{byte(minWeightMagnitude)}
-	data = append(data, []byte(string(trytes))...)
+	version := frameVersion(p)
+	if !ipccommon.IsSupportedFrameVersion(version) {
+		return "", fmt.Errorf("Unsupported frame version! Version: %X", version)
+	}
+
+	return doPowWithID(p, version, nextRequestID(p, version), trytes, minWeightMagnitude)
+}
+
+func doPowWithID(p *common.DiverClient, version byte, reqID uint16, trytes giota.Trytes, minWeightMagnitude int) (giota.Trytes, error) {
+	return doPowWithFlags(p, version, reqID, trytes, minWeightMagnitude, 0)
+}
+
+func doPowWithFlags(p *common.DiverClient, version byte, reqID uint16, trytes giota.Trytes, minWeightMagnitude int, flags byte) (giota.Trytes, error) {
+	data, err := (&ipccommon.PowRequest{MWM: minWeightMagnitude, Flags: powRequestFlags(p, flags), Backend: p.PowBackend, Trytes: trytes}).Encode(version)
+	if err != nil {
+		return "", err
+	}
 
-	response, err := sendIpcFrameV1ToServer(p, ipccommon.IpcCmdPowFunc, data)
-	responseString := string(response)
+	response, err := sendIpcFrameWithIDToServer(p, version, reqID, ipccommon.IpcCmdPowFunc, data)
 	if err != nil {
 		return "", err
 	}
 
-	return giota.ToTrytes(responseString)
+	return giota.ToTrytes(string(response))
 }
 
-// sendToServer sends an IpcMessage struct to the diverDriver
-// It returns the response bytes or an error
-func sendToServer(p *common.DiverClient, requestMsg *ipccommon.IpcMessage) (response []byte, Error error) {
-	request, err := requestMsg.ToBytes()
+// powRequestFlags adds the flags selected by the options of the client to the flags of a POW request
+func powRequestFlags(p *common.DiverClient, flags byte) byte {
+	if p.PackedTrytes {
+		flags |= ipccommon.PowFlagPackedTrytes
+	}
+	return flags
+}
+
+// sendToServer sends an IPC message with the given IPC_CMD to the diverDriver
+// The response is awaited for the read timeout of the command (see common.DiverClient.ReadTimeOutMsFor)
+// Failed connection attempts are repeated according to the RetryPolicy of the client (see dialWithRetry)
+// It returns the received frame or an error
+func sendToServer(p *common.DiverClient, command byte, requestMsg ipccommon.Message) (response *ipccommon.IpcFrame, Error error) {
+	crc8Table, err := ipccommon.Crc8TableByName(p.Crc8)
 	if err != nil {
 		return nil, err
 	}
+	requestMsg.SetCrc8Table(crc8Table)
+
+	return sendRequestToServer(p, requestMsg, p.ReadTimeOutMsFor(command))
+}
+
+// dialWithRetry connects to the diverDriver and repeats failed dials according to the RetryPolicy of the client
+// Only the dial is repeated: once a request was written, a timeout or an IpcCmdError is returned to the caller,
+// so the diverDriver never does the POW of a request twice and errors of the request are not repeated in vain.
+func dialWithRetry(p *common.DiverClient) (net.Conn, error) {
+	attempts := p.RetryPolicy.Attempts()
+	for attempt := 1; ; attempt++ {
+		c, err := dial(p)
+		if p.Tracer != nil {
+			p.Tracer.DialDone(err)
+		}
+		if err == nil || attempt >= attempts || err == common.ErrClientClosed {
+			return c, err
+		}
+
+		time.Sleep(p.RetryPolicy.Backoff(attempt))
+	}
+}
+
+// dial connects to the diverDriver via Unix socket, Windows named pipe, TCP or TLS depending on the path
+// If the client has a DialFunc, the connection is created by the DialFunc instead
+func dial(p *common.DiverClient) (net.Conn, error) {
+	if p.IsClosed() {
+		return nil, common.ErrClientClosed
+	}
+
+	if p.DialFunc != nil {
+		return p.DialFunc(context.Background())
+	}
 
-	c, err := net.Dial("unix", p.DiverDriverPath)
+	// The keepalive only applies to TCP, a Unix socket fails as soon as the diverDriver is gone
+	dialer := &net.Dialer{KeepAlive: p.KeepAlive}
+
+	network, address := common.ParseDiverDriverPath(p.DiverDriverPath)
+	switch network {
+	case common.NetworkTLS:
+		return tls.DialWithDialer(dialer, common.NetworkTCP, address, p.TLSConfig)
+	case common.NetworkPipe:
+		return dialPipe(address)
+	case common.NetworkUnix:
+		if err := common.CheckUnixSocketPath(address); err != nil {
+			return nil, err
+		}
+	}
+	return dialer.Dial(network, address)
+}
+
+// connect connects to the diverDriver (see dialWithRetry) and sets the timeouts of the connection (readTimeOutMs 0 = no read timeout)
+// If the client has a MaxFrameLength, the server is told to never send longer frames on this connection
+// If the client has an AuthKey, the connection is authenticated before it is returned
+func connect(p *common.DiverClient, readTimeOutMs int) (net.Conn, error) {
+	if p.MaxFrameLength > 0 {
+		if minFrameLength := ipccommon.MinPowResponseFrameLength(frameVersion(p)); p.MaxFrameLength < minFrameLength {
+			return nil, fmt.Errorf("MaxFrameLength too small for a POW response! Length: %d, Required: %d", p.MaxFrameLength, minFrameLength)
+		}
+	}
+
+	c, err := dialWithRetry(p)
 	if err != nil {
 		return nil, err
 	}
-	defer c.Close()
 
 	if p.WriteTimeOutMs != 0 {
 		err = c.SetWriteDeadline(time.Now().Add(time.Millisecond * time.Duration(p.WriteTimeOutMs)))
 		if err != nil {
+			c.Close()
 			return nil, err
 		}
 	}
 
-	if p.ReadTimeOutMs != 0 {
-		err = c.SetReadDeadline(time.Now().Add(time.Millisecond * time.Duration(p.ReadTimeOutMs)))
+	if readTimeOutMs != 0 {
+		err = c.SetReadDeadline(time.Now().Add(time.Millisecond * time.Duration(readTimeOutMs)))
 		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if p.MaxFrameLength > 0 {
+		maxFrameLength := make([]byte, 4)
+		binary.BigEndian.PutUint32(maxFrameLength, uint32(p.MaxFrameLength))
+		if p.ChunkedResponses {
+			// Older servers reject the client flags, so they are only sent if needed
+			maxFrameLength = append(maxFrameLength, ipccommon.ClientFlagChunkedResponses)
+		}
+		if _, err = exchangeIpcFrame(c, p, frameVersion(p), ipccommon.IpcCmdGetCapabilities, maxFrameLength); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if p.AuthKey != "" {
+		if err = authenticate(c, p); err != nil {
+			c.Close()
 			return nil, err
 		}
 	}
 
-	_, err = c.Write(request)
+	return c, nil
+}
+
+// authenticate performs the IpcCmdAuth handshake on the connection
+func authenticate(c net.Conn, p *common.DiverClient) error {
+	version := frameVersion(p)
+
+	nonce, err := exchangeIpcFrame(c, p, version, ipccommon.IpcCmdAuth, nil)
+	if err != nil {
+		return err
+	}
+	if len(nonce) == 0 {
+		// Authentication is disabled on the server
+		return nil
+	}
+
+	_, err = exchangeIpcFrame(c, p, version, ipccommon.IpcCmdAuth, common.AuthHMAC([]byte(p.AuthKey), nonce))
+	return err
+}
+
+// exchangeIpcFrame sends an IPC frame on an open connection and returns the evaluated answer of the server
+func exchangeIpcFrame(c net.Conn, p *common.DiverClient, version byte, command byte, data []byte) (response []byte, Error error) {
+	reqID := nextRequestID(p, version)
+
+	requestMsg, err := ipccommon.NewIpcMessage(version, reqID, command, data)
+	if err != nil {
+		return nil, err
+	}
+
+	crc8Table, err := ipccommon.Crc8TableByName(p.Crc8)
+	if err != nil {
+		return nil, err
+	}
+	requestMsg.SetCrc8Table(crc8Table)
+
+	_, err = requestMsg.WriteTo(c)
+	if p.Tracer != nil {
+		p.Tracer.WriteDone(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := receive(c, p.MaxFrameLength, p.ReadBufferSize, maxChunkedResponseLength(p), crc8Table, p.Tracer, p.OnNotification)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluateResponse(p, frame, version, reqID)
+}
+
+// sendRequestToServer sends the request message to the diverDriver using a new connection
+// It returns the frame received within readTimeOutMs or an error
+func sendRequestToServer(p *common.DiverClient, requestMsg ipccommon.Message, readTimeOutMs int) (response *ipccommon.IpcFrame, Error error) {
+	crc8Table, err := ipccommon.Crc8TableByName(p.Crc8)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := connect(p, readTimeOutMs)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	_, err = requestMsg.WriteTo(c)
+	if p.Tracer != nil {
+		p.Tracer.WriteDone(err)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	response, err = receive(c, p.ReadTimeOutMs)
+	response, err = receive(c, p.MaxFrameLength, p.ReadBufferSize, maxChunkedResponseLength(p), crc8Table, p.Tracer, p.OnNotification)
 	return response, err
 }
 
-// sendIpcFrameV1ToServer creates an IpcFrameV1 and calls sendToServer
-// The answer of the server is evaluated and returned to the caller
-func sendIpcFrameV1ToServer(p *common.DiverClient, command byte, data []byte) (response []byte, Error error) {
+// frameVersion returns the IPC frame version used for requests of the client
+func frameVersion(p *common.DiverClient) byte {
+	if p.FrameVersion == 0 {
+		return ipccommon.FrameVersionV1
+	}
+	return p.FrameVersion
+}
+
+// nextRequestID returns the next request ID of the client
+// The ID wraps around at the maximum REQ_ID of the frame version and never is 0
+func nextRequestID(p *common.DiverClient, version byte) uint16 {
 	p.RequestIdLock.Lock()
+	defer p.RequestIdLock.Unlock()
+
 	p.RequestId++
-	reqID := p.RequestId
-	p.RequestIdLock.Unlock()
+	if version == ipccommon.FrameVersionV1 && p.RequestId > 0xFF {
+		p.RequestId = 1
+	}
+	if p.RequestId == 0 {
+		p.RequestId = 1
+	}
+	return p.RequestId
+}
+
+// sendIpcFrameToServer creates an IPC frame with the frame version of the client and calls sendToServer
+// The answer of the server is evaluated and returned to the caller
+func sendIpcFrameToServer(p *common.DiverClient, command byte, data []byte) (response []byte, Error error) {
+	version := frameVersion(p)
+	if !ipccommon.IsSupportedFrameVersion(version) {
+		return nil, fmt.Errorf("Unsupported frame version! Version: %X", version)
+	}
+
+	return sendIpcFrameWithIDToServer(p, version, nextRequestID(p, version), command, data)
+}
 
-	requestMsg, err := ipccommon.NewIpcMessageV1(reqID, command, data)
+// sendIpcFrameWithIDToServer creates an IPC frame with the given frame version and ReqID and calls sendToServer
+// The answer of the server is evaluated and returned to the caller
+func sendIpcFrameWithIDToServer(p *common.DiverClient, version byte, reqID uint16, command byte, data []byte) (response []byte, Error error) {
+	requestMsg, err := ipccommon.NewIpcMessage(version, reqID, command, data)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := sendToServer(p, requestMsg)
+	frame, err := sendToServer(p, command, requestMsg)
+	if err != nil && command == ipccommon.IpcCmdPowFunc {
+		var checksumErr *common.ErrChecksumMismatch
+		if errors.As(err, &checksumErr) {
+			// The POW is already done, so the response is requested again instead of repeating the POW
+			if resentFrame, resendErr := resendResponse(p, version, reqID, data); resendErr == nil && resentFrame.Command == ipccommon.IpcCmdResponse {
+				frame, err = resentFrame, nil
+			}
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	frame, err := ipccommon.BytesToIpcFrameV1(resp)
+	return evaluateResponse(p, frame, version, reqID)
+}
+
+// resendResponse requests the response of the POW request with the given frame version, ReqID and DATA again (IpcCmdResend)
+// The request is repeated up to maxResendAttempts times, as long as the response fails the checksum check
+func resendResponse(p *common.DiverClient, version byte, reqID uint16, requestData []byte) (response *ipccommon.IpcFrame, Error error) {
+	resendMsg, err := ipccommon.NewIpcMessage(version, reqID, ipccommon.IpcCmdResend, ipccommon.ResendDigest(requestData))
 	if err != nil {
 		return nil, err
 	}
 
-	if frame.ReqID != reqID {
-		return nil, fmt.Errorf("Wrong ReqID! ReqID: %X, Expected: %X", frame.ReqID, reqID)
+	for attempt := 1; ; attempt++ {
+		response, err = sendToServer(p, ipccommon.IpcCmdResend, resendMsg)
+		var checksumErr *common.ErrChecksumMismatch
+		if err == nil || attempt >= maxResendAttempts || !errors.As(err, &checksumErr) {
+			return response, err
+		}
+	}
+}
+
+// evaluateResponse checks that the frame is the answer to the request with the given frame version and ReqID
+// The ReqID is not compared if the client has SkipReqIDCheck set
+// It returns the DATA of a response or the error sent by the server, classified by its ERROR_CODE for FRAME_VERSION==0x02
+func evaluateResponse(p *common.DiverClient, frame *ipccommon.IpcFrame, version byte, reqID uint16) (response []byte, Error error) {
+	if frame.Version != version || (frame.ReqID != reqID && !p.SkipReqIDCheck) {
+		return nil, &common.ErrReqIDMismatch{ReqID: frame.ReqID, Expected: reqID}
 	}
 
 	switch frame.Command {
@@ -151,7 +795,8 @@ func sendIpcFrameV1ToServer(p *common.DiverClient, command byte, data []byte) (r
 		return frame.Data, nil
 
 	case ipccommon.IpcCmdError:
-		return nil, fmt.Errorf(string(frame.Data))
+		code, msg := ipccommon.DecodeErrorData(frame.Version, frame.Data)
+		return nil, &common.ErrServerError{Code: code, Msg: msg}
 
 	default:
 		//
@@ -160,83 +805,110 @@ func sendIpcFrameV1ToServer(p *common.DiverClient, command byte, data []byte) (r
 	}
 }
 
-func receive(c net.Conn, timeoutMs int) (response []byte, Error error) {
-	frameState := ipccommon.FrameStateSearchEnq
-	frameLength := 0
-	var frameData []byte
+// receive reads a single frame from the connection and returns it
+// The response has to arrive before the read deadline of the connection, otherwise ErrReceiveTimeout is returned
+// Frames that announce more than maxFrameLength bytes (0 = maximum length of the frame version) are rejected before any data is buffered
+// The connection is read in chunks of bufferSize bytes (0 = ipccommon.DefaultReadBufferSize)
+// The CRC8 of the frame is checked with crc8Table
+// The tracer is informed about the first received byte and the complete frame (nil = no tracing)
+// Notifications of the server are passed to onNotification and skipped (nil = ignored)
+// The frames of a chunked response (see ipccommon.IpcCmdFlagMoreFollows) are returned as a single frame with at most
+// maxChunkedLength bytes of DATA (0 = chunked responses are rejected, see maxChunkedResponseLength)
+func receive(c net.Conn, maxFrameLength int, bufferSize int, maxChunkedLength int, crc8Table *crc8.Table, tracer common.Tracer, onNotification func(string)) (response *ipccommon.IpcFrame, Error error) {
+	return receiveFrame(newFrameReader(c, maxFrameLength, bufferSize, crc8Table, tracer), maxChunkedLength, tracer, onNotification)
+}
 
-	ts := time.Now()
-	td := time.Duration(timeoutMs) * time.Millisecond
+// maxChunkedResponseLength returns the maximum length of the DATA of a chunked response of the client
+// It is 0 if the client didn't request chunked responses, so the diverDriver can't send them anyway.
+func maxChunkedResponseLength(p *common.DiverClient) int {
+	if !p.ChunkedResponses {
+		return 0
+	}
+	if p.MaxResponseLength > 0 {
+		return p.MaxResponseLength
+	}
+	return ipccommon.DefaultMaxResponseLength
+}
+
+// newFrameReader creates the reader of the frames of the connection for receiveFrame
+// Bytes of following frames that were received together with a frame are kept by the reader,
+// so it has to be used for all further frames of the connection.
+func newFrameReader(c net.Conn, maxFrameLength int, bufferSize int, crc8Table *crc8.Table, tracer common.Tracer) *ipccommon.FrameReader {
+	var r io.Reader = c
+	if tracer != nil {
+		r = &firstByteReader{reader: c, tracer: tracer}
+	}
+	return ipccommon.NewFrameReader(r, bufferSize, maxFrameLength, crc8Table)
+}
+
+// receiveFrame reads the next frame like receive from a reader created by newFrameReader
+func receiveFrame(reader *ipccommon.FrameReader, maxChunkedLength int, tracer common.Tracer, onNotification func(string)) (response *ipccommon.IpcFrame, Error error) {
+	// Received frames of a chunked response, nil until the first frame with IpcCmdFlagMoreFollows
+	var chunked *ipccommon.IpcFrame
 
 	for {
-		if time.Since(ts) > td {
-			return nil, errors.New("Receive timeout")
-		}
+		frame, err := reader.ReadFrame()
+		if err == nil {
+			if frame.Command == ipccommon.IpcCmdNotification {
+				if onNotification != nil {
+					onNotification(string(frame.Data))
+				}
+				continue
+			}
 
-		buf := make([]byte, 3072) // ((8019 is the TransactionTrinarySize) / 3) + Overhead) => 3072
-		bufLength, err := c.Read(buf)
-		if err != nil {
-			continue
-		}
+			if chunked != nil && (frame.Version != chunked.Version || frame.ReqID != chunked.ReqID) {
+				return frameComplete(tracer, nil, &common.ErrReqIDMismatch{ReqID: frame.ReqID, Expected: chunked.ReqID})
+			}
 
-		bufferIdx := -1
-		for {
-			bufferIdx++
-
-			if bufLength > bufferIdx {
-				switch frameState {
-
-				case ipccommon.FrameStateSearchEnq:
-					if buf[bufferIdx] == 0x05 {
-						// Init variables for new message
-						frameLength = -1
-						frameData = nil
-						frameState = ipccommon.FrameStateSearchVersion
-					}
-
-				case ipccommon.FrameStateSearchVersion:
-					if buf[bufferIdx] == 0x01 {
-						frameState = ipccommon.FrameStateSearchLength
-					} else {
-						frameState = ipccommon.FrameStateSearchEnq
-					}
-
-				case ipccommon.FrameStateSearchLength:
-					if frameLength == -1 {
-						// Receive first byte
-						frameLength = int(buf[bufferIdx]) << 8
-					} else {
-						// Receive second byte and go on
-						frameLength |= int(buf[bufferIdx])
-						frameState = ipccommon.FrameStateSearchData
-					}
-
-				case ipccommon.FrameStateSearchData:
-					missingByteCount := frameLength - len(frameData)
-					if (bufLength - bufferIdx) >= missingByteCount {
-						// Frame completely received
-						frameData = append(frameData, buf[bufferIdx:(bufferIdx+missingByteCount)]...)
-						bufferIdx += missingByteCount - 1
-						frameState = ipccommon.FrameStateSearchCRC
-					} else {
-						// Frame not completed in this read => Copy the remaining bytes
-						frameData = append(frameData, buf[bufferIdx:bufLength]...)
-						bufferIdx = bufLength
-					}
-
-				case ipccommon.FrameStateSearchCRC:
-					crc := crc8.Checksum(frameData, ipccommon.Crc8Table)
-					if buf[bufferIdx] != crc {
-						return nil, fmt.Errorf("Wrong Checksum! CRC: %X, Expected: %X", crc, buf[bufferIdx])
-					}
-
-					return frameData, nil
+			if frame.Command&ipccommon.IpcCmdFlagMoreFollows != 0 && chunked == nil {
+				if maxChunkedLength == 0 {
+					return frameComplete(tracer, nil, common.ErrChunkedResponseNotRequested)
+				}
+				chunked = &ipccommon.IpcFrame{Version: frame.Version, ReqID: frame.ReqID}
+			}
 
+			if chunked != nil {
+				// The size is checked before the DATA is buffered, so a server can't grow the response without limit
+				if length := len(chunked.Data) + len(frame.Data); length > maxChunkedLength {
+					return frameComplete(tracer, nil, &common.ErrResponseTooLong{Length: length, Allowed: maxChunkedLength})
+				}
+				chunked.Data = append(chunked.Data, frame.Data...)
+				if frame.Command&ipccommon.IpcCmdFlagMoreFollows != 0 {
+					continue
 				}
-			} else {
-				// Received Buffer completely handled, break the loop to receive the next message
-				break
+				frame.Data = chunked.Data
 			}
+			return frameComplete(tracer, frame, nil)
 		}
+
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return frameComplete(tracer, nil, common.ErrReceiveTimeout)
+		}
+		// Frame errors and read errors like a connection closed by the diverDriver can't be recovered by reading again
+		return frameComplete(tracer, nil, err)
+	}
+}
+
+// frameComplete informs the tracer that receive returns (nil = no tracing)
+func frameComplete(tracer common.Tracer, frame *ipccommon.IpcFrame, err error) (*ipccommon.IpcFrame, error) {
+	if tracer != nil {
+		tracer.FrameComplete(err)
+	}
+	return frame, err
+}
+
+// firstByteReader informs the tracer when the first byte was read
+type firstByteReader struct {
+	reader   io.Reader
+	tracer   common.Tracer
+	received bool
+}
+
+func (r *firstByteReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	if n > 0 && !r.received {
+		r.received = true
+		r.tracer.FirstByte()
 	}
+	return n, err
 }
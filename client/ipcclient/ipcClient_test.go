@@ -0,0 +1,1308 @@
+package ipcclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+	"github.com/muxxer/diverdriver/server/ipc"
+	"github.com/spf13/viper"
+)
+
+// startTestServer starts a diverDriver on a temporary Unix socket and returns a client connected to it
+func startTestServer(t testing.TB, powType string, powVersion string) *common.DiverClient {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	serveTestServer(t, path, powType, powVersion)
+
+	return &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+}
+
+// serveTestServer starts a diverDriver on the given Unix socket path
+func serveTestServer(t testing.TB, path string, powType string, powVersion string) {
+	t.Helper()
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	// The tests use short trytes instead of whole transactions
+	config.Set("pow.validateTrytesLength", false)
+	serveTestServerWithConfig(t, path, config, powType, powVersion)
+}
+
+// serveTestServerWithConfig starts a diverDriver with the given config on the Unix socket path
+func serveTestServerWithConfig(t testing.TB, path string, config *viper.Viper, powType string, powVersion string) {
+	t.Helper()
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ipcserver.HandleClientConnection(c, config, powType, powVersion)
+		}
+	}()
+}
+
+func newResponse(t *testing.T, reqID byte, data []byte) []byte {
+	t.Helper()
+
+	msg, err := ipccommon.NewIpcMessageV1(reqID, ipccommon.IpcCmdResponse, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := msg.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestReceivePartialReads(t *testing.T) {
+	response := newResponse(t, 3, []byte("ABCDEFGHI"))
+
+	for _, chunkSize := range []int{1, 2, 4, len(response) - 1, len(response)} {
+		client, server := net.Pipe()
+
+		go func(chunkSize int) {
+			for i := 0; i < len(response); i += chunkSize {
+				end := i + chunkSize
+				if end > len(response) {
+					end = len(response)
+				}
+				if _, err := server.Write(response[i:end]); err != nil {
+					return
+				}
+			}
+		}(chunkSize)
+
+		frame, err := receive(client, ipccommon.MaxFrameLengthV1, 0, 0, ipccommon.Crc8Table, nil, nil)
+		if err != nil {
+			t.Fatalf("Chunk size %d: %v", chunkSize, err)
+		}
+
+		if frame.ReqID != 3 || !bytes.Equal(frame.Data, []byte("ABCDEFGHI")) {
+			t.Errorf("Chunk size %d: unexpected frame %+v", chunkSize, frame)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestReceiveWrongChecksum(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	response := newResponse(t, 3, []byte("ABCDEFGHI"))
+	response[len(response)-1]++
+	go server.Write(response)
+
+	_, err := receive(client, ipccommon.MaxFrameLengthV1, 0, 0, ipccommon.Crc8Table, nil, nil)
+	var checksumErr *common.ErrChecksumMismatch
+	if !errors.As(err, &checksumErr) {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+}
+
+func TestGetVersions(t *testing.T) {
+	p := startTestServer(t, "TestPow", "1.2.3")
+
+	versions, err := p.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := common.Versions{ServerVersion: common.DiverDriverVersion, ProtocolVersion: ipccommon.MaxFrameVersion, PowType: "TestPow", PowVersion: "1.2.3"}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("Unexpected versions %+v, expected %+v", versions, expected)
+	}
+}
+
+func TestGetVersionsPowFeatures(t *testing.T) {
+	powFunc := func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	}
+	ipcserver.SetPowFuncPoolWithDescriptor([]giota.PowFunc{powFunc}, ipcserver.PowDescriptor{Type: "TestPow", Version: "1.2.3", Features: []string{"crc", "batch"}})
+	defer ipcserver.SetPowFuncPoolWithDescriptor([]giota.PowFunc{nil}, ipcserver.PowDescriptor{})
+
+	p := startTestServer(t, "TestPow", "1.2.3")
+
+	versions, err := p.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(versions.PowFeatures, []string{"crc", "batch"}) {
+		t.Errorf("Unexpected features %v", versions.PowFeatures)
+	}
+	if !versions.HasPowFeature("batch") || versions.HasPowFeature("cancel") {
+		t.Errorf("HasPowFeature doesn't match the features %v", versions.PowFeatures)
+	}
+}
+
+func TestGetCapabilities(t *testing.T) {
+	p := startTestServer(t, "TestPow", "1.0")
+
+	commands, err := p.GetCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, command := range []byte{ipccommon.IpcCmdPowFunc, ipccommon.IpcCmdPing, ipccommon.IpcCmdGetCapabilities} {
+		if !common.HasCapability(commands, command) {
+			t.Errorf("Command %X missing in capabilities %v", command, commands)
+		}
+	}
+	if common.HasCapability(commands, ipccommon.IpcCmdResponse) {
+		t.Errorf("Capabilities contain a S => C command: %v", commands)
+	}
+}
+
+func TestMaxFrameLengthNegotiation(t *testing.T) {
+	p := startTestServer(t, "TestPow", "1.0")
+
+	p.MaxFrameLength = 100
+	if _, err := p.Ping(); err == nil {
+		t.Error("Expected an error for a maximum frame length smaller than a POW response")
+	}
+
+	p.MaxFrameLength = ipccommon.MinPowResponseFrameLength(ipccommon.FrameVersionV1)
+	if _, err := p.Ping(); err != nil {
+		t.Errorf("Request with negotiated maximum frame length failed: %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	p := startTestServer(t, "TestPow", "1.0")
+
+	roundTrip, err := p.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roundTrip <= 0 {
+		t.Errorf("Unexpected round trip time %v", roundTrip)
+	}
+}
+
+func TestReceiveFrameTooLong(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// Header of a frame that announces 1000 bytes of FRAME_DATA
+	go server.Write([]byte{ipccommon.FrameStartByte, ipccommon.FrameVersionV1, 0x03, 0xE8, 0x00, 0x00})
+
+	if _, err := receive(client, 100, 0, 0, ipccommon.Crc8Table, nil, nil); err == nil {
+		t.Error("Expected an error for a frame exceeding the maximum frame length")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+
+	if _, err := p.Versions(); err == nil {
+		t.Fatal("Expected an error without a running server")
+	}
+
+	// The server comes back within the retry window
+	time.AfterFunc(100*time.Millisecond, func() { serveTestServer(t, path, "TestPow", "1.0") })
+
+	p.RetryPolicy = common.RetryPolicy{MaxAttempts: 10, InitialBackoff: 20 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+	if _, err := p.Versions(); err != nil {
+		t.Errorf("Request failed despite retries: %v", err)
+	}
+}
+
+func TestRetryPolicyRequestNotRepeated(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			// The second request waits until the client timed out
+			<-release
+		}
+		return "", errors.New("device not responding")
+	})
+	defer ipcserver.SetPowFunc(nil)
+	defer close(release)
+
+	p := startTestServer(t, "TestPow", "1.0")
+	p.RetryPolicy = common.RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}
+
+	// An error of the server fails the same way every time
+	if _, err := p.PowFunc("ABC9", 14); err == nil || err.Error() != "device not responding" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("The request failed by the server was sent %d times", n)
+	}
+
+	// After a timeout the server may still do the POW, so the request is not sent again
+	p.ReadTimeOutMs = 100
+	if _, err := p.PowFunc("ABC9", 14); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("The timed out request was sent %d times", n-1)
+	}
+}
+
+func TestConcurrentRequestsFrameVersionV2(t *testing.T) {
+	// Echo the request, so every response can be assigned to its request
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+	p.FrameVersion = ipccommon.FrameVersionV2
+	p.ReadTimeOutMs = 10000
+	p.RequestId = 0xFFF0 // Let the request IDs wrap around during the test
+
+	const goroutines = 20
+	const requestsPerGoroutine = 20 // More requests than fit into the 8 bit REQ_ID of frame version 1
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*requestsPerGoroutine)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < requestsPerGoroutine; i++ {
+				trytes := giota.Trytes(fmt.Sprintf("%c%c9ABC", 'A'+g, 'A'+i))
+				result, err := p.PowFunc(trytes, 14)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if result != trytes {
+					errs <- fmt.Errorf("Unexpected response %v, expected %v", result, trytes)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestPowFuncContextCancelAnswersInOneRead(t *testing.T) {
+	requestReceived := make(chan struct{})
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: "/nonexistent/diverDriver.sock", WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	p.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+
+			reader := ipccommon.NewFrameReader(server, 0, 0, nil)
+			request, err := reader.ReadFrame()
+			if err != nil {
+				return
+			}
+			close(requestReceived)
+			cancel, err := reader.ReadFrame()
+			if err != nil {
+				return
+			}
+
+			// The answers of the POW request and of the cancel are written at once, so the client receives both in a single read
+			powMsg, _ := ipccommon.NewIpcMessage(request.Version, request.ReqID, ipccommon.IpcCmdError, []byte("POW cancelled"))
+			cancelMsg, _ := ipccommon.NewIpcMessage(cancel.Version, cancel.ReqID, ipccommon.IpcCmdResponse, nil)
+			powBytes, _ := powMsg.ToBytes()
+			cancelBytes, _ := cancelMsg.ToBytes()
+			server.Write(append(powBytes, cancelBytes...))
+			ioutil.ReadAll(server)
+		}()
+		return client, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requestReceived
+		cancel()
+	}()
+
+	if _, err := p.PowFuncContext(ctx, "ABC9", 14); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestPowFuncContextCancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	ipcserver.SetCancellablePowFunc(func(ctx context.Context, trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return "", ctx.Err()
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := p.PowFuncContext(ctx, "ABC9", 14); err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("POW was not cancelled on the server")
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and its key to the directory
+func writeTestCertificate(t *testing.T, dir string) (certFile string, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "diverDriver"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, cert := writeTestCertificate(t, t.TempDir())
+
+	tlsConfig, err := ipcserver.NewTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := ipcserver.Listen("tls://127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	server := ipcserver.NewServer(ln, config, "TestPow", "1.0")
+	server.Start()
+	defer server.Stop()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(cert)
+
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: "tls://" + ln.Addr().String(), WriteTimeOutMs: 1000, ReadTimeOutMs: 1000,
+		TLSConfig: &tls.Config{RootCAs: rootCAs}}
+
+	if _, err := p.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients that do not trust the certificate are rejected
+	p.TLSConfig = nil
+	if _, err := p.Ping(); err == nil {
+		t.Error("Expected an error for an untrusted certificate")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	config.Set("pow.validateTrytesLength", false)
+	config.Set("server.authKey", "secret")
+	serveTestServerWithConfig(t, path, config, "TestPow", "1.0")
+
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	if _, err := p.PowFunc("ABC9", 14); err == nil {
+		t.Error("POW was accepted without authentication")
+	}
+
+	p.AuthKey = "wrong"
+	_, err := p.PowFunc("ABC9", 14)
+	var serverErr *common.ErrServerError
+	if !errors.As(err, &serverErr) || serverErr.Msg != "authentication failed" {
+		t.Errorf("Expected a server error, got %v", err)
+	}
+
+	p.AuthKey = "secret"
+	if result, err := p.PowFunc("ABC9", 14); err != nil || result != "ABC9" {
+		t.Errorf("Unexpected result %v, %v", result, err)
+	}
+}
+
+func TestPowFuncAsync(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+
+	requests := []giota.Trytes{"ABC9", "DEF9", "GHI9"}
+	results := make([]<-chan common.PowResult, len(requests))
+	for i, trytes := range requests {
+		results[i] = p.PowFuncAsync(trytes, 14)
+	}
+
+	for i, result := range results {
+		res := <-result
+		if res.Error != nil || res.Trytes != requests[i] {
+			t.Errorf("Unexpected result %+v, expected %v", res, requests[i])
+		}
+	}
+}
+
+func TestReceiveTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ts := time.Now()
+	client.SetReadDeadline(ts.Add(50 * time.Millisecond))
+	if _, err := receive(client, 0, 0, 0, ipccommon.Crc8Table, nil, nil); !errors.Is(err, common.ErrReceiveTimeout) {
+		t.Errorf("Expected %v, got %v", common.ErrReceiveTimeout, err)
+	}
+	if elapsed := time.Since(ts); elapsed > 100*time.Millisecond {
+		t.Errorf("Timeout of 50ms returned after %v", elapsed)
+	}
+}
+
+func TestReadTimeOutHonored(t *testing.T) {
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: "/nonexistent/diverDriver.sock", WriteTimeOutMs: 1000, ReadTimeOutMs: 100}
+	p.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		// The diverDriver reads the request but never answers
+		go io.Copy(ioutil.Discard, server)
+		return client, nil
+	}
+
+	ts := time.Now()
+	if _, err := p.Ping(); !errors.Is(err, common.ErrReceiveTimeout) {
+		t.Errorf("Expected %v, got %v", common.ErrReceiveTimeout, err)
+	}
+	if elapsed := time.Since(ts); elapsed < 100*time.Millisecond || elapsed > 200*time.Millisecond {
+		t.Errorf("Read timeout of 100ms returned after %v", elapsed)
+	}
+}
+
+func TestMaxMinWeightMagnitude(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 300)
+	config.Set("pow.validateTrytesLength", false)
+	serveTestServerWithConfig(t, path, config, "TestPow", "1.0")
+
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	if _, err := p.PowFunc("ABC9", 300); err == nil {
+		t.Error("MWM above the default maximum was accepted")
+	}
+
+	// Frame version 1 can't encode MWM above 255
+	p.MaxMinWeightMagnitude = 300
+	if _, err := p.PowFunc("ABC9", 300); err == nil {
+		t.Error("MWM above 255 was accepted with frame version 1")
+	}
+
+	p.FrameVersion = ipccommon.FrameVersionV2
+	if _, err := p.PowFunc("ABC9", 300); err != nil {
+		t.Errorf("MWM 300 was rejected with frame version 2: %v", err)
+	}
+}
+
+func TestCrc8Variant(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	config.Set("server.crc8", "CCITT")
+	serveTestServerWithConfig(t, path, config, "TestPow", "1.0")
+
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 1000, Crc8: "CCITT"}
+	if _, err := p.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client using another variant receives checksum errors
+	p = &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	var checksumErr *common.ErrChecksumMismatch
+	if _, err := p.Ping(); !errors.As(err, &checksumErr) {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+
+	p.Crc8 = "UNKNOWN"
+	if _, err := p.Ping(); err == nil {
+		t.Error("Expected an error for an unknown CRC8 variant")
+	}
+}
+
+func TestPowFuncFull(t *testing.T) {
+	nonce := giota.Trytes(strings.Repeat("N", common.TransactionTrinarySize-common.NonceTrinaryOffset))
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return nonce, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+
+	trytes := giota.Trytes(strings.Repeat("9", common.TransactionTrinarySize))
+	transaction, err := p.PowFuncFull(trytes, 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction != trytes[:common.NonceTrinaryOffset]+nonce {
+		t.Errorf("Nonce was not spliced into the transaction: %v", transaction)
+	}
+
+	if _, err := p.PowFuncFull("ABC9", 14); err == nil {
+		t.Error("Expected an error for trytes that are no transaction")
+	}
+}
+
+func TestGetPowInfo(t *testing.T) {
+	p := startTestServer(t, "TestPow", "1.2.3")
+
+	serverVersion, powType, powVersion, err := GetPowInfo(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverVersion != common.DiverDriverVersion || powType != "TestPow" || powVersion != "1.2.3" {
+		t.Errorf("Unexpected POW info %v, %v, %v", serverVersion, powType, powVersion)
+	}
+}
+
+func TestGetPowInfoLegacyServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server only knows the single info commands
+	responses := map[byte]string{
+		ipccommon.IpcCmdGetServerVersion: "0.1.0",
+		ipccommon.IpcCmdGetPowType:       "LegacyPow",
+		ipccommon.IpcCmdGetPowVersion:    "0.9",
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				frame, err := ipccommon.NewFrameReader(c, 0, 0, nil).ReadFrame()
+				if err != nil {
+					return
+				}
+				cmd, data := byte(ipccommon.IpcCmdResponse), []byte(responses[frame.Command])
+				if _, ok := responses[frame.Command]; !ok {
+					cmd, data = ipccommon.IpcCmdError, []byte(fmt.Sprintf("Unknown command! Cmd: %X", frame.Command))
+				}
+				msg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, cmd, data)
+				response, _ := msg.ToBytes()
+				c.Write(response)
+			}(c)
+		}
+	}()
+
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	serverVersion, powType, powVersion, err := GetPowInfo(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverVersion != "0.1.0" || powType != "LegacyPow" || powVersion != "0.9" {
+		t.Errorf("Unexpected POW info %v, %v, %v", serverVersion, powType, powVersion)
+	}
+
+	// The server info falls back to the plain server version as well
+	serverInfo, err := GetServerInfo(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverInfo != (common.ServerInfo{Version: "0.1.0"}) {
+		t.Errorf("Unexpected server info %+v", serverInfo)
+	}
+
+	// The versions are requested one by one after the server answered that it doesn't know the command
+	versions, err := GetVersions(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.ServerVersion != "0.1.0" || versions.PowType != "LegacyPow" || versions.PowVersion != "0.9" {
+		t.Errorf("Unexpected versions %+v", versions)
+	}
+}
+
+func TestGetVersionsTimeoutNoFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server accepts the connections but never answers
+	var connections int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connections, 1)
+			defer c.Close()
+		}
+	}()
+
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 100}
+	if _, err := GetVersions(p); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("The unresponsive server was asked %d times", n)
+	}
+}
+
+func TestGetServerInfo(t *testing.T) {
+	p := startTestServer(t, "TestPow", "1.0")
+
+	serverInfo, err := p.GetServerInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverInfo.Version != common.DiverDriverVersion || serverInfo.GoVersion != runtime.Version() || serverInfo.MaxFrameVersion != ipccommon.MaxFrameVersion {
+		t.Errorf("Unexpected server info %+v", serverInfo)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	ipcserver.SetPowFunc(ipcserver.SoftwarePowFunc)
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+	passed, _, err := p.SelfTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !passed {
+		t.Error("Self-test failed with the software POW")
+	}
+
+	// A POW implementation returning a wrong nonce fails the self-test
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "ABC9", nil
+	})
+	passed, _, err = p.SelfTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passed {
+		t.Error("Self-test passed with a wrong nonce")
+	}
+}
+
+func TestListConnections(t *testing.T) {
+	p := startTestServer(t, "TestPow", "1.0")
+
+	connections, err := p.ListConnections()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection of the request is handling the command while the list is taken
+	found := false
+	for _, conn := range connections {
+		if conn.Command == ipccommon.CommandNames[ipccommon.IpcCmdListConnections] {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("The own connection was not listed: %+v", connections)
+	}
+}
+
+// recordingTracer records the names of the received events
+type recordingTracer struct {
+	events []string
+}
+
+func (r *recordingTracer) DialDone(err error)      { r.events = append(r.events, "DialDone") }
+func (r *recordingTracer) WriteDone(err error)     { r.events = append(r.events, "WriteDone") }
+func (r *recordingTracer) FirstByte()              { r.events = append(r.events, "FirstByte") }
+func (r *recordingTracer) FrameComplete(err error) { r.events = append(r.events, "FrameComplete") }
+
+func TestTracer(t *testing.T) {
+	p := startTestServer(t, "TestPow", "1.0")
+
+	tracer := &recordingTracer{}
+	p.Tracer = tracer
+	if _, err := p.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"DialDone", "WriteDone", "FirstByte", "FrameComplete"}
+	if strings.Join(tracer.events, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected events %v, expected %v", tracer.events, expected)
+	}
+}
+
+func TestPowFuncTimed(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		time.Sleep(20 * time.Millisecond)
+		return "NONCE", nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+
+	result, duration, err := p.PowFuncTimed("ABC9", 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "NONCE" {
+		t.Errorf("Unexpected result %v", result)
+	}
+	if duration < 20*time.Millisecond {
+		t.Errorf("Duration %v is shorter than the POW", duration)
+	}
+
+	// Requests without the flag still receive only the nonce
+	if result, err := p.PowFunc("ABC9", 14); err != nil || result != "NONCE" {
+		t.Errorf("Unexpected result %v, %v", result, err)
+	}
+}
+
+func TestPowFuncHighPriority(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+
+	if result, err := p.PowFuncHighPriority("ABC9", 14); err != nil || result != "NONCE" {
+		t.Errorf("Unexpected result %v, %v", result, err)
+	}
+}
+
+func TestPowFuncDryRun(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		t.Error("POW function called for a dry run")
+		return "NONCE", nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+
+	result, err := p.PowFuncDryRun("ABC9", 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != giota.Trytes(strings.Repeat("9", ipccommon.NonceTrytesSize)) {
+		t.Errorf("Unexpected placeholder nonce %v", result)
+	}
+
+	// The request is validated like a real POW request
+	if _, err := p.PowFuncDryRun("ABC9", 15); err == nil {
+		t.Error("Expected an error for a MWM above the maximum of the server")
+	}
+	if _, err := p.PowFuncDryRun("abc", 14); err == nil {
+		t.Error("Expected an error for invalid trytes")
+	}
+}
+
+func TestPowFuncNotReady(t *testing.T) {
+	ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+
+	if _, err := p.PowFunc("ABC9", 14); !common.IsPowNotReady(err) {
+		t.Errorf("Expected a not ready error, got %v", err)
+	}
+
+	// All other commands are answered during the initialization
+	if _, err := p.Ping(); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+	if _, err := p.Versions(); err != nil {
+		t.Errorf("GetVersions failed: %v", err)
+	}
+
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	if result, err := p.PowFunc("ABC9", 14); err != nil || result != "NONCE" {
+		t.Errorf("Unexpected result %v, %v", result, err)
+	}
+}
+
+func TestDialFunc(t *testing.T) {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+
+	dials := 0
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: "/nonexistent/diverDriver.sock", WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	p.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		dials++
+		client, server := net.Pipe()
+		go ipcserver.HandleClientConnection(server, config, "TestPow", "1.0")
+		return client, nil
+	}
+
+	if _, err := p.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 1 {
+		t.Errorf("DialFunc called %d times, expected 1", dials)
+	}
+}
+
+func TestSkipReqIDCheck(t *testing.T) {
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: "/nonexistent/diverDriver.sock", WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	p.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		// The diverDriver answers every request with a wrong ReqID
+		go func() {
+			ipccommon.NewFrameReader(server, 0, 0, nil).ReadFrame()
+			server.Write(newResponse(t, 0x42, []byte(common.DiverDriverVersion)))
+		}()
+		return client, nil
+	}
+
+	var mismatch *common.ErrReqIDMismatch
+	if _, err := getServerVersion(p); !errors.As(err, &mismatch) || mismatch.ReqID != 0x42 {
+		t.Errorf("Expected ErrReqIDMismatch, got %v", err)
+	}
+
+	p.SkipReqIDCheck = true
+	if serverVersion, err := getServerVersion(p); err != nil || serverVersion != common.DiverDriverVersion {
+		t.Errorf("Unexpected result %v, %v", serverVersion, err)
+	}
+}
+
+func TestOnNotification(t *testing.T) {
+	started := make(chan struct{})
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return "NONCE", nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	config.Set("pow.validateTrytesLength", false)
+	server := ipcserver.NewServer(ln, config, "TestPow", "1.0")
+	server.Start()
+
+	notifications := make(chan string, 1)
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: path, WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	p.OnNotification = func(notification string) {
+		notifications <- notification
+	}
+
+	go func() {
+		<-started
+		server.Stop()
+	}()
+
+	// The running request is still answered after the notification
+	if result, err := p.PowFunc("ABC9", 14); err != nil || result != "NONCE" {
+		t.Errorf("Unexpected result %v, %v", result, err)
+	}
+
+	select {
+	case notification := <-notifications:
+		if notification != "server shutting down" {
+			t.Errorf("Unexpected notification %q", notification)
+		}
+	default:
+		t.Error("Notification not received")
+	}
+}
+
+func TestChunkedResponses(t *testing.T) {
+	// The response spans three frames of the negotiated maximum frame length
+	payloadLength := 3 * ipccommon.TransactionTrytesSize
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return giota.Trytes(strings.Repeat("ABC", payloadLength/3)), nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+	p.MaxFrameLength = ipccommon.MinPowResponseFrameLength(ipccommon.FrameVersionV1)
+
+	if _, err := p.PowFunc("ABC9", 14); err == nil {
+		t.Error("Expected an error for a response exceeding the maximum frame length without chunked responses")
+	}
+
+	p.ChunkedResponses = true
+	result, err := p.PowFunc("ABC9", 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != giota.Trytes(strings.Repeat("ABC", payloadLength/3)) {
+		t.Errorf("Unexpected result of length %d, expected %d", len(result), payloadLength)
+	}
+}
+
+func TestReceiveChunkedResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	chunks, err := ipccommon.NewChunkedIpcMessages(ipccommon.FrameVersionV1, 3, ipccommon.IpcCmdResponse, []byte("ABCDEFGHI"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("Response split into %d frames, expected 3", len(chunks))
+	}
+
+	go func() {
+		for _, chunk := range chunks {
+			chunkBytes, _ := chunk.ToBytes()
+			server.Write(chunkBytes)
+		}
+	}()
+
+	frame, err := receive(client, 7, 0, 9, ipccommon.Crc8Table, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.ReqID != 3 || frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "ABCDEFGHI" {
+		t.Errorf("Unexpected frame %+v", frame)
+	}
+}
+
+func TestReceiveChunkedResponseLimits(t *testing.T) {
+	chunks, err := ipccommon.NewChunkedIpcMessages(ipccommon.FrameVersionV1, 3, ipccommon.IpcCmdResponse, []byte("ABCDEFGHI"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxChunkedLength int
+		expected         error
+	}{
+		// Chunked responses that were not requested are rejected
+		{0, common.ErrChunkedResponseNotRequested},
+		{8, &common.ErrResponseTooLong{Length: 9, Allowed: 8}},
+		{2, &common.ErrResponseTooLong{Length: 3, Allowed: 2}},
+	}
+
+	for _, test := range tests {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			for _, chunk := range chunks {
+				chunkBytes, _ := chunk.ToBytes()
+				if _, err := server.Write(chunkBytes); err != nil {
+					return
+				}
+			}
+		}()
+
+		_, err := receive(client, 7, 0, test.maxChunkedLength, ipccommon.Crc8Table, nil, nil)
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Errorf("Maximum length %d: expected %v, got %v", test.maxChunkedLength, test.expected, err)
+		}
+		client.Close()
+	}
+}
+
+// corruptingConn flips the CRC8 of every frame written to the connection
+type corruptingConn struct {
+	net.Conn
+}
+
+func (c *corruptingConn) Write(b []byte) (int, error) {
+	corrupted := append([]byte{}, b...)
+	corrupted[len(corrupted)-1] ^= 0xFF
+	return c.Conn.Write(corrupted)
+}
+
+func TestPowFuncResendAfterChecksumError(t *testing.T) {
+	pows := 0
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		pows++
+		return "NONCE", nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	config.Set("pow.validateTrytesLength", false)
+
+	dials := 0
+	p := &common.DiverClient{PowClientImplementation: IpcClient, DiverDriverPath: "/nonexistent/diverDriver.sock", WriteTimeOutMs: 1000, ReadTimeOutMs: 1000}
+	p.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		dials++
+		client, server := net.Pipe()
+		if dials == 1 {
+			// The response of the POW request is corrupted on the way to the client
+			go ipcserver.HandleClientConnection(&corruptingConn{server}, config, "TestPow", "1.0")
+		} else {
+			go ipcserver.HandleClientConnection(server, config, "TestPow", "1.0")
+		}
+		return client, nil
+	}
+
+	result, err := p.PowFunc("ABC9", 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "NONCE" {
+		t.Errorf("Unexpected result %v", result)
+	}
+	if pows != 1 {
+		t.Errorf("POW done %d times, expected 1", pows)
+	}
+	if dials != 2 {
+		t.Errorf("DialFunc called %d times, expected 2", dials)
+	}
+}
+
+func TestCommandTimeOuts(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		time.Sleep(200 * time.Millisecond)
+		return "NONCE", nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+	p.ReadTimeOutMs = 50
+
+	// The POW takes longer than the read timeout of the other commands
+	if _, err := p.PowFunc("ABC9", 14); err == nil {
+		t.Error("POW finished within the default read timeout")
+	}
+
+	p.CommandTimeOutsMs = map[byte]int{ipccommon.IpcCmdPowFunc: 2000}
+	if _, err := p.PowFunc("ABC9", 14); err != nil {
+		t.Errorf("POW failed with the read timeout of the command: %v", err)
+	}
+	if _, err := p.Ping(); err != nil {
+		t.Errorf("Ping failed with the default read timeout: %v", err)
+	}
+}
+
+func TestPowFuncPackedTrytes(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+	p.FrameVersion = ipccommon.FrameVersionV2
+	p.PackedTrytes = true
+
+	// The server unpacks the trytes before the POW
+	result, err := p.PowFunc("HELLOWORLD99", 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "HELLOWORLD99" {
+		t.Errorf("Unexpected result %v", result)
+	}
+}
+
+func TestGetStatsAndReset(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+	if _, err := p.GetStatsAndReset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.PowFunc("ABC9", 14); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := p.GetStatsAndReset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PowCount != 1 {
+		t.Errorf("%d POW requests in the interval, expected 1", stats.PowCount)
+	}
+
+	stats, err = p.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PowCount != 0 {
+		t.Errorf("%d POW requests after the reset, expected 0", stats.PowCount)
+	}
+}
+
+func TestPowFuncRaw(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+	for _, version := range []byte{ipccommon.FrameVersionV1, ipccommon.FrameVersionV2} {
+		p.FrameVersion = version
+		result, err := p.PowFuncRaw([]byte("ABC9"), 14)
+		if err != nil {
+			t.Fatalf("Version %d: %v", version, err)
+		}
+		if string(result) != "ABC9" {
+			t.Errorf("Version %d: unexpected result %s", version, result)
+		}
+	}
+
+	// Invalid trytes are passed through and rejected by the diverDriver
+	var serverErr *common.ErrServerError
+	if _, err := p.PowFuncRaw([]byte("abc"), 14); !errors.As(err, &serverErr) {
+		t.Errorf("Expected an error of the server, got %v", err)
+	}
+}
+
+func TestServerErrorCodes(t *testing.T) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(t, "TestPow", "1.0")
+
+	// FRAME_VERSION==0x02 errors carry an ERROR_CODE, which is mapped to a typed error
+	p.FrameVersion = ipccommon.FrameVersionV2
+	_, err := p.PowFunc("ABC9", 15)
+	var serverErr *common.ErrServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != ipccommon.ErrorCodeMwmTooHigh || !errors.Is(err, common.ErrServerMwmTooHigh) {
+		t.Fatalf("Expected ErrServerMwmTooHigh, got %v", err)
+	}
+	if !strings.HasPrefix(serverErr.Msg, "MinWeightMagnitude too high") {
+		t.Errorf("Unexpected message %q", serverErr.Msg)
+	}
+
+	// FRAME_VERSION==0x01 errors are not classified
+	p.FrameVersion = ipccommon.FrameVersionV1
+	_, err = p.PowFunc("ABC9", 15)
+	if !errors.As(err, &serverErr) || serverErr.Code != ipccommon.ErrorCodeUnknown || errors.Is(err, common.ErrServerMwmTooHigh) {
+		t.Errorf("Expected an unclassified server error, got %v", err)
+	}
+}
+
+// benchmarkPowFunc measures the round trip of POW requests against a diverDriver that answers immediately
+func benchmarkPowFunc(b *testing.B, pow func(p *common.DiverClient) error) {
+	ipcserver.SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes[common.NonceTrinaryOffset:], nil
+	})
+	defer ipcserver.SetPowFunc(nil)
+
+	p := startTestServer(b, "TestPow", "1.0")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := pow(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPowFunc(b *testing.B) {
+	trytes := giota.Trytes(strings.Repeat("A", common.TransactionTrinarySize))
+	benchmarkPowFunc(b, func(p *common.DiverClient) error {
+		_, err := p.PowFunc(trytes, 14)
+		return err
+	})
+}
+
+func BenchmarkPowFuncRaw(b *testing.B) {
+	trytes := bytes.Repeat([]byte("A"), common.TransactionTrinarySize)
+	benchmarkPowFunc(b, func(p *common.DiverClient) error {
+		_, err := p.PowFuncRaw(trytes, 14)
+		return err
+	})
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package ipcclient
+
+import (
+	"errors"
+	"net"
+)
+
+// dialPipe is only supported on Windows, other platforms use Unix sockets
+func dialPipe(path string) (net.Conn, error) {
+	return nil, errors.New("Named pipes are only supported on Windows")
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package ipcclient
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// dialPipe connects to the diverDriver via a Windows named pipe
+func dialPipe(path string) (net.Conn, error) {
+	return winio.DialPipe(path, nil)
+}
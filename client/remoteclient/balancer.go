@@ -0,0 +1,50 @@
+package remoteclient
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/utils"
+)
+
+// endpointBalancer distributes the requests over the remote POW servers of a comma separated DiverDriverPath
+type endpointBalancer struct {
+	endpoints []string
+	next      uint32 // Index of the endpoint that is tried first by the next request, accessed atomically
+}
+
+// balancers are the balancers by DiverDriverPath, so all clients with the same servers share the round-robin
+var balancers sync.Map
+
+// balancerFor returns the balancer of the remote POW servers in the path
+func balancerFor(path string) *endpointBalancer {
+	if b, ok := balancers.Load(path); ok {
+		return b.(*endpointBalancer)
+	}
+
+	b, _ := balancers.LoadOrStore(path, &endpointBalancer{endpoints: utils.SplitRemoteURLList(path)})
+	return b.(*endpointBalancer)
+}
+
+// do calls f with one endpoint after the other, starting round-robin, until a call succeeds
+// If the tracer implements common.EndpointTracer, it receives the result of every endpoint.
+// It returns the error of the last endpoint if all of them failed.
+// The path always contains at least one endpoint, IsValidRemoteURLList rejects empty entries.
+func (b *endpointBalancer) do(tracer common.Tracer, f func(endpoint string) error) error {
+	endpointTracer, _ := tracer.(common.EndpointTracer)
+
+	start := int(atomic.AddUint32(&b.next, 1) - 1)
+	var err error
+	for i := range b.endpoints {
+		endpoint := b.endpoints[(start+i)%len(b.endpoints)]
+		err = f(endpoint)
+		if endpointTracer != nil {
+			endpointTracer.EndpointDone(endpoint, err)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
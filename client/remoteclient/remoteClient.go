@@ -1,17 +1,37 @@
 package remoteclient
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/iotaledger/giota"
 	"github.com/muxxer/diverdriver/common"
 	remotePoWClient "gitlab.com/brunoamancio/remotePoW/client"
 )
 
+// remoteDoPoW does the POW on the remote POW server, replaced in tests
+var remoteDoPoW = remotePoWClient.DoRemotePoW
+
 var (
 	RemoteClient = &common.ClientAPI{
-		PowFuncDefinition:    PowFunc,
-		GetPowInfoDefinition: GetPowInfo,
+		PowFuncDefinition:             PowFunc,
+		PowFuncFullDefinition:         PowFuncFull,
+		PowFuncTimedDefinition:        PowFuncTimed,
+		PowFuncHighPriorityDefinition: PowFuncHighPriority,
+		PowFuncDryRunDefinition:       PowFuncDryRun,
+		PowFuncRawDefinition:          PowFuncRaw,
+		PowFuncContextDefinition:      PowFuncContext,
+		GetPowInfoDefinition:          GetPowInfo,
+		GetVersionsDefinition:         GetVersions,
+		GetServerInfoDefinition:       GetServerInfo,
+		GetStatsDefinition:            GetStats,
+		GetStatsAndResetDefinition:    GetStatsAndReset,
+		PingDefinition:                Ping,
+		SelfTestDefinition:            SelfTest,
+		GetCapabilitiesDefinition:     GetCapabilities,
+		ListConnectionsDefinition:     ListConnections,
 	}
 )
 
@@ -29,35 +49,189 @@ func PowFunc(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int)
 	return result, err
 }
 
+// PowFuncFull does the POW and returns the transaction with the nonce, as received from the remote POW server
+func PowFuncFull(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (transaction giota.Trytes, Error error) {
+	if (minWeightMagnitude < 0) || (minWeightMagnitude > 243) {
+		return "", fmt.Errorf("minWeightMagnitude out of range [0-243]: %v", minWeightMagnitude)
+	}
+
+	trytesWithPowString, err := doRemotePoW(p, trytes, minWeightMagnitude)
+	if err != nil {
+		return "", err
+	}
+
+	if len(trytesWithPowString) != common.TransactionTrinarySize {
+		return "", fmt.Errorf("Remote POW returned a wrong number of trytes! Length: %v, Expected: %v", len(trytesWithPowString), common.TransactionTrinarySize)
+	}
+	return giota.Trytes(trytesWithPowString), nil
+}
+
 func doPow(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (giota.Trytes, error) {
-	trytesWithPowString, err := remotePoWClient.DoRemotePoW(p.DiverDriverPath, string(trytes), minWeightMagnitude)
+	trytesWithPowString, err := doRemotePoW(p, trytes, minWeightMagnitude)
 	if err != nil {
 		return "", err
 	}
-	// 2646 is the offset of the nonce in a transaction
-	nounce := trytesWithPowString[2646:]
-	return giota.Trytes(nounce), err
+
+	return extractNonce(trytesWithPowString)
+}
+
+// doRemotePoW sends the POW request to a remote POW server (see callRemote)
+func doRemotePoW(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (trytesWithPowString string, Error error) {
+	err := callRemote(p, func(endpoint string) (err error) {
+		trytesWithPowString, err = remoteDoPoW(endpoint, string(trytes), minWeightMagnitude)
+		return err
+	})
+	return trytesWithPowString, err
+}
+
+// callRemote calls the remote POW servers of the client and repeats failed calls according to the RetryPolicy of the client
+// Every attempt tries the servers round-robin until one succeeds (see endpointBalancer).
+// While the CircuitBreaker of the client is open, the call fails with common.ErrCircuitOpen without contacting a server
+func callRemote(p *common.DiverClient, call func(endpoint string) error) error {
+	balancer := balancerFor(p.DiverDriverPath)
+
+	attempts := p.RetryPolicy.Attempts()
+	for attempt := 1; ; attempt++ {
+		if err := p.CircuitBreaker.Allow(); err != nil {
+			return err
+		}
+
+		err := balancer.do(p.Tracer, call)
+		p.CircuitBreaker.Record(err)
+		if err == nil || attempt >= attempts {
+			return err
+		}
+
+		time.Sleep(p.RetryPolicy.Backoff(attempt))
+	}
+}
+
+// extractNonce returns the nonce of the trytes of a transaction
+func extractNonce(trytesWithPowString string) (giota.Trytes, error) {
+	if len(trytesWithPowString) < common.NonceTrinaryOffset {
+		return "", fmt.Errorf("Remote POW returned too few trytes! Length: %v, Expected: >= %v", len(trytesWithPowString), common.NonceTrinaryOffset)
+	}
+
+	nonce := trytesWithPowString[common.NonceTrinaryOffset:]
+	return giota.Trytes(nonce), nil
 }
 
 func GetPowInfo(p *common.DiverClient) (ServerVersion string, PowType string, PowVersion string, Error error) {
-	serverVersionString, powTypeString, powVersionString, err := remotePoWClient.GetPoWInfo(p.DiverDriverPath)
-	return serverVersionString, powTypeString, powVersionString, err
+	err := callRemote(p, func(endpoint string) (err error) {
+		ServerVersion, PowType, PowVersion, err = remotePoWClient.GetPoWInfo(endpoint)
+		return err
+	})
+	return ServerVersion, PowType, PowVersion, err
+}
+
+// GetVersions returns the versions of the remote POW server and the used POW implementation
+// The protocol version is always 0, because remote POW doesn't use the IPC protocol
+func GetVersions(p *common.DiverClient) (Versions common.Versions, Error error) {
+	serverVersion, powType, powVersion, err := GetPowInfo(p)
+	if err != nil {
+		return common.Versions{}, err
+	}
+
+	return common.Versions{ServerVersion: serverVersion, PowType: powType, PowVersion: powVersion}, nil
+}
+
+// GetServerInfo returns the version of the remote POW server, which doesn't report its build
+func GetServerInfo(p *common.DiverClient) (ServerInfo common.ServerInfo, Error error) {
+	serverVersion, err := getServerVersion(p)
+	if err != nil {
+		return common.ServerInfo{}, err
+	}
+
+	return common.ServerInfo{Version: serverVersion}, nil
+}
+
+// GetStats is not supported by remote POW
+func GetStats(p *common.DiverClient) (Stats common.Stats, Error error) {
+	return common.Stats{}, errors.New("GetStats is not supported by remote POW")
+}
+
+// GetStatsAndReset is not supported by remote POW
+func GetStatsAndReset(p *common.DiverClient) (Stats common.Stats, Error error) {
+	return common.Stats{}, errors.New("GetStatsAndReset is not supported by remote POW")
+}
+
+// PowFuncTimed is not supported by remote POW
+func PowFuncTimed(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, duration time.Duration, Error error) {
+	return "", 0, errors.New("PowFuncTimed is not supported by remote POW")
+}
+
+// PowFuncHighPriority does the POW like PowFunc, remote POW has no priorities
+func PowFuncHighPriority(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	return PowFunc(p, trytes, minWeightMagnitude)
+}
+
+// PowFuncRaw does the POW like PowFunc, remote POW always exchanges the trytes as strings
+func PowFuncRaw(p *common.DiverClient, trytes []byte, minWeightMagnitude int) (result []byte, Error error) {
+	nonce, err := PowFunc(p, giota.Trytes(trytes), minWeightMagnitude)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(nonce), nil
+}
+
+// PowFuncDryRun is not supported by remote POW
+func PowFuncDryRun(p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	return "", errors.New("PowFuncDryRun is not supported by remote POW")
+}
+
+// ListConnections is not supported by remote POW
+func ListConnections(p *common.DiverClient) (Connections []common.ConnectionInfo, Error error) {
+	return nil, errors.New("ListConnections is not supported by remote POW")
+}
+
+// PowFuncContext is not supported by remote POW
+func PowFuncContext(ctx context.Context, p *common.DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	return "", errors.New("PowFuncContext is not supported by remote POW")
+}
+
+// GetCapabilities is not supported by remote POW
+func GetCapabilities(p *common.DiverClient) (Commands []byte, Error error) {
+	return nil, errors.New("GetCapabilities is not supported by remote POW")
+}
+
+// SelfTest does the POW of common.SelfTestTransaction on the remote POW server and verifies the nonce
+// The duration includes the round trip, the remote POW server doesn't report the duration of the POW.
+func SelfTest(p *common.DiverClient) (Passed bool, Duration time.Duration, Error error) {
+	ts := time.Now()
+	nonce, err := doPow(p, common.SelfTestTransaction, common.SelfTestMinWeightMagnitude)
+	if err != nil {
+		return false, 0, err
+	}
+	return common.VerifyNonce(common.SelfTestTransaction, nonce, common.SelfTestMinWeightMagnitude), time.Since(ts), nil
+}
+
+// Ping is not supported by remote POW
+func Ping(p *common.DiverClient) (RoundTrip time.Duration, Error error) {
+	return 0, errors.New("Ping is not supported by remote POW")
 }
 
-// Not used yet, but its available for individual requests
 func getServerVersion(p *common.DiverClient) (serverVersion string, Error error) {
-	serverVersionString, err := remotePoWClient.GetServerVersion(p.DiverDriverPath)
-	return serverVersionString, err
+	err := callRemote(p, func(endpoint string) (err error) {
+		serverVersion, err = remotePoWClient.GetServerVersion(endpoint)
+		return err
+	})
+	return serverVersion, err
 }
 
 // Not used yet, but its available for individual requests
 func getPowType(p *common.DiverClient) (powType string, Error error) {
-	powTypeString, err := remotePoWClient.GetPoWType(p.DiverDriverPath)
-	return powTypeString, err
+	err := callRemote(p, func(endpoint string) (err error) {
+		powType, err = remotePoWClient.GetPoWType(endpoint)
+		return err
+	})
+	return powType, err
 }
 
 // Not used yet, but its available for individual requests
 func getPowVersion(p *common.DiverClient) (powVersion string, Error error) {
-	powVersionString, err := remotePoWClient.GetPoWVersion(p.DiverDriverPath)
-	return powVersionString, err
+	err := callRemote(p, func(endpoint string) (err error) {
+		powVersion, err = remotePoWClient.GetPoWVersion(endpoint)
+		return err
+	})
+	return powVersion, err
 }
@@ -0,0 +1,117 @@
+package remoteclient
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muxxer/diverdriver/common"
+	remotePoWClient "gitlab.com/brunoamancio/remotePoW/client"
+)
+
+func TestExtractNonce(t *testing.T) {
+	nonce := strings.Repeat("N", 27)
+
+	result, err := extractNonce(strings.Repeat("9", common.NonceTrinaryOffset) + nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != nonce {
+		t.Errorf("Wrong nonce %v, expected %v", result, nonce)
+	}
+}
+
+func TestExtractNonceShortTrytes(t *testing.T) {
+	if _, err := extractNonce("ABC9"); err == nil {
+		t.Error("Expected an error for too short trytes")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	calls := 0
+	fail := true
+	remoteDoPoW = func(url string, trytes string, mwm int) (string, error) {
+		calls++
+		if fail {
+			return "", errors.New("remote POW server unreachable")
+		}
+		return strings.Repeat("9", common.TransactionTrinarySize), nil
+	}
+	defer func() { remoteDoPoW = remotePoWClient.DoRemotePoW }()
+
+	p := &common.DiverClient{DiverDriverPath: "http://localhost:14265"}
+	p.CircuitBreaker.Threshold = 2
+	p.CircuitBreaker.Cooldown = 50 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		if _, err := PowFunc(p, "ABC9", 14); err == nil || err == common.ErrCircuitOpen {
+			t.Fatalf("Attempt %d: expected the error of the server, got %v", i, err)
+		}
+	}
+
+	// The circuit is open, the server is not called until the cooldown has passed
+	if _, err := PowFunc(p, "ABC9", 14); err != common.ErrCircuitOpen {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Server called %d times, expected 2", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	fail = false
+	if _, err := PowFunc(p, "ABC9", 14); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if _, err := PowFunc(p, "ABC9", 14); err != nil {
+		t.Errorf("Circuit not closed after a successful probe: %v", err)
+	}
+}
+
+// endpointRecorder records the endpoints reported via common.EndpointTracer
+type endpointRecorder struct {
+	endpoints []string
+}
+
+func (r *endpointRecorder) DialDone(err error)      {}
+func (r *endpointRecorder) WriteDone(err error)     {}
+func (r *endpointRecorder) FirstByte()              {}
+func (r *endpointRecorder) FrameComplete(err error) {}
+
+func (r *endpointRecorder) EndpointDone(endpoint string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	r.endpoints = append(r.endpoints, endpoint+" "+result)
+}
+
+func TestRoundRobinEndpoints(t *testing.T) {
+	remoteDoPoW = func(url string, trytes string, mwm int) (string, error) {
+		if url == "http://node2:14265" {
+			return "", errors.New("remote POW server unreachable")
+		}
+		return strings.Repeat("9", common.TransactionTrinarySize), nil
+	}
+	defer func() { remoteDoPoW = remotePoWClient.DoRemotePoW }()
+
+	recorder := &endpointRecorder{}
+	p := &common.DiverClient{DiverDriverPath: "http://node1:14265, http://node2:14265, http://node3:14265", Tracer: recorder}
+	balancers.Delete(p.DiverDriverPath) // Start the round-robin at the first endpoint
+	for i := 0; i < 3; i++ {
+		if _, err := PowFunc(p, "ABC9", 14); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The failed endpoint is skipped within the request
+	expected := []string{
+		"http://node1:14265 ok",
+		"http://node2:14265 failed",
+		"http://node3:14265 ok",
+		"http://node3:14265 ok",
+	}
+	if strings.Join(recorder.endpoints, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected endpoints %v, expected %v", recorder.endpoints, expected)
+	}
+}
@@ -0,0 +1,13 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// AuthHMAC returns the HMAC-SHA256 of the nonce issued by the diverDriver using the pre-shared key
+func AuthHMAC(key []byte, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops requests to an endpoint that failed repeatedly, so they fail fast instead of waiting for the timeout.
+// After Threshold consecutive failures the circuit opens and requests fail with ErrCircuitOpen. When the Cooldown has passed,
+// a single request probes the endpoint again. Its success closes the circuit, its failure opens it for another Cooldown.
+// The zero value disables the circuit breaker.
+type CircuitBreaker struct {
+	Threshold int           // Number of consecutive failures until the circuit opens (0 = disabled)
+	Cooldown  time.Duration // Time the circuit stays open until the endpoint is probed again
+
+	lock     sync.Mutex
+	failures int       // Consecutive failures
+	openedAt time.Time // Time of the last failure that kept the circuit open
+	probing  bool      // True while the request probing the endpoint is running
+}
+
+// Allow returns ErrCircuitOpen if the request should not be sent to the endpoint
+// Every allowed request has to be reported via Record.
+func (b *CircuitBreaker) Allow() error {
+	if b.Threshold <= 0 {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures < b.Threshold {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.Cooldown {
+		return ErrCircuitOpen
+	}
+
+	b.probing = true
+	return nil
+}
+
+// Record reports the result of a request allowed by Allow
+func (b *CircuitBreaker) Record(err error) {
+	if b.Threshold <= 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openedAt = time.Now()
+	}
+}
@@ -1,45 +1,619 @@
 package common
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/iotaledger/giota"
 )
 
+// Build information of the diverDriver, set when building a release, e.g.
+// go build -ldflags "-X github.com/muxxer/diverdriver/common.GitCommit=$(git rev-parse --short HEAD)"
+var (
+	GitCommit = "" // Git commit the diverDriver was built from (empty = unknown)
+	BuildDate = "" // Date the diverDriver was built (empty = unknown)
+)
+
 const (
 	DiverDriverVersion = "0.2.0"
+
+	DefaultMaxMinWeightMagnitude = 243 // Default of DiverClient.MaxMinWeightMagnitude
+
+	NonceTrinaryOffset     = 2646 // Offset of the nonce in the trytes of a transaction ((8019 - 81) / 3)
+	TransactionTrinarySize = 2673 // Trytes of a transaction (8019 / 3)
 )
 
 type PowFuncDefinition func(p *DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error)
+type PowFuncFullDefinition func(p *DiverClient, trytes giota.Trytes, minWeightMagnitude int) (transaction giota.Trytes, Error error)
+type PowFuncTimedDefinition func(p *DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, duration time.Duration, Error error)
+type PowFuncHighPriorityDefinition func(p *DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error)
+type PowFuncDryRunDefinition func(p *DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error)
+type PowFuncRawDefinition func(p *DiverClient, trytes []byte, minWeightMagnitude int) (result []byte, Error error)
+type PowFuncContextDefinition func(ctx context.Context, p *DiverClient, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error)
 type GetPowInfoDefinition func(p *DiverClient) (ServerVersion string, PowType string, PowVersion string, Error error)
+type GetVersionsDefinition func(p *DiverClient) (Versions Versions, Error error)
+type GetServerInfoDefinition func(p *DiverClient) (ServerInfo ServerInfo, Error error)
+type GetStatsDefinition func(p *DiverClient) (Stats Stats, Error error)
+type GetStatsAndResetDefinition func(p *DiverClient) (Stats Stats, Error error)
+type PingDefinition func(p *DiverClient) (RoundTrip time.Duration, Error error)
+type SelfTestDefinition func(p *DiverClient) (Passed bool, Duration time.Duration, Error error)
+type GetCapabilitiesDefinition func(p *DiverClient) (Commands []byte, Error error)
+type ListConnectionsDefinition func(p *DiverClient) (Connections []ConnectionInfo, Error error)
+type CloseDefinition func(p *DiverClient) error
 
 type ClientAPI struct {
-	PowFuncDefinition    PowFuncDefinition
-	GetPowInfoDefinition GetPowInfoDefinition
+	PowFuncDefinition             PowFuncDefinition
+	PowFuncFullDefinition         PowFuncFullDefinition
+	PowFuncTimedDefinition        PowFuncTimedDefinition
+	PowFuncHighPriorityDefinition PowFuncHighPriorityDefinition
+	PowFuncDryRunDefinition       PowFuncDryRunDefinition
+	PowFuncContextDefinition      PowFuncContextDefinition
+	PowFuncRawDefinition          PowFuncRawDefinition
+	GetPowInfoDefinition          GetPowInfoDefinition
+	GetVersionsDefinition         GetVersionsDefinition
+	GetServerInfoDefinition       GetServerInfoDefinition
+	GetStatsDefinition            GetStatsDefinition
+	GetStatsAndResetDefinition    GetStatsAndResetDefinition
+	PingDefinition                PingDefinition
+	SelfTestDefinition            SelfTestDefinition
+	GetCapabilitiesDefinition     GetCapabilitiesDefinition
+	ListConnectionsDefinition     ListConnectionsDefinition
+	CloseDefinition               CloseDefinition // Releases the resources of the implementation (nil = nothing to release)
+}
+
+// Versions contains the versions of the diverDriver, the IPC protocol and the used POW implementation
+type Versions struct {
+	ServerVersion   string       `json:"serverVersion"`         // Version of the diverDriver
+	ProtocolVersion byte         `json:"protocolVersion"`       // Highest IPC frame version supported by the diverDriver (0 for remote POW)
+	PowType         string       `json:"powType"`               // Name of the used POW implementation (e.g. PiDiver)
+	PowVersion      string       `json:"powVersion"`            // Version of the used POW implementation (e.g. PiDiver FPGA Core Version)
+	PowFeatures     []string     `json:"powFeatures,omitempty"` // Features supported by the used POW implementation (empty for older diverDrivers)
+	PowBackends     []PowBackend `json:"powBackends,omitempty"` // Additional POW backends that can be selected via DiverClient.PowBackend
+}
+
+// ServerInfo contains the version and the build of the diverDriver, e.g. to diagnose deployments
+// Older diverDrivers only report the Version, all other fields are empty in that case.
+type ServerInfo struct {
+	Version         string `json:"version"`         // Version of the diverDriver
+	GitCommit       string `json:"gitCommit"`       // Git commit the diverDriver was built from
+	BuildDate       string `json:"buildDate"`       // Date the diverDriver was built
+	GoVersion       string `json:"goVersion"`       // Version of Go the diverDriver was built with
+	MaxFrameVersion byte   `json:"maxFrameVersion"` // Highest IPC frame version supported by the diverDriver
+}
+
+// ConnectionInfo describes a client connection of the diverDriver, e.g. to debug a wedged server
+type ConnectionInfo struct {
+	ID             uint64    `json:"id"`             // Id of the connection, as used in the log lines of the diverDriver
+	Peer           string    `json:"peer"`           // Remote address, or PID and UID of the peer process for Unix sockets
+	Opened         time.Time `json:"opened"`         // Time the connection was accepted
+	Command        string    `json:"command"`        // Name of the command currently handled (empty while waiting for the next request)
+	CommandStarted time.Time `json:"commandStarted"` // Time the handling of Command started (zero while waiting)
+	PendingPow     int       `json:"pendingPow"`     // Number of POW requests of the connection that were not answered yet
+}
+
+// PowBackend describes a POW backend of the diverDriver that is registered by name
+type PowBackend struct {
+	Name       string `json:"name"`       // Name used in POW requests to select the backend
+	PowType    string `json:"powType"`    // Name of the POW implementation of the backend
+	PowVersion string `json:"powVersion"` // Version of the POW implementation of the backend
+}
+
+// HasPowFeature returns true if the used POW implementation supports the feature
+func (v Versions) HasPowFeature(feature string) bool {
+	for _, f := range v.PowFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
 }
 
+// Stats contains the POW statistics of the diverDriver
+type Stats struct {
+	PowCount             uint64         `json:"powCount"`             // Number of successful POW requests
+	AveragePowDurationMs float64        `json:"averagePowDurationMs"` // Average duration of a successful POW request in ms
+	AverageQueueWaitMs   float64        `json:"averageQueueWaitMs"`   // Average time a POW request waited for a worker in ms (compare with AveragePowDurationMs)
+	QueueDepth           int64          `json:"queueDepth"`           // Number of POW requests waiting for a worker
+	ActiveConnections    int64          `json:"activeConnections"`    // Number of connected clients
+	Draining             bool           `json:"draining"`             // True if new POW requests are rejected because the server is in drain mode
+	MwmHistogram         map[int]uint64 `json:"mwmHistogram"`         // Number of POW requests per requested MWM
+	PowPerSecond         float64        `json:"powPerSecond"`         // Successful POW requests per second over the last minute, e.g. to compare devices
+	RecentAverageMwm     float64        `json:"recentAverageMwm"`     // Average MWM of the successful POW requests of the last minute
+}
+
+// DialFunc creates a connection to the diverDriver, replacing the dial of the DiverDriverPath
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
 // DiverClient is the client that connects to the diverDriver
+// It has to be closed via Close when it is no longer needed.
 type DiverClient struct {
 	PowClientImplementation *ClientAPI
-	DiverDriverPath         string // Path to the diverDriver Unix socket
-	WriteTimeOutMs          int64  // Timeout in ms to write to the Unix socket
-	ReadTimeOutMs           int    // Timeout in ms to read the Unix socket
-	RequestId               byte
+	DiverDriverPath         string         // Path to the diverDriver Unix socket ("@name" = abstract namespace on Linux), or "tcp://host:port" / "tls://host:port"
+	TLSConfig               *tls.Config    // TLS configuration for "tls://" paths (nil = default configuration)
+	AuthKey                 string         // Pre-shared key to authenticate the connections to the diverDriver (empty = no authentication)
+	WriteTimeOutMs          int64          // Timeout in ms to write to the Unix socket
+	ReadTimeOutMs           int            // Timeout in ms to read the Unix socket (0 = no timeout)
+	CommandTimeOutsMs       map[byte]int   // Read timeouts in ms of single commands by IPC_CMD, e.g. a longer one for ipccommon.IpcCmdPowFunc (missing commands use ReadTimeOutMs)
+	ReadBufferSize          int            // Size of the buffer for reading the responses (0 = ipccommon.DefaultReadBufferSize)
+	MaxFrameLength          int            // Maximum accepted length of a received frame, negotiated with the diverDriver (0 = maximum length of the frame version)
+	ChunkedResponses        bool           // Longer responses are received in several frames of at most MaxFrameLength instead of failing (requires a diverDriver with chunked responses)
+	MaxResponseLength       int            // Maximum length of the DATA of a chunked response (0 = ipccommon.DefaultMaxResponseLength)
+	RetryPolicy             RetryPolicy    // Retries of requests that failed to connect (default: no retry)
+	CircuitBreaker          CircuitBreaker // Fails requests to a remote POW server fast after consecutive failures (default: disabled)
+	MaxConcurrency          int            // Maximum number of POW requests of the client running at the same time, further requests wait for a free slot (0 = no limit, has to be set before the first request)
+	MaxMinWeightMagnitude   int            // Maximum MWM accepted by the client (0 = DefaultMaxMinWeightMagnitude, above 255 requires frame version 2)
+	PowBackend              string         // Name of the POW backend of the diverDriver doing the POW (empty = primary backend, requires frame version 2, see Versions.PowBackends)
+	PackedTrytes            bool           // The trytes of POW requests are sent packed as trits, about 40% smaller than ASCII (requires frame version 2 and a diverDriver with packed trytes)
+	FrameVersion            byte           // IPC frame version used for requests (0 = version 1, use version 2 for more than 255 concurrent requests)
+	SkipReqIDCheck          bool           // Responses are accepted without comparing their REQ_ID with the request, e.g. for strictly sequential requests (default: ErrReqIDMismatch)
+	VerifyPoW               bool           // The hash of every POW result is checked against the requested MWM, e.g. for an untrusted remote POW server (requires whole transactions, default: the result is trusted)
+	Crc8                    string         // CRC8 variant of the frames, has to match "server.crc8" of the diverDriver (empty = MAXIM, see ipccommon.Crc8Variants)
+	ExpectedPowType         string         // POW type the diverDriver has to use, checked via GetPowInfo before the first POW (empty = any)
+	ExpectedPowVersion      string         // POW version the diverDriver has to use, checked like ExpectedPowType (empty = any)
+	PowInfoCacheTTL         time.Duration  // Time the result of GetPowInfo is cached (0 = until InvalidatePowInfo is called, negative = no caching)
+	Tracer                  Tracer         // Receives the events of the requests to attribute latency (nil = no tracing)
+	KeepAlive               time.Duration  // Interval of the TCP keepalive probes for "tcp://" and "tls://" paths, detects a dead diverDriver during long requests (0 = default of Go, negative = disabled)
+	DialFunc                DialFunc       // Creates the connections to the diverDriver, e.g. for tunnels or tests (nil = dial DiverDriverPath)
+	OnNotification          func(string)   // Called for every notification of the diverDriver received during a request, e.g. "server shutting down" (nil = ignored)
+	RequestId               uint16
 	RequestIdLock           sync.Mutex
+
+	powInfo     *powInfo
+	powInfoLock sync.Mutex
+	powChecked  int32 // Set after the check of ExpectedPowType and ExpectedPowVersion succeeded, accessed atomically
+
+	powSlots     chan struct{} // Semaphore of MaxConcurrency, nil if there is no limit
+	powSlotsOnce sync.Once
+
+	closed int32 // Set by Close, accessed atomically
+}
+
+// ReadTimeOutMsFor returns the read timeout in ms of the response to the command (see CommandTimeOutsMs)
+func (p *DiverClient) ReadTimeOutMsFor(command byte) int {
+	if timeOutMs, ok := p.CommandTimeOutsMs[command]; ok {
+		return timeOutMs
+	}
+	return p.ReadTimeOutMs
+}
+
+// powInfo is the cached result of GetPowInfo
+type powInfo struct {
+	serverVersion string
+	powType       string
+	powVersion    string
+	fetched       time.Time
 }
 
 func (p *DiverClient) PowFunc(trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
-	return p.PowClientImplementation.PowFuncDefinition(p, trytes, minWeightMagnitude)
+	if p.IsClosed() {
+		return "", ErrClientClosed
+	}
+	if err := p.checkExpectedPow(); err != nil {
+		return "", err
+	}
+	release, err := p.acquirePowSlot(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	result, err = p.PowClientImplementation.PowFuncDefinition(p, trytes, minWeightMagnitude)
+	if err != nil {
+		return "", err
+	}
+	return result, p.verifyPow(trytes, result, minWeightMagnitude)
+}
+
+// PowFuncFull does the POW like PowFunc, but returns the complete trytes of the transaction with the nonce spliced in
+// PowFunc only returns the nonce, which has to be copied to the transaction at NonceTrinaryOffset by the caller
+func (p *DiverClient) PowFuncFull(trytes giota.Trytes, minWeightMagnitude int) (transaction giota.Trytes, Error error) {
+	if p.IsClosed() {
+		return "", ErrClientClosed
+	}
+	if err := p.checkExpectedPow(); err != nil {
+		return "", err
+	}
+	release, err := p.acquirePowSlot(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	transaction, err = p.PowClientImplementation.PowFuncFullDefinition(p, trytes, minWeightMagnitude)
+	if err != nil {
+		return "", err
+	}
+	// A ClientAPI may return anything, the nonce is only sliced off a complete transaction
+	if len(transaction) != TransactionTrinarySize {
+		return "", fmt.Errorf("Invalid transaction length! Length: %d, Expected: %d", len(transaction), TransactionTrinarySize)
+	}
+	// The returned transaction itself is checked, not only its nonce
+	return transaction, p.verifyPow(transaction, transaction[NonceTrinaryOffset:], minWeightMagnitude)
+}
+
+// PowTransaction does the POW for the transaction like PowFunc and returns the parsed transaction with the nonce
+// The hash of the returned transaction is always checked against the MinWeightMagnitude, independent of VerifyPoW.
+func (p *DiverClient) PowTransaction(tx *giota.Transaction, minWeightMagnitude int) (transaction *giota.Transaction, Error error) {
+	if tx == nil {
+		return nil, fmt.Errorf("Transaction is nil")
+	}
+
+	trytes := tx.Trytes()
+	nonce, err := p.PowFunc(trytes, minWeightMagnitude)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionTrytes, err := SpliceNonce(trytes, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err = giota.NewTransaction(transactionTrytes)
+	if err != nil {
+		return nil, err
+	}
+	if !transaction.HasValidNonce(int64(minWeightMagnitude)) {
+		return nil, &ErrInvalidNonce{Nonce: nonce, MinWeightMagnitude: minWeightMagnitude}
+	}
+	return transaction, nil
+}
+
+// PowFuncTimed does the POW like PowFunc and also returns the time the POW took on the device
+func (p *DiverClient) PowFuncTimed(trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, duration time.Duration, Error error) {
+	if p.IsClosed() {
+		return "", 0, ErrClientClosed
+	}
+	if err := p.checkExpectedPow(); err != nil {
+		return "", 0, err
+	}
+	release, err := p.acquirePowSlot(context.Background())
+	if err != nil {
+		return "", 0, err
+	}
+	defer release()
+
+	result, duration, err = p.PowClientImplementation.PowFuncTimedDefinition(p, trytes, minWeightMagnitude)
+	if err != nil {
+		return "", 0, err
+	}
+	return result, duration, p.verifyPow(trytes, result, minWeightMagnitude)
+}
+
+// PowFuncHighPriority does the POW like PowFunc, but the request is dequeued before all normal priority requests
+// waiting on the diverDriver, e.g. for interactive requests. A POW that is already running is not preempted.
+func (p *DiverClient) PowFuncHighPriority(trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	if p.IsClosed() {
+		return "", ErrClientClosed
+	}
+	if err := p.checkExpectedPow(); err != nil {
+		return "", err
+	}
+	release, err := p.acquirePowSlot(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	result, err = p.PowClientImplementation.PowFuncHighPriorityDefinition(p, trytes, minWeightMagnitude)
+	if err != nil {
+		return "", err
+	}
+	return result, p.verifyPow(trytes, result, minWeightMagnitude)
+}
+
+// PowFuncDryRun validates the request on the diverDriver like PowFunc without doing POW
+// The result is a placeholder nonce of only '9' trytes, so client integrations can be tested without using the device.
+func (p *DiverClient) PowFuncDryRun(trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	if p.IsClosed() {
+		return "", ErrClientClosed
+	}
+
+	return p.PowClientImplementation.PowFuncDryRunDefinition(p, trytes, minWeightMagnitude)
+}
+
+// PowFuncRaw does the POW like PowFunc, but passes the trytes and the nonce through as bytes, e.g. for benchmarks
+// The trytes are neither validated nor converted by the client, invalid trytes are rejected by the diverDriver.
+// PowFunc stays the safe default for applications.
+func (p *DiverClient) PowFuncRaw(trytes []byte, minWeightMagnitude int) (result []byte, Error error) {
+	if p.IsClosed() {
+		return nil, ErrClientClosed
+	}
+	if err := p.checkExpectedPow(); err != nil {
+		return nil, err
+	}
+	release, err := p.acquirePowSlot(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return p.PowClientImplementation.PowFuncRawDefinition(p, trytes, minWeightMagnitude)
+}
+
+// SpliceNonce returns the trytes of the transaction with the nonce at NonceTrinaryOffset
+func SpliceNonce(trytes giota.Trytes, nonce giota.Trytes) (transaction giota.Trytes, Error error) {
+	if len(trytes) != TransactionTrinarySize {
+		return "", fmt.Errorf("Wrong length of the transaction trytes! Length: %v, Expected: %v", len(trytes), TransactionTrinarySize)
+	}
+	if len(nonce) != TransactionTrinarySize-NonceTrinaryOffset {
+		return "", fmt.Errorf("Wrong length of the nonce! Length: %v, Expected: %v", len(nonce), TransactionTrinarySize-NonceTrinaryOffset)
+	}
+
+	return trytes[:NonceTrinaryOffset] + nonce, nil
+}
+
+// PowFuncContext does the POW and cancels it on the diverDriver, if the context is cancelled before the result is received
+func (p *DiverClient) PowFuncContext(ctx context.Context, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	if p.IsClosed() {
+		return "", ErrClientClosed
+	}
+	if err := p.checkExpectedPow(); err != nil {
+		return "", err
+	}
+	release, err := p.acquirePowSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	result, err = p.PowClientImplementation.PowFuncContextDefinition(ctx, p, trytes, minWeightMagnitude)
+	if err != nil {
+		return "", err
+	}
+	return result, p.verifyPow(trytes, result, minWeightMagnitude)
+}
+
+// verifyPow returns ErrInvalidNonce if the client has VerifyPoW set and the hash of the transaction with the nonce
+// doesn't end with minWeightMagnitude zero trits
+func (p *DiverClient) verifyPow(trytes giota.Trytes, nonce giota.Trytes, minWeightMagnitude int) error {
+	if !p.VerifyPoW || VerifyNonce(trytes, nonce, minWeightMagnitude) {
+		return nil
+	}
+	return &ErrInvalidNonce{Nonce: nonce, MinWeightMagnitude: minWeightMagnitude}
+}
+
+// PowResult is the result of an asynchronous POW request
+type PowResult struct {
+	Trytes giota.Trytes
+	Error  error
+}
+
+// PowFuncAsync does the POW in the background and delivers the result on the returned channel
+// Every call uses its own connection to the diverDriver, so the results of parallel requests don't interleave
+func (p *DiverClient) PowFuncAsync(trytes giota.Trytes, minWeightMagnitude int) <-chan PowResult {
+	result := make(chan PowResult, 1)
+	go func() {
+		resultTrytes, err := p.PowFunc(trytes, minWeightMagnitude)
+		result <- PowResult{Trytes: resultTrytes, Error: err}
+	}()
+	return result
 }
 
 func (p *DiverClient) GetPowFuncDefinition() PowFuncDefinition {
 	return p.PowClientImplementation.PowFuncDefinition
 }
 
+// GetPowInfo returns the versions of the diverDriver and the used POW implementation
+// The values don't change while the diverDriver is running, so the result is cached according to PowInfoCacheTTL
 func (p *DiverClient) GetPowInfo() (ServerVersion string, PowType string, PowVersion string, Error error) {
-	return p.PowClientImplementation.GetPowInfoDefinition(p)
+	if p.IsClosed() {
+		return "", "", "", ErrClientClosed
+	}
+
+	p.powInfoLock.Lock()
+	defer p.powInfoLock.Unlock()
+
+	if p.powInfo != nil && (p.PowInfoCacheTTL == 0 || time.Since(p.powInfo.fetched) < p.PowInfoCacheTTL) {
+		return p.powInfo.serverVersion, p.powInfo.powType, p.powInfo.powVersion, nil
+	}
+
+	serverVersion, powType, powVersion, err := p.PowClientImplementation.GetPowInfoDefinition(p)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	p.powInfo = nil
+	if p.PowInfoCacheTTL >= 0 {
+		p.powInfo = &powInfo{serverVersion: serverVersion, powType: powType, powVersion: powVersion, fetched: time.Now()}
+	}
+	return serverVersion, powType, powVersion, nil
+}
+
+// InvalidatePowInfo removes the cached result of GetPowInfo, e.g. if the diverDriver was restarted
+func (p *DiverClient) InvalidatePowInfo() {
+	p.powInfoLock.Lock()
+	defer p.powInfoLock.Unlock()
+
+	p.powInfo = nil
+	atomic.StoreInt32(&p.powChecked, 0)
+}
+
+// checkExpectedPow returns an ErrPowMismatch if the diverDriver does not use ExpectedPowType and ExpectedPowVersion
+// The check is done once and repeated after InvalidatePowInfo, e.g. if the diverDriver was restarted with another backend
+func (p *DiverClient) checkExpectedPow() error {
+	if (p.ExpectedPowType == "" && p.ExpectedPowVersion == "") || atomic.LoadInt32(&p.powChecked) != 0 {
+		return nil
+	}
+
+	_, powType, powVersion, err := p.GetPowInfo()
+	if err != nil {
+		return err
+	}
+
+	if (p.ExpectedPowType != "" && powType != p.ExpectedPowType) || (p.ExpectedPowVersion != "" && powVersion != p.ExpectedPowVersion) {
+		return &ErrPowMismatch{PowType: powType, PowVersion: powVersion, ExpectedPowType: p.ExpectedPowType, ExpectedPowVersion: p.ExpectedPowVersion}
+	}
+
+	atomic.StoreInt32(&p.powChecked, 1)
+	return nil
+}
+
+// acquirePowSlot waits until less than MaxConcurrency POW requests of the client are running
+// Every request uses its own connection, the slot only limits the number of requests at the same time.
+// It returns the function that frees the slot again, or the error of the context if it is done before a slot is free.
+func (p *DiverClient) acquirePowSlot(ctx context.Context) (release func(), err error) {
+	p.powSlotsOnce.Do(func() {
+		if p.MaxConcurrency > 0 {
+			p.powSlots = make(chan struct{}, p.MaxConcurrency)
+		}
+	})
+
+	if p.powSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case p.powSlots <- struct{}{}:
+		return func() { <-p.powSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 func (p *DiverClient) GetPowInfoFuncDefinition() PowFuncDefinition {
 	return p.PowClientImplementation.PowFuncDefinition
 }
+
+// Versions returns the versions of the diverDriver, the IPC protocol and the used POW implementation
+func (p *DiverClient) Versions() (Versions Versions, Error error) {
+	if p.IsClosed() {
+		return Versions, ErrClientClosed
+	}
+
+	return p.PowClientImplementation.GetVersionsDefinition(p)
+}
+
+// GetServerInfo returns the version and the build of the diverDriver
+func (p *DiverClient) GetServerInfo() (ServerInfo ServerInfo, Error error) {
+	if p.IsClosed() {
+		return ServerInfo, ErrClientClosed
+	}
+
+	return p.PowClientImplementation.GetServerInfoDefinition(p)
+}
+
+func (p *DiverClient) GetStats() (Stats Stats, Error error) {
+	if p.IsClosed() {
+		return Stats, ErrClientClosed
+	}
+
+	return p.PowClientImplementation.GetStatsDefinition(p)
+}
+
+// GetStatsAndReset returns the POW statistics like GetStats and sets the counters of the diverDriver to zero,
+// so the next call returns the statistics of the interval in between
+func (p *DiverClient) GetStatsAndReset() (Stats Stats, Error error) {
+	if p.IsClosed() {
+		return Stats, ErrClientClosed
+	}
+
+	return p.PowClientImplementation.GetStatsAndResetDefinition(p)
+}
+
+// Ping checks if the diverDriver is alive without doing POW and returns the round trip time
+func (p *DiverClient) Ping() (RoundTrip time.Duration, Error error) {
+	if p.IsClosed() {
+		return 0, ErrClientClosed
+	}
+
+	return p.PowClientImplementation.PingDefinition(p)
+}
+
+// WaitReady pings the diverDriver every interval until it answers, e.g. for readiness probes and startup scripts
+// It returns nil as soon as a Ping succeeds, or ErrNotReady with the error of the last Ping when the context is done.
+// A Ping that is already running is not interrupted by the context, it is bounded by the ReadTimeOutMs of the client.
+// An interval <= 0 pings every 100 ms.
+func (p *DiverClient) WaitReady(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if p.IsClosed() {
+			return ErrClientClosed
+		}
+
+		_, err := p.Ping()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return &ErrNotReady{Err: ctx.Err(), LastPingErr: err}
+		}
+	}
+}
+
+// SelfTest lets the diverDriver do the POW of a known transaction and verifies the nonce, e.g. for field diagnostics
+// Unlike Ping it uses the POW implementation, so it tests the whole chain including the device.
+// It returns whether the nonce was valid and the duration of the POW.
+func (p *DiverClient) SelfTest() (Passed bool, Duration time.Duration, Error error) {
+	if p.IsClosed() {
+		return false, 0, ErrClientClosed
+	}
+	release, err := p.acquirePowSlot(context.Background())
+	if err != nil {
+		return false, 0, err
+	}
+	defer release()
+
+	return p.PowClientImplementation.SelfTestDefinition(p)
+}
+
+// GetCapabilities returns the IPC commands supported by the diverDriver
+// The highest supported frame version is returned by Versions
+func (p *DiverClient) GetCapabilities() (Commands []byte, Error error) {
+	if p.IsClosed() {
+		return nil, ErrClientClosed
+	}
+
+	return p.PowClientImplementation.GetCapabilitiesDefinition(p)
+}
+
+// ListConnections returns the client connections of the diverDriver and the command each one is handling,
+// e.g. for monitoring tools to find out which client a wedged server is stuck on
+func (p *DiverClient) ListConnections() (Connections []ConnectionInfo, Error error) {
+	if p.IsClosed() {
+		return nil, ErrClientClosed
+	}
+
+	return p.PowClientImplementation.ListConnectionsDefinition(p)
+}
+
+// Close releases the resources of the client, e.g. the clients of a fallback client
+// Callers have to Close every client when it is no longer needed. All requests after Close return ErrClientClosed.
+func (p *DiverClient) Close() error {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return ErrClientClosed
+	}
+
+	if p.PowClientImplementation.CloseDefinition != nil {
+		return p.PowClientImplementation.CloseDefinition(p)
+	}
+	return nil
+}
+
+// IsClosed returns true if Close was called
+func (p *DiverClient) IsClosed() bool {
+	return atomic.LoadInt32(&p.closed) != 0
+}
+
+// HasCapability returns true if the command is in the list of supported commands
+func HasCapability(commands []byte, command byte) bool {
+	for _, c := range commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+// ErrReceiveTimeout is returned if the response of the diverDriver was not received in time
+// The request may succeed if it is repeated
+var ErrReceiveTimeout = errors.New("Receive timeout")
+
+// ErrClientClosed is returned for every request of a DiverClient after Close was called
+var ErrClientClosed = errors.New("DiverClient is closed")
+
+// ErrCircuitOpen is returned without sending the request, while the CircuitBreaker of the DiverClient is open
+var ErrCircuitOpen = errors.New("Circuit open, remote POW server failed repeatedly")
+
+// ErrNotReady is returned by WaitReady if the diverDriver did not answer a Ping before the context was done
+type ErrNotReady struct {
+	Err         error // Error of the context, e.g. context.DeadlineExceeded
+	LastPingErr error // Error of the last Ping
+}
+
+func (e *ErrNotReady) Error() string {
+	return fmt.Sprintf("diverDriver not ready: %v, last ping error: %v", e.Err, e.LastPingErr)
+}
+
+// Timeout returns true if the deadline of the context was exceeded
+func (e *ErrNotReady) Timeout() bool {
+	return e.Err == context.DeadlineExceeded
+}
+
+// Unwrap returns the error of the context, so errors.Is(err, context.DeadlineExceeded) works
+func (e *ErrNotReady) Unwrap() error {
+	return e.Err
+}
+
+// ErrMsgPowNotReady is the error message of the diverDriver for POW requests received before the POW backend was initialized
+const ErrMsgPowNotReady = "PoW backend not ready"
+
+// ErrMsgServerDraining is the error message of the diverDriver for POW requests received while it is in drain mode
+const ErrMsgServerDraining = "server draining"
+
+// ErrMsgPowReinitializing is the error message of the diverDriver for POW requests received while its POW backend is reinitialized
+const ErrMsgPowReinitializing = "PoW backend reinitializing"
+
+// ErrMsgUnknownCommand is the prefix of the error message of the diverDriver for commands it doesn't support
+// All versions of the diverDriver answer unknown commands with "Unknown command! Cmd: <IPC_CMD>".
+const ErrMsgUnknownCommand = "Unknown command!"
+
+// ErrChecksumMismatch is returned if the CRC8 of a received frame does not match its FRAME_DATA
+type ErrChecksumMismatch = ipccommon.ErrChecksumMismatch
+
+// ErrReqIDMismatch is returned if the response of the diverDriver belongs to another request
+type ErrReqIDMismatch struct {
+	ReqID    uint16 // REQ_ID of the response
+	Expected uint16 // REQ_ID of the request
+}
+
+func (e *ErrReqIDMismatch) Error() string {
+	return fmt.Sprintf("Wrong ReqID! ReqID: %X, Expected: %X", e.ReqID, e.Expected)
+}
+
+// ErrChunkedResponseNotRequested is returned if the diverDriver split a response into several frames,
+// although the client didn't request chunked responses (see DiverClient.ChunkedResponses)
+var ErrChunkedResponseNotRequested = errors.New("Chunked response not requested")
+
+// ErrResponseTooLong is returned if the frames of a chunked response exceed DiverClient.MaxResponseLength
+type ErrResponseTooLong struct {
+	Length  int // Length of the DATA received so far
+	Allowed int // Maximum accepted length of the DATA
+}
+
+func (e *ErrResponseTooLong) Error() string {
+	return fmt.Sprintf("Response too long! Length: %d, Allowed: %d", e.Length, e.Allowed)
+}
+
+// Errors of the diverDriver classified by the ERROR_CODE of the IpcCmdError (FRAME_VERSION==0x02 only)
+// The ErrServerError unwraps to them, e.g. errors.Is(err, common.ErrServerOverloaded)
+var (
+	ErrServerChecksum           = errors.New("Checksum mismatch of the request")
+	ErrServerInvalidTrytes      = errors.New("Invalid POW request or trytes")
+	ErrServerMwmTooHigh         = errors.New("MinWeightMagnitude too high or not allowed")
+	ErrServerDevice             = errors.New("POW implementation failed")
+	ErrServerOverloaded         = errors.New("Server overloaded")
+	ErrServerInvalidTransaction = errors.New("Invalid transaction")
+)
+
+// serverErrorsByCode maps the ERROR_CODE of an IpcCmdError to the typed error
+var serverErrorsByCode = map[byte]error{
+	ipccommon.ErrorCodeChecksum:           ErrServerChecksum,
+	ipccommon.ErrorCodeInvalidTrytes:      ErrServerInvalidTrytes,
+	ipccommon.ErrorCodeMwmTooHigh:         ErrServerMwmTooHigh,
+	ipccommon.ErrorCodeDevice:             ErrServerDevice,
+	ipccommon.ErrorCodeOverloaded:         ErrServerOverloaded,
+	ipccommon.ErrorCodeInvalidTransaction: ErrServerInvalidTransaction,
+}
+
+// ErrInvalidNonce is returned by the POW requests of a DiverClient with VerifyPoW and by PowTransaction, if the hash
+// of the transaction with the received nonce doesn't meet the MinWeightMagnitude, e.g. because of a buggy or malicious remote POW server
+type ErrInvalidNonce struct {
+	Nonce              giota.Trytes // Received nonce
+	MinWeightMagnitude int          // Requested MWM
+}
+
+func (e *ErrInvalidNonce) Error() string {
+	return fmt.Sprintf("Invalid nonce! The hash of the transaction doesn't meet the MinWeightMagnitude %d, Nonce: %v", e.MinWeightMagnitude, e.Nonce)
+}
+
+// ErrServerError is an error the diverDriver sent as answer to a request (IpcCmdError)
+type ErrServerError struct {
+	Code byte // ERROR_CODE of the error (ipccommon.ErrorCodeUnknown for FRAME_VERSION==0x01)
+	Msg  string
+}
+
+func (e *ErrServerError) Error() string {
+	return e.Msg
+}
+
+// Unwrap returns the typed error of the ERROR_CODE, or nil if the error is not classified
+func (e *ErrServerError) Unwrap() error {
+	return serverErrorsByCode[e.Code]
+}
+
+// IsPowNotReady returns true if the diverDriver rejected the request because its POW backend is not initialized yet
+// Unlike a failure of the device, the request may succeed if it is repeated later
+func IsPowNotReady(err error) bool {
+	var serverErr *ErrServerError
+	return errors.As(err, &serverErr) && serverErr.Msg == ErrMsgPowNotReady
+}
+
+// IsServerDraining returns true if the diverDriver rejected the request because it is in drain mode (e.g. before maintenance)
+// The request should be sent to another diverDriver
+func IsServerDraining(err error) bool {
+	var serverErr *ErrServerError
+	return errors.As(err, &serverErr) && serverErr.Msg == ErrMsgServerDraining
+}
+
+// IsUnknownCommand returns true if the diverDriver rejected the request because it doesn't support the command,
+// e.g. an older diverDriver that has to be asked with the commands it knows instead
+func IsUnknownCommand(err error) bool {
+	var serverErr *ErrServerError
+	return errors.As(err, &serverErr) && strings.HasPrefix(serverErr.Msg, ErrMsgUnknownCommand)
+}
+
+// ErrPowMismatch is returned for POW requests if the diverDriver does not use the POW implementation set in
+// ExpectedPowType and ExpectedPowVersion of the DiverClient
+type ErrPowMismatch struct {
+	PowType            string // POW type of the diverDriver
+	PowVersion         string // POW version of the diverDriver
+	ExpectedPowType    string // Expected POW type (empty = any)
+	ExpectedPowVersion string // Expected POW version (empty = any)
+}
+
+func (e *ErrPowMismatch) Error() string {
+	return fmt.Sprintf("Wrong POW implementation! PowType: %v, PowVersion: %v, Expected: %v %v", e.PowType, e.PowVersion, e.ExpectedPowType, e.ExpectedPowVersion)
+}
@@ -0,0 +1,55 @@
+package ipccommon
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DumpFrames reads the IPC frames of a captured byte stream and writes a human-readable line per frame to w:
+// frame version, REQ_ID, command name, DATA as hex and the result of the CRC8 check (CRC-8/MAXIM).
+// Dropped frames are written with the reason and the reader continues with the next frame.
+// It returns nil at the end of the stream, or the first error of r or w.
+func DumpFrames(r io.Reader, w io.Writer) error {
+	reader := NewFrameReader(r, 0, 0, nil)
+	for {
+		frame, err := reader.ReadFrame()
+
+		var frameErr *FrameError
+		switch {
+		case err == nil:
+			_, err = fmt.Fprintf(w, "V%d ReqID %d %s CRC ok DATA[%d] %s\n", frame.Version, frame.ReqID, commandName(frame.Command), len(frame.Data), hex.EncodeToString(frame.Data))
+
+		case errors.As(err, &frameErr):
+			var checksumErr *ErrChecksumMismatch
+			if errors.As(err, &checksumErr) {
+				_, err = fmt.Fprintf(w, "V%d ReqID %d CRC bad (%v)\n", frameErr.Version, frameErr.ReqID, err)
+			} else {
+				_, err = fmt.Fprintf(w, "V%d ReqID %d dropped (%v)\n", frameErr.Version, frameErr.ReqID, err)
+			}
+
+		case err == io.EOF:
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// commandName returns the name and the value of an IPC_CMD, including the flag of chunked responses
+func commandName(command byte) string {
+	flags := ""
+	if command&IpcCmdFlagMoreFollows != 0 {
+		flags = " more follows"
+		command &^= IpcCmdFlagMoreFollows
+	}
+
+	name, ok := CommandNames[command]
+	if !ok {
+		name = "Unknown"
+	}
+	return fmt.Sprintf("%s (%02X%s)", name, command, flags)
+}
@@ -0,0 +1,45 @@
+package ipccommon
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDumpFrames(t *testing.T) {
+	first := newMessageBytes(t, FrameVersionV1, 1, []byte("AB"))
+	second := newMessageBytes(t, FrameVersionV2, 0x1234, nil)
+
+	var out bytes.Buffer
+	if err := DumpFrames(bytes.NewReader(append(first, second...)), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "V1 ReqID 1 GetServerVersion (04) CRC ok DATA[2] 4142\n" +
+		"V2 ReqID 4660 GetServerVersion (04) CRC ok DATA[0] \n"
+	if out.String() != expected {
+		t.Errorf("Unexpected dump:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestDumpFramesCorrupted(t *testing.T) {
+	corrupted := newMessageBytes(t, FrameVersionV1, 1, []byte("AB"))
+	corrupted[len(corrupted)-1] ^= 0xFF
+	valid := newMessageBytes(t, FrameVersionV1, 2, []byte("CD"))
+
+	var out bytes.Buffer
+	if err := DumpFrames(bytes.NewReader(append(corrupted, valid...)), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines dumped, expected 2:\n%s", len(lines), out.String())
+	}
+	if !strings.HasPrefix(lines[0], "V1 ReqID 1 CRC bad (Wrong Checksum!") {
+		t.Errorf("Corrupted frame not reported: %s", lines[0])
+	}
+	if lines[1] != "V1 ReqID 2 GetServerVersion (04) CRC ok DATA[2] 4344" {
+		t.Errorf("Frame after the corrupted frame not dumped: %s", lines[1])
+	}
+}
@@ -0,0 +1,348 @@
+package ipccommon
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sigurn/crc8"
+)
+
+// ErrChecksumMismatch is returned if the CRC8 of a received frame does not match its FRAME_DATA
+type ErrChecksumMismatch struct {
+	CRC      byte // Checksum calculated over the received FRAME_DATA
+	Expected byte // Checksum received with the frame
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("Wrong Checksum! CRC: %X, Expected: %X", e.CRC, e.Expected)
+}
+
+// ErrFrameTooLong is returned if a received frame announces more FRAME_DATA than allowed
+type ErrFrameTooLong struct {
+	Length  int // FRAME_LENGTH announced by the frame
+	Allowed int // Maximum accepted FRAME_LENGTH
+}
+
+func (e *ErrFrameTooLong) Error() string {
+	return fmt.Sprintf("Frame too long! Length: %d, Allowed: %d", e.Length, e.Allowed)
+}
+
+// ErrUnsupportedFrameVersion is returned if a frame starts with a FRAME_VERSION unknown to this implementation
+type ErrUnsupportedFrameVersion struct {
+	Version byte // Received FRAME_VERSION
+}
+
+func (e *ErrUnsupportedFrameVersion) Error() string {
+	return fmt.Sprintf("unsupported frame version: 0x%02X", e.Version)
+}
+
+// FrameError is returned by FrameReader.ReadFrame if a received frame was dropped
+// The reader already searches the next frame, so ReadFrame can be called again
+type FrameError struct {
+	Version byte   // FRAME_VERSION of the dropped frame
+	ReqID   uint16 // REQ_ID of the dropped frame (0 if it was not received)
+	Err     error  // Reason why the frame was dropped
+}
+
+func (e *FrameError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// FrameReader reads IPC frames of all supported frame versions from a stream
+// Frames may be split across several reads, and several frames may be received in a single read
+type FrameReader struct {
+	reader         io.Reader
+	maxFrameLength int
+	crc8Table      *crc8.Table
+
+	// The received bytes are copied to frameData, so the buffer is reused for every read
+	buf []byte
+	// Received bytes that are not parsed yet
+	data []byte
+
+	// Optional limit of the DATA of single commands, see SetDataLengthLimit
+	dataLengthLimit func(version byte, command byte) int
+
+	frameState       byte
+	frameVersion     byte
+	frameLength      int
+	frameLengthBytes int
+	frameData        []byte
+	commandChecked   bool // True if the IPC_CMD of the current frame was checked against the dataLengthLimit
+	resyncing        bool // True while the rest of a dropped frame is skipped, until the next frame starts
+}
+
+// NewFrameReader creates a FrameReader that reads the stream in chunks of bufferSize bytes (0 = DefaultReadBufferSize)
+// Frames that announce more than maxFrameLength bytes (0 = maximum length of the frame version) are dropped before any data is buffered
+// The CRC8 of the frames is checked with crc8Table (nil = Crc8Table)
+func NewFrameReader(reader io.Reader, bufferSize int, maxFrameLength int, crc8Table *crc8.Table) *FrameReader {
+	if bufferSize <= 0 {
+		bufferSize = DefaultReadBufferSize
+	}
+	if crc8Table == nil {
+		crc8Table = Crc8Table
+	}
+
+	return &FrameReader{
+		reader:         reader,
+		maxFrameLength: maxFrameLength,
+		crc8Table:      crc8Table,
+		buf:            make([]byte, bufferSize),
+		frameState:     FrameStateSearchEnq,
+		frameVersion:   FrameVersionV1,
+	}
+}
+
+// SetDataLengthLimit sets a limit of the DATA of single commands, in addition to the maximum frame length
+// limit returns the maximum DATA_LENGTH for the frame version and the IPC_CMD (0 = no limit).
+// Frames that exceed it are dropped as soon as their IPC_CMD is received, before the DATA is buffered.
+func (r *FrameReader) SetDataLengthLimit(limit func(version byte, command byte) int) {
+	r.dataLengthLimit = limit
+}
+
+// ReadFrame returns the next valid frame of the stream
+// Dropped frames are reported as *FrameError, all other errors are returned by the underlying reader
+func (r *FrameReader) ReadFrame() (*IpcFrame, error) {
+	for {
+		if len(r.data) > 0 {
+			frame, err := r.parse()
+			if frame != nil || err != nil {
+				return frame, err
+			}
+		}
+
+		n, err := r.reader.Read(r.buf)
+		if n > len(r.buf) {
+			// The reader reported more bytes than fit into the buffer
+			return nil, io.ErrShortBuffer
+		}
+		if n == 0 && err != nil {
+			return nil, err
+		}
+		r.data = r.buf[:n]
+	}
+}
+
+// parse consumes the received bytes until a frame is completed, a frame is dropped, or all bytes are consumed
+func (r *FrameReader) parse() (*IpcFrame, error) {
+	for idx := 0; idx < len(r.data); idx++ {
+		switch r.frameState {
+
+		case FrameStateSearchEnq:
+			if r.data[idx] == FrameStartByte {
+				// Init variables for new message
+				r.frameLength = 0
+				r.frameLengthBytes = 0
+				r.frameData = nil
+				r.commandChecked = false
+				r.frameState = FrameStateSearchVersion
+			}
+
+		case FrameStateSearchVersion:
+			if IsSupportedFrameVersion(r.data[idx]) {
+				r.frameVersion = r.data[idx]
+				r.frameState = FrameStateSearchLength
+				r.resyncing = false
+			} else if r.resyncing && r.data[idx] != FrameStartByte {
+				// The ENQ is a byte inside the rest of a dropped frame
+				r.frameState = FrameStateSearchEnq
+			} else if r.data[idx] != FrameStartByte {
+				// The rest of the frame can't be parsed, so the error is reported as a version 1 frame without REQ_ID,
+				// which every peer understands. A repeated ENQ may be the real start of the frame.
+				err := &FrameError{Version: FrameVersionV1, Err: &ErrUnsupportedFrameVersion{Version: r.data[idx]}}
+				r.frameState = FrameStateSearchEnq
+				r.resyncing = true
+				r.data = r.data[idx+1:]
+				return nil, err
+			}
+
+		case FrameStateSearchLength:
+			// Receive the length big endian, 2 bytes for version 1 and 4 bytes for version 2
+			r.frameLength = r.frameLength<<8 | int(r.data[idx])
+			r.frameLengthBytes++
+			if r.frameLengthBytes == FrameLengthSize(r.frameVersion) {
+				allowedLength := MaxFrameLength(r.frameVersion)
+				if r.maxFrameLength > 0 && r.maxFrameLength < allowedLength {
+					allowedLength = r.maxFrameLength
+				}
+				if r.frameLength < 0 || r.frameLength > allowedLength {
+					// The REQ_ID is not received yet
+					err := &FrameError{Version: r.frameVersion, Err: &ErrFrameTooLong{Length: r.frameLength, Allowed: allowedLength}}
+					r.resync(r.data[idx+1:])
+					return nil, err
+				}
+				r.frameState = FrameStateSearchData
+			}
+
+		case FrameStateSearchData:
+			if r.dataLengthLimit != nil && !r.commandChecked && len(r.frameData) <= ReqIDSize(r.frameVersion) {
+				// The IPC_CMD follows the REQ_ID, check it before the DATA is buffered
+				if commandIdx := idx + ReqIDSize(r.frameVersion) - len(r.frameData); commandIdx < len(r.data) {
+					r.commandChecked = true
+					if err := r.checkDataLengthLimit(r.data[idx : commandIdx+1]); err != nil {
+						r.resync(r.data[idx:])
+						return nil, err
+					}
+				}
+			}
+
+			missingByteCount := r.frameLength - len(r.frameData)
+			availableByteCount := len(r.data) - idx
+
+			if availableByteCount >= missingByteCount {
+				// Frame completely received
+				r.frameData = append(r.frameData, r.data[idx:(idx+missingByteCount)]...)
+				// The index is incremented at the end of the loop, so it has to point to the last consumed byte.
+				// If no byte was missing, the current byte is already the CRC and has to be evaluated again.
+				idx += missingByteCount - 1
+				r.frameState = FrameStateSearchCRC
+			} else {
+				// Frame not completed in this read => Copy the remaining bytes
+				r.frameData = append(r.frameData, r.data[idx:]...)
+				idx = len(r.data)
+			}
+
+		case FrameStateSearchCRC:
+			frame, err := parseFrameData(r.frameVersion, r.frameData, r.data[idx], r.crc8Table)
+			if err != nil {
+				r.resync(r.data[idx:])
+				return nil, err
+			}
+
+			// Search for the next message
+			r.frameState = FrameStateSearchEnq
+			r.data = r.data[idx+1:]
+			return frame, nil
+		}
+	}
+
+	// Received bytes completely handled
+	r.data = nil
+	return nil, nil
+}
+
+// checkDataLengthLimit checks the FRAME_LENGTH against the dataLengthLimit of the IPC_CMD
+// received contains the bytes of the FRAME_DATA that are not buffered yet, up to and including the IPC_CMD.
+func (r *FrameReader) checkDataLengthLimit(received []byte) error {
+	header := append(r.frameData[:len(r.frameData):len(r.frameData)], received...)
+	reqIDSize := ReqIDSize(r.frameVersion)
+
+	limit := r.dataLengthLimit(r.frameVersion, header[reqIDSize])
+	if limit <= 0 {
+		return nil
+	}
+
+	// REQ_ID | IPC_CMD | DATA_LENGTH | DATA
+	allowedLength := reqIDSize + 1 + FrameLengthSize(r.frameVersion) + limit
+	if r.frameLength <= allowedLength {
+		return nil
+	}
+
+	reqID := uint16(0)
+	for _, b := range header[:reqIDSize] {
+		reqID = reqID<<8 | uint16(b)
+	}
+	return &FrameError{Version: r.frameVersion, ReqID: reqID, Err: &ErrFrameTooLong{Length: r.frameLength, Allowed: allowedLength}}
+}
+
+// ParseFrame parses a single complete IPC frame of any supported frame version, from the START_BYTE to the CRC8
+// The CRC8 is checked with crc8Table (nil = Crc8Table). Malformed frames are returned as error, never as frame.
+// Frames that were dropped after their header was parsed are reported as *FrameError, like by FrameReader.ReadFrame.
+func ParseFrame(data []byte, crc8Table *crc8.Table) (*IpcFrame, error) {
+	if crc8Table == nil {
+		crc8Table = Crc8Table
+	}
+
+	if len(data) < 2 {
+		return nil, fmt.Errorf("Frame too short! Length: %d, Required: %d", len(data), 2)
+	}
+	if data[0] != FrameStartByte {
+		return nil, fmt.Errorf("Wrong start byte! StartByte: %X, Expected: %X", data[0], FrameStartByte)
+	}
+
+	version := data[1]
+	if !IsSupportedFrameVersion(version) {
+		return nil, &FrameError{Version: FrameVersionV1, Err: &ErrUnsupportedFrameVersion{Version: version}}
+	}
+
+	// START_BYTE | FRAME_VERSION | FRAME_LENGTH | FRAME_DATA | CRC8
+	headerLength := 2 + FrameLengthSize(version)
+	if len(data) < headerLength+1 {
+		return nil, fmt.Errorf("Frame too short! Length: %d, Required: %d", len(data), headerLength+1)
+	}
+
+	frameLength := 0
+	for _, b := range data[2:headerLength] {
+		frameLength = frameLength<<8 | int(b)
+	}
+	if frameLength != len(data)-headerLength-1 {
+		return nil, fmt.Errorf("Wrong frame length! FrameLength: %d, Received: %d", frameLength, len(data)-headerLength-1)
+	}
+
+	return parseFrameData(version, data[headerLength:headerLength+frameLength], data[len(data)-1], crc8Table)
+}
+
+// parseFrameData converts the FRAME_DATA of a received frame to an IpcFrame after checking its CRC8
+// Every error is returned as *FrameError
+func parseFrameData(version byte, frameData []byte, expectedCRC byte, crc8Table *crc8.Table) (*IpcFrame, error) {
+	frame, err := BytesToIpcFrame(version, frameData)
+	if err != nil {
+		return nil, &FrameError{Version: version, Err: err}
+	}
+
+	crc := crc8.Checksum(frameData, crc8Table)
+	if expectedCRC != crc {
+		return nil, &FrameError{Version: frame.Version, ReqID: frame.ReqID, Err: &ErrChecksumMismatch{CRC: crc, Expected: expectedCRC}}
+	}
+
+	return frame, nil
+}
+
+// resync drops the current frame and continues parsing at the next plausible frame start.
+// The ENQ of the dropped frame may have been a byte inside the data of another frame, or a truncated frame may have
+// swallowed the start of the next frame. So the parser searches the next ENQ followed by a supported frame version
+// within the bytes of the dropped frame, instead of continuing at the first byte after the dropped frame.
+func (r *FrameReader) resync(remaining []byte) {
+	dropped := []byte{r.frameVersion}
+	for i := FrameLengthSize(r.frameVersion) - 1; i >= 0; i-- {
+		dropped = append(dropped, byte(r.frameLength>>uint(8*i)))
+	}
+	dropped = append(dropped, r.frameData...)
+
+	data := append(dropped, remaining...)
+	r.data = nil
+	for i := range data {
+		if data[i] != FrameStartByte {
+			continue
+		}
+		if i+1 == len(data) || IsSupportedFrameVersion(data[i+1]) {
+			r.data = data[i:]
+			break
+		}
+	}
+
+	r.frameData = nil
+	r.frameState = FrameStateSearchEnq
+	r.resyncing = true
+}
+
+// WriteFrame writes all bytes of the frame to the writer
+// A write that returns fewer bytes without an error is continued with the remaining bytes,
+// so the peer never receives a partial frame followed by the next one.
+func WriteFrame(w io.Writer, frame []byte) error {
+	for len(frame) > 0 {
+		n, err := w.Write(frame)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		frame = frame[n:]
+	}
+	return nil
+}
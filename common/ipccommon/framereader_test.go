@@ -0,0 +1,305 @@
+package ipccommon
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func newMessageBytes(t *testing.T, version byte, reqID uint16, data []byte) []byte {
+	t.Helper()
+
+	msg, err := NewIpcMessage(version, reqID, IpcCmdGetServerVersion, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgBytes, err := msg.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msgBytes
+}
+
+// chunkReader returns the bytes of the stream in chunks of the given size
+type chunkReader struct {
+	data      []byte
+	chunkSize int
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.chunkSize
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	n = copy(b, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestFrameReaderSplitAcrossReads(t *testing.T) {
+	stream := append(newMessageBytes(t, FrameVersionV1, 1, []byte("ABC9")), newMessageBytes(t, FrameVersionV2, 2, []byte("DEF9"))...)
+
+	for chunkSize := 1; chunkSize <= len(stream); chunkSize++ {
+		reader := NewFrameReader(&chunkReader{data: stream, chunkSize: chunkSize}, 0, 0, nil)
+
+		for i, expected := range []string{"ABC9", "DEF9"} {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				t.Fatalf("Chunk size %d: %v", chunkSize, err)
+			}
+			if frame.ReqID != uint16(i+1) || string(frame.Data) != expected {
+				t.Errorf("Chunk size %d: unexpected frame %+v", chunkSize, frame)
+			}
+		}
+
+		if _, err := reader.ReadFrame(); err != io.EOF {
+			t.Errorf("Chunk size %d: expected %v, got %v", chunkSize, io.EOF, err)
+		}
+	}
+}
+
+func TestFrameReaderSmallBuffer(t *testing.T) {
+	stream := newMessageBytes(t, FrameVersionV1, 7, bytes.Repeat([]byte("A"), 100))
+
+	reader := NewFrameReader(iotest.HalfReader(bytes.NewReader(stream)), 3, 0, nil)
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.ReqID != 7 || len(frame.Data) != 100 {
+		t.Errorf("Unexpected frame %+v", frame)
+	}
+}
+
+func TestFrameReaderChecksumMismatch(t *testing.T) {
+	corrupt := newMessageBytes(t, FrameVersionV1, 1, []byte("ABC9"))
+	corrupt[len(corrupt)-1]++
+	stream := append(corrupt, newMessageBytes(t, FrameVersionV1, 2, []byte("DEF9"))...)
+
+	reader := NewFrameReader(bytes.NewReader(stream), 0, 0, nil)
+
+	_, err := reader.ReadFrame()
+	var frameErr *FrameError
+	var checksumErr *ErrChecksumMismatch
+	if !errors.As(err, &frameErr) || !errors.As(err, &checksumErr) {
+		t.Fatalf("Expected a checksum error, got %v", err)
+	}
+	if frameErr.ReqID != 1 {
+		t.Errorf("Wrong ReqID %d of the dropped frame", frameErr.ReqID)
+	}
+
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.ReqID != 2 || string(frame.Data) != "DEF9" {
+		t.Errorf("Unexpected frame %+v", frame)
+	}
+}
+
+func TestFrameReaderResync(t *testing.T) {
+	// The truncated frame swallows the start of the valid frame
+	truncated := newMessageBytes(t, FrameVersionV1, 1, nil)
+	stream := append(truncated[:len(truncated)-3], newMessageBytes(t, FrameVersionV1, 2, []byte("DEF9"))...)
+
+	reader := NewFrameReader(bytes.NewReader(stream), 0, 0, nil)
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			var frameErr *FrameError
+			if !errors.As(err, &frameErr) {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if frame.ReqID != 2 || string(frame.Data) != "DEF9" {
+			t.Errorf("Unexpected frame %+v", frame)
+		}
+		break
+	}
+}
+
+func TestFrameReaderUnsupportedFrameVersion(t *testing.T) {
+	stream := append([]byte{FrameStartByte, 0x7F, 0x00, 0x01}, newMessageBytes(t, FrameVersionV1, 2, nil)...)
+
+	reader := NewFrameReader(bytes.NewReader(stream), 0, 0, nil)
+	_, err := reader.ReadFrame()
+	var frameErr *FrameError
+	var versionErr *ErrUnsupportedFrameVersion
+	if !errors.As(err, &frameErr) || !errors.As(err, &versionErr) || versionErr.Version != 0x7F {
+		t.Fatalf("Unsupported frame version not reported: %v", err)
+	}
+	if frameErr.Version != FrameVersionV1 || frameErr.ReqID != 0 {
+		t.Errorf("Error not reported as version 1 frame without ReqID: %+v", frameErr)
+	}
+
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.ReqID != 2 {
+		t.Errorf("Unexpected frame %+v", frame)
+	}
+}
+
+func TestFrameReaderFrameTooLong(t *testing.T) {
+	stream := append(newMessageBytes(t, FrameVersionV1, 1, bytes.Repeat([]byte("A"), 100)), newMessageBytes(t, FrameVersionV1, 2, nil)...)
+
+	reader := NewFrameReader(bytes.NewReader(stream), 0, 50, nil)
+
+	_, err := reader.ReadFrame()
+	var tooLongErr *ErrFrameTooLong
+	if !errors.As(err, &tooLongErr) || tooLongErr.Allowed != 50 {
+		t.Fatalf("Expected a frame too long error, got %v", err)
+	}
+
+	// The data of the dropped frame is skipped until the next frame start
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			t.Fatal("Frame after the dropped frame was not received")
+		}
+		if err == nil {
+			if frame.ReqID != 2 {
+				t.Errorf("Unexpected frame %+v", frame)
+			}
+			break
+		}
+	}
+}
+
+// shortWriter accepts at most max bytes per write without returning an error
+type shortWriter struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *shortWriter) Write(b []byte) (int, error) {
+	if len(b) > w.max {
+		b = b[:w.max]
+	}
+	return w.Buffer.Write(b)
+}
+
+func TestFrameReaderDataLengthLimit(t *testing.T) {
+	limit := func(version byte, command byte) int {
+		if command == IpcCmdGetServerVersion {
+			return 4
+		}
+		return 0
+	}
+	stream := append(newMessageBytes(t, FrameVersionV2, 1, []byte("ABCDE")), newMessageBytes(t, FrameVersionV2, 2, []byte("ABCD"))...)
+
+	// The IPC_CMD has to be found even if the header is split across reads
+	for _, chunkSize := range []int{1, 2, len(stream)} {
+		reader := NewFrameReader(&chunkReader{data: stream, chunkSize: chunkSize}, 0, 0, nil)
+		reader.SetDataLengthLimit(limit)
+
+		_, err := reader.ReadFrame()
+		var frameErr *FrameError
+		var tooLongErr *ErrFrameTooLong
+		if !errors.As(err, &frameErr) || !errors.As(err, &tooLongErr) || frameErr.ReqID != 1 {
+			t.Fatalf("Chunk size %d: Frame above the limit not rejected: %v", chunkSize, err)
+		}
+
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Chunk size %d: %v", chunkSize, err)
+		}
+		if frame.ReqID != 2 || string(frame.Data) != "ABCD" {
+			t.Errorf("Chunk size %d: Unexpected frame %+v", chunkSize, frame)
+		}
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	for _, version := range []byte{FrameVersionV1, FrameVersionV2} {
+		frameBytes := newMessageBytes(t, version, 7, []byte("ABC9"))
+
+		frame, err := ParseFrame(frameBytes, nil)
+		if err != nil {
+			t.Fatalf("V%d: %v", version, err)
+		}
+		if frame.Version != version || frame.ReqID != 7 || frame.Command != IpcCmdGetServerVersion || string(frame.Data) != "ABC9" {
+			t.Errorf("V%d: Unexpected frame %+v", version, frame)
+		}
+
+		corrupt := append([]byte{}, frameBytes...)
+		corrupt[len(corrupt)-1]++
+		var checksumErr *ErrChecksumMismatch
+		if _, err := ParseFrame(corrupt, nil); !errors.As(err, &checksumErr) {
+			t.Errorf("V%d: Checksum mismatch not detected: %v", version, err)
+		}
+
+		if _, err := ParseFrame(frameBytes[:len(frameBytes)-2], nil); err == nil {
+			t.Errorf("V%d: Truncated frame not detected", version)
+		}
+	}
+}
+
+// FuzzParseFrame checks that ParseFrame never panics, and that every frame it returns is valid:
+// it is encoded to the same bytes again and the FrameReader returns the same frame.
+func FuzzParseFrame(f *testing.F) {
+	for _, version := range []byte{FrameVersionV1, FrameVersionV2} {
+		for _, data := range [][]byte{nil, []byte("ABC9"), {0x05, 0x01, 0x05}} {
+			msg, _ := NewIpcMessage(version, 1, IpcCmdPowFunc, data)
+			frameBytes, _ := msg.ToBytes()
+			f.Add(frameBytes)
+		}
+	}
+	f.Add([]byte{})
+	f.Add([]byte{FrameStartByte, 0x7F})
+	f.Add([]byte{FrameStartByte, FrameVersionV1, 0x00, 0x00, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := ParseFrame(data, nil)
+		if err != nil {
+			if frame != nil {
+				t.Fatalf("Frame %+v returned with error %v", frame, err)
+			}
+			return
+		}
+
+		msg, err := NewIpcMessage(frame.Version, frame.ReqID, frame.Command, frame.Data)
+		if err != nil {
+			t.Fatalf("Parsed frame %+v can't be encoded: %v", frame, err)
+		}
+		encoded, err := msg.ToBytes()
+		if err != nil {
+			t.Fatalf("Parsed frame %+v can't be encoded: %v", frame, err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("Parsed frame %+v is encoded to %X instead of %X", frame, encoded, data)
+		}
+
+		readFrame, err := NewFrameReader(bytes.NewReader(data), 0, 0, nil).ReadFrame()
+		if err != nil {
+			t.Fatalf("FrameReader rejected the parsed frame: %v", err)
+		}
+		if readFrame.Version != frame.Version || readFrame.ReqID != frame.ReqID || readFrame.Command != frame.Command || !bytes.Equal(readFrame.Data, frame.Data) {
+			t.Fatalf("FrameReader returned %+v instead of %+v", readFrame, frame)
+		}
+	})
+}
+
+func TestWriteFrameShortWrites(t *testing.T) {
+	frame := bytes.Repeat([]byte("ABC9"), 100)
+
+	w := &shortWriter{max: 7}
+	if err := WriteFrame(w, frame); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.Bytes(), frame) {
+		t.Errorf("Frame not written completely: %d of %d bytes", w.Len(), len(frame))
+	}
+
+	// A writer that makes no progress must not loop forever
+	if err := WriteFrame(&shortWriter{max: 0}, frame); err != io.ErrShortWrite {
+		t.Errorf("Expected io.ErrShortWrite, got %v", err)
+	}
+}
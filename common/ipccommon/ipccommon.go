@@ -2,7 +2,12 @@ package ipccommon
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
 
 	"github.com/lunixbochs/struc"
 	"github.com/sigurn/crc8"
@@ -16,6 +21,102 @@ const (
 	IpcCmdGetPowType       = 0x05 // C => S: Get the name of the used POW implementation (e.g. PiDiver)
 	IpcCmdGetPowVersion    = 0x06 // C => S: Get the version of the used POW implementation (e.g. PiDiver FPGA Core Version)
 	IpcCmdPowFunc          = 0x07 // C => S: Do POW
+	IpcCmdGetVersions      = 0x08 // C => S: Get the versions of this application, the protocol and the used POW implementation
+	IpcCmdGetStats         = 0x09 // C => S: Get the POW statistics of the server
+	IpcCmdPing             = 0x0A // C => S: Check if the server is alive (answered immediately, without POW)
+	IpcCmdCancelPow        = 0x0B // C => S: Cancel a running POW request
+	IpcCmdAuth             = 0x0C // C => S: Authenticate the connection with a pre-shared key
+	IpcCmdGetCapabilities  = 0x0D // C => S: Get the supported commands and the highest frame version of the server
+	IpcCmdGetPowInfo       = 0x0E // C => S: Get the server version, the POW type and the POW version in a single request
+	IpcCmdPowFuncDryRun    = 0x0F // C => S: Validate a POW request without doing POW (answered with a placeholder nonce)
+	IpcCmdResend           = 0x10 // C => S: Send the response of a recent POW request with the same REQ_ID again (e.g. after a checksum error)
+	IpcCmdGetServerInfo    = 0x11 // C => S: Get the version, the build and the highest frame version of this application
+	IpcCmdAdmin            = 0x12 // C => S: Administrative operations, e.g. the drain mode (requires authentication with a pre-shared key)
+	IpcCmdSelfTest         = 0x13 // C => S: Do the POW of a known transaction and verify the nonce, to test the whole chain including the device
+	IpcCmdListConnections  = 0x14 // C => S: Get the client connections of the server and the command each one is handling
+)
+
+// CommandNames are the names of the IPC commands, used for logging and metrics
+var CommandNames = map[byte]string{
+	IpcCmdNotification:     "Notification",
+	IpcCmdResponse:         "Response",
+	IpcCmdError:            "Error",
+	IpcCmdGetServerVersion: "GetServerVersion",
+	IpcCmdGetPowType:       "GetPowType",
+	IpcCmdGetPowVersion:    "GetPowVersion",
+	IpcCmdPowFunc:          "PowFunc",
+	IpcCmdGetVersions:      "GetVersions",
+	IpcCmdGetStats:         "GetStats",
+	IpcCmdPing:             "Ping",
+	IpcCmdCancelPow:        "CancelPow",
+	IpcCmdAuth:             "Auth",
+	IpcCmdGetCapabilities:  "GetCapabilities",
+	IpcCmdGetPowInfo:       "GetPowInfo",
+	IpcCmdPowFuncDryRun:    "PowFuncDryRun",
+	IpcCmdResend:           "Resend",
+	IpcCmdGetServerInfo:    "GetServerInfo",
+	IpcCmdAdmin:            "Admin",
+	IpcCmdSelfTest:         "SelfTest",
+	IpcCmdListConnections:  "ListConnections",
+}
+
+// IpcCmdFlagMoreFollows is set in the IPC_CMD of every frame of a chunked response except the last one
+// The DATA of all frames with the same REQ_ID has to be concatenated by the client
+const IpcCmdFlagMoreFollows byte = 0x80
+
+// Flags of the optional client flags of an IpcCmdGetCapabilities request
+const (
+	ClientFlagChunkedResponses byte = 0x01 // The client accepts responses split into several frames (see IpcCmdFlagMoreFollows)
+)
+
+// Flags of an IpcCmdGetStats request
+const (
+	StatsFlagReset byte = 0x01 // The counters are set to zero after the snapshot was taken
+)
+
+// Operations of an IpcCmdAdmin request
+const (
+	AdminOpStatus byte = 0x00 // Only report the drain mode
+	AdminOpDrain  byte = 0x01 // Reject new POW requests with "server draining" (e.g. before maintenance)
+	AdminOpResume byte = 0x02 // Accept POW requests again
+	AdminOpReinit byte = 0x03 // Re-run the init of the POW backend (e.g. of the device), answered when the reinit is done
+)
+
+// Codes of an IpcCmdError (FRAME_VERSION==0x02 only), prepended to the error message so clients don't depend on its wording
+const (
+	ErrorCodeUnknown            byte = 0x00 // Not classified, only the message describes the error
+	ErrorCodeChecksum           byte = 0x01 // The CRC8 of the request did not match its FRAME_DATA
+	ErrorCodeInvalidTrytes      byte = 0x02 // The POW request or its trytes are malformed
+	ErrorCodeMwmTooHigh         byte = 0x03 // The MinWeightMagnitude is above the maximum or not allowed
+	ErrorCodeDevice             byte = 0x04 // The POW implementation failed, e.g. an error of the device
+	ErrorCodeOverloaded         byte = 0x05 // The server is overloaded or the rate limit was exceeded, the request may succeed later
+	ErrorCodeInvalidTransaction byte = 0x06 // The trytes don't parse into a valid transaction, e.g. a malformed value or address
+)
+
+// Flags of an IpcCmdPowFunc request (FRAME_VERSION==0x02 only)
+const (
+	PowFlagTimed        byte = 0x01 // The duration of the POW is prepended to the response
+	PowFlagHighPriority byte = 0x02 // The request is dequeued before normal priority requests (no preemption of a running POW)
+	PowFlagPackedTrytes byte = 0x04 // The trytes of the request are packed as trits (see PackTrytes)
+	PowFlagBackend      byte = 0x08 // The request names the POW backend of the diverDriver (see PowRequest.Backend)
+
+	knownPowFlags = PowFlagTimed | PowFlagHighPriority | PowFlagPackedTrytes | PowFlagBackend
+)
+
+const (
+	FrameStartByte  byte = 0x05           // ENQ Byte, start of the IPC frame
+	FrameVersionV1  byte = 0x01           // Version 1 of the IPC frame (8 bit REQ_ID, 16 bit lengths)
+	FrameVersionV2  byte = 0x02           // Version 2 of the IPC frame (16 bit REQ_ID, 32 bit lengths)
+	MaxFrameVersion      = FrameVersionV2 // Highest IPC frame version supported by this implementation
+
+	TransactionTrytesSize = 2673 // Trytes of a transaction, the payload of a POW response (8019 / 3)
+	NonceTrytesSize       = 27   // Trytes of the nonce at the end of a transaction (81 / 3)
+	DefaultReadBufferSize = 3072 // ((8019 is the TransactionTrinarySize) / 3) + Overhead) => 3072
+
+	DefaultMaxResponseLength = 1 << 20 // Maximum length of the DATA of a chunked response accepted by the clients by default
+
+	MaxFrameLengthV1 = 0xFFFF     // Maximum length of the FRAME_DATA of an IPC frame with version 1
+	MaxFrameLengthV2 = 0x7FFFFFFF // Maximum length of the FRAME_DATA of an IPC frame with version 2 (limited to fit into an int on all platforms)
 
 	// Different states of the receivement of the frame via interprocess communication
 	FrameStateSearchEnq     byte = 1 // FrameStateSearchEnq: Search the Start byte of the frame
@@ -25,8 +126,38 @@ const (
 	FrameStateSearchCRC     byte = 5 // Search the CRC checksum of the embedded data
 )
 
+// Crc8Table is the default table for the CRC8 of the frames (CRC-8/MAXIM)
 var Crc8Table = crc8.MakeTable(crc8.CRC8_MAXIM)
 
+// Crc8Variants contains the CRC8 variants that can be selected instead of the default table, e.g. to talk to tools
+// built against another variant during a migration. Server and client have to use the same variant.
+var Crc8Variants = map[string]crc8.Params{
+	"MAXIM":    crc8.CRC8_MAXIM,
+	"CCITT":    crc8.CRC8,
+	"CDMA2000": crc8.CRC8_CDMA2000,
+	"DARC":     crc8.CRC8_DARC,
+	"DVB-S2":   crc8.CRC8_DVB_S2,
+	"EBU":      crc8.CRC8_EBU,
+	"I-CODE":   crc8.CRC8_I_CODE,
+	"ITU":      crc8.CRC8_ITU,
+	"ROHC":     crc8.CRC8_ROHC,
+	"WCDMA":    crc8.CRC8_WCDMA,
+}
+
+// Crc8TableByName returns the CRC8 table of a variant in Crc8Variants
+// An empty name returns the default Crc8Table
+func Crc8TableByName(name string) (*crc8.Table, error) {
+	if name == "" || strings.EqualFold(name, "MAXIM") {
+		return Crc8Table, nil
+	}
+
+	params, ok := Crc8Variants[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown CRC8 variant: %v", name)
+	}
+	return crc8.MakeTable(params), nil
+}
+
 // IpcFrameV1 contains the information of the IPC communication
 type IpcFrameV1 struct {
 	ReqID      byte   `struc:"byte"`
@@ -48,19 +179,19 @@ func (f *IpcFrameV1) ToBytes() ([]byte, error) {
 
 // NewIpcMessageV1 creates a new IpcFrameV1 embedded in an IpcMessage
 func NewIpcMessageV1(requestID byte, command byte, data []byte) (*IpcMessage, error) {
-	frameLength := len(data)
-	if frameLength > 0xFFFF {
-		return nil, errors.New("Message is too big")
-	}
-
 	frame := &IpcFrameV1{ReqID: requestID, Command: command, DataLength: len(data), Data: data}
 	frameBytes, err := frame.ToBytes()
 	if err != nil {
 		return nil, err
 	}
 
+	frameLength := len(frameBytes)
+	if frameLength > MaxFrameLengthV1 {
+		return nil, errors.New("Message is too big")
+	}
+
 	crc8 := crc8.Checksum(frameBytes, Crc8Table)
-	message := &IpcMessage{StartByte: 0x05, FrameVersion: 0x01, FrameLength: frameLength, FrameData: frameBytes, CRC8: crc8}
+	message := &IpcMessage{StartByte: FrameStartByte, FrameVersion: FrameVersionV1, FrameLength: frameLength, FrameData: frameBytes, CRC8: crc8}
 
 	return message, nil
 }
@@ -85,6 +216,16 @@ func (m *IpcMessage) ToBytes() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// WriteTo packs the IpcMessage directly into the writer, without the copy into a buffer of ToBytes
+func (m *IpcMessage) WriteTo(w io.Writer) (int64, error) {
+	return packTo(w, m)
+}
+
+// SetCrc8Table recalculates the CRC8 of the message with the given table
+func (m *IpcMessage) SetCrc8Table(table *crc8.Table) {
+	m.CRC8 = crc8.Checksum(m.FrameData, table)
+}
+
 // BytesToIpcMessage converts a byte slice to an IpcMessage
 func BytesToIpcMessage(data []byte) (*IpcMessage, error) {
 	buf := bytes.NewBuffer(data)
@@ -99,7 +240,13 @@ func BytesToIpcMessage(data []byte) (*IpcMessage, error) {
 }
 
 // BytesToIpcFrameV1 converts a byte slice to an IpcFrameV1
+// The DATA_LENGTH has to match the received FRAME_DATA exactly
 func BytesToIpcFrameV1(data []byte) (*IpcFrameV1, error) {
+	// REQ_ID | IPC_CMD | DATA_LENGTH
+	if err := checkDataLength(data, 2, 2); err != nil {
+		return nil, err
+	}
+
 	buf := bytes.NewBuffer(data)
 
 	frame := new(IpcFrameV1)
@@ -110,3 +257,351 @@ func BytesToIpcFrameV1(data []byte) (*IpcFrameV1, error) {
 
 	return frame, nil
 }
+
+// checkDataLength checks that the DATA_LENGTH (big endian, lengthSize bytes at offset) matches the length of the DATA
+// The frames are not unpacked otherwise, because struc trusts the DATA_LENGTH
+func checkDataLength(frameData []byte, offset int, lengthSize int) error {
+	headerLength := offset + lengthSize
+	if len(frameData) < headerLength {
+		return fmt.Errorf("Frame too short! Length: %d, Required: %d", len(frameData), headerLength)
+	}
+
+	dataLength := 0
+	for _, b := range frameData[offset:headerLength] {
+		dataLength = dataLength<<8 | int(b)
+	}
+	if dataLength != len(frameData)-headerLength {
+		return fmt.Errorf("Wrong data length! DataLength: %d, Received: %d", dataLength, len(frameData)-headerLength)
+	}
+	return nil
+}
+
+// IpcFrameV2 contains the information of the IPC communication with 16 bit request IDs
+type IpcFrameV2 struct {
+	ReqID      uint16 `struc:"uint16"`
+	Command    byte   `struc:"byte"`
+	DataLength int    `struc:"uint32,sizeof=Data"`
+	Data       []byte `struc:"[]byte"`
+}
+
+// ToBytes converts an IpcFrameV2 to a byte slice
+func (f *IpcFrameV2) ToBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	err := struc.Pack(&buf, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// IpcMessageV2 is the container of an IpcFrameV2 with additional communication control data
+type IpcMessageV2 struct {
+	StartByte    byte   `struc:"byte"`
+	FrameVersion byte   `struc:"byte"`
+	FrameLength  int    `struc:"uint32,sizeof=FrameData"`
+	FrameData    []byte `struc:"[]byte"`
+	CRC8         byte   `struc:"byte"`
+}
+
+// ToBytes converts an IpcMessageV2 to a byte slice
+func (m *IpcMessageV2) ToBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	err := struc.Pack(&buf, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteTo packs the IpcMessageV2 directly into the writer, without the copy into a buffer of ToBytes
+func (m *IpcMessageV2) WriteTo(w io.Writer) (int64, error) {
+	return packTo(w, m)
+}
+
+// packTo packs the message into the writer and returns the number of written bytes
+// Short writes are continued like in WriteFrame, so the message is either written completely or an error is returned.
+func packTo(w io.Writer, message interface{}) (int64, error) {
+	fw := &fullWriter{w: w}
+	err := struc.Pack(fw, message)
+	return fw.n, err
+}
+
+// fullWriter writes every chunk completely via WriteFrame and counts the written bytes
+type fullWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (fw *fullWriter) Write(b []byte) (int, error) {
+	if err := WriteFrame(fw.w, b); err != nil {
+		return 0, err
+	}
+	fw.n += int64(len(b))
+	return len(b), nil
+}
+
+// SetCrc8Table recalculates the CRC8 of the message with the given table
+func (m *IpcMessageV2) SetCrc8Table(table *crc8.Table) {
+	m.CRC8 = crc8.Checksum(m.FrameData, table)
+}
+
+// NewIpcMessageV2 creates a new IpcFrameV2 embedded in an IpcMessageV2
+func NewIpcMessageV2(requestID uint16, command byte, data []byte) (*IpcMessageV2, error) {
+	frame := &IpcFrameV2{ReqID: requestID, Command: command, DataLength: len(data), Data: data}
+	frameBytes, err := frame.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	frameLength := len(frameBytes)
+	if frameLength > MaxFrameLengthV2 {
+		return nil, errors.New("Message is too big")
+	}
+
+	crc8 := crc8.Checksum(frameBytes, Crc8Table)
+	message := &IpcMessageV2{StartByte: FrameStartByte, FrameVersion: FrameVersionV2, FrameLength: frameLength, FrameData: frameBytes, CRC8: crc8}
+
+	return message, nil
+}
+
+// BytesToIpcFrameV2 converts a byte slice to an IpcFrameV2
+// The DATA_LENGTH has to match the received FRAME_DATA exactly
+func BytesToIpcFrameV2(data []byte) (*IpcFrameV2, error) {
+	// REQ_ID | IPC_CMD | DATA_LENGTH
+	if err := checkDataLength(data, 3, 4); err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(data)
+
+	frame := new(IpcFrameV2)
+	err := struc.Unpack(buf, &frame)
+	if err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// Message is an IPC message of any frame version that can be sent to the other side
+type Message interface {
+	ToBytes() ([]byte, error)
+	WriteTo(w io.Writer) (int64, error)
+	SetCrc8Table(table *crc8.Table)
+}
+
+// IpcFrame contains the information of an IPC frame independent of the frame version
+type IpcFrame struct {
+	Version byte
+	ReqID   uint16
+	Command byte
+	Data    []byte
+}
+
+// IsSupportedFrameVersion returns true if the frame version is known to this implementation
+func IsSupportedFrameVersion(version byte) bool {
+	return version == FrameVersionV1 || version == FrameVersionV2
+}
+
+// FrameLengthSize returns the size of the FRAME_LENGTH field of the frame version in bytes
+func FrameLengthSize(version byte) int {
+	if version == FrameVersionV1 {
+		return 2
+	}
+	return 4
+}
+
+// ReqIDSize returns the size of the REQ_ID field of the frame version in bytes
+func ReqIDSize(version byte) int {
+	if version == FrameVersionV1 {
+		return 1
+	}
+	return 2
+}
+
+// MinPowResponseFrameLength returns the length of the FRAME_DATA of a POW response with the frame version
+func MinPowResponseFrameLength(version byte) int {
+	// REQ_ID | IPC_CMD | DATA_LENGTH | DATA
+	return ReqIDSize(version) + 1 + FrameLengthSize(version) + TransactionTrytesSize
+}
+
+// MaxPowRequestDataLength returns the maximum length of the DATA of an IpcCmdPowFunc request with the frame version
+func MaxPowRequestDataLength(version byte) int {
+	if version == FrameVersionV1 {
+		// [0] MWM | [1..] Trytes
+		return 1 + TransactionTrytesSize
+	}
+	// [0..1] MWM | [2] Flags | [3] Length | [4..] Backend | Trytes (packed trytes are shorter)
+	return 3 + 1 + 0xFF + TransactionTrytesSize
+}
+
+// MaxFrameLength returns the maximum length of the FRAME_DATA of the frame version
+func MaxFrameLength(version byte) int {
+	if version == FrameVersionV1 {
+		return MaxFrameLengthV1
+	}
+	return MaxFrameLengthV2
+}
+
+// NewChunkedIpcMessages creates the IPC messages of a response that is split into frames of at most maxFrameLength bytes
+// of FRAME_DATA (0 = maximum length of the frame version). All messages except the last one have IpcCmdFlagMoreFollows set.
+func NewChunkedIpcMessages(version byte, requestID uint16, command byte, data []byte, maxFrameLength int) ([]Message, error) {
+	if maxFrameLength <= 0 || maxFrameLength > MaxFrameLength(version) {
+		maxFrameLength = MaxFrameLength(version)
+	}
+
+	// REQ_ID | IPC_CMD | DATA_LENGTH
+	chunkSize := maxFrameLength - (ReqIDSize(version) + 1 + FrameLengthSize(version))
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("Maximum frame length too small! Length: %d", maxFrameLength)
+	}
+
+	var messages []Message
+	for {
+		chunk, cmd := data, command
+		if len(data) > chunkSize {
+			chunk, cmd = data[:chunkSize], command|IpcCmdFlagMoreFollows
+		}
+
+		msg, err := NewIpcMessage(version, requestID, cmd, chunk)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+
+		data = data[len(chunk):]
+		if len(data) == 0 {
+			return messages, nil
+		}
+	}
+}
+
+// NewIpcMessage creates a new IPC message with the given frame version
+// The request ID is truncated to 8 bit for frame version 1
+func NewIpcMessage(version byte, requestID uint16, command byte, data []byte) (Message, error) {
+	switch version {
+
+	case FrameVersionV1:
+		return NewIpcMessageV1(byte(requestID), command, data)
+
+	case FrameVersionV2:
+		return NewIpcMessageV2(requestID, command, data)
+
+	default:
+		return nil, fmt.Errorf("Unsupported frame version! Version: %X", version)
+	}
+}
+
+// BytesToIpcFrame converts the FRAME_DATA of the given frame version to an IpcFrame
+func BytesToIpcFrame(version byte, data []byte) (*IpcFrame, error) {
+	switch version {
+
+	case FrameVersionV1:
+		frame, err := BytesToIpcFrameV1(data)
+		if err != nil {
+			return nil, err
+		}
+		return &IpcFrame{Version: version, ReqID: uint16(frame.ReqID), Command: frame.Command, Data: frame.Data}, nil
+
+	case FrameVersionV2:
+		frame, err := BytesToIpcFrameV2(data)
+		if err != nil {
+			return nil, err
+		}
+		return &IpcFrame{Version: version, ReqID: frame.ReqID, Command: frame.Command, Data: frame.Data}, nil
+
+	default:
+		return nil, fmt.Errorf("Unsupported frame version! Version: %X", version)
+	}
+}
+
+// EncodeErrorData encodes the DATA of an IpcCmdError
+// FRAME_VERSION==0x02: [0] ERROR_CODE | [1..] Message, FRAME_VERSION==0x01: Message only
+func EncodeErrorData(version byte, code byte, msg string) []byte {
+	if version == FrameVersionV1 {
+		return []byte(msg)
+	}
+	return append([]byte{code}, msg...)
+}
+
+// DecodeErrorData decodes the DATA of an IpcCmdError encoded by EncodeErrorData
+// Errors of FRAME_VERSION==0x01 are returned with ErrorCodeUnknown.
+func DecodeErrorData(version byte, data []byte) (code byte, msg string) {
+	if version == FrameVersionV1 || len(data) == 0 {
+		return ErrorCodeUnknown, string(data)
+	}
+	return data[0], string(data[1:])
+}
+
+// EncodeSelfTestResponse creates the DATA of the response to an IpcCmdSelfTest request
+// [0] Result (1 = nonce valid) | [1..4] Duration of the POW in ms (uint32, big endian)
+func EncodeSelfTestResponse(passed bool, durationMs int64) []byte {
+	data := EncodeTimedPowResponse(durationMs, "")
+	if passed {
+		return append([]byte{1}, data...)
+	}
+	return append([]byte{0}, data...)
+}
+
+// DecodeSelfTestResponse parses the DATA of the response to an IpcCmdSelfTest request
+func DecodeSelfTestResponse(data []byte) (passed bool, durationMs int64, err error) {
+	if len(data) != 5 {
+		return false, 0, fmt.Errorf("Wrong length of the self-test response! Length: %d, Expected: 5", len(data))
+	}
+	durationMs, _, err = DecodeTimedPowResponse(data[1:])
+	return data[0] == 1, durationMs, err
+}
+
+// EncodeTimedPowResponse creates the DATA of the response to an IpcCmdPowFunc request with PowFlagTimed
+// [0..3] Duration of the POW in ms (uint32, big endian) | [4..] Trytes
+func EncodeTimedPowResponse(durationMs int64, trytes string) []byte {
+	if durationMs < 0 {
+		durationMs = 0
+	} else if durationMs > math.MaxUint32 {
+		durationMs = math.MaxUint32
+	}
+
+	data := make([]byte, 4, 4+len(trytes))
+	binary.BigEndian.PutUint32(data, uint32(durationMs))
+	return append(data, trytes...)
+}
+
+// DecodeTimedPowResponse parses the DATA of the response to an IpcCmdPowFunc request with PowFlagTimed
+func DecodeTimedPowResponse(data []byte) (durationMs int64, trytes string, err error) {
+	if len(data) < 4 {
+		return 0, "", errors.New("POW response without duration")
+	}
+	return int64(binary.BigEndian.Uint32(data)), string(data[4:]), nil
+}
+
+// EncodePowInfo creates the DATA of an IpcCmdGetPowInfo response
+// Every value is prefixed by its length: [0] Length | [1..] ServerVersion | Length | PowType | Length | PowVersion
+func EncodePowInfo(serverVersion string, powType string, powVersion string) ([]byte, error) {
+	var data []byte
+	for _, value := range []string{serverVersion, powType, powVersion} {
+		if len(value) > 0xFF {
+			return nil, fmt.Errorf("POW info too long! Length: %d, Allowed: %d", len(value), 0xFF)
+		}
+		data = append(data, byte(len(value)))
+		data = append(data, value...)
+	}
+	return data, nil
+}
+
+// DecodePowInfo parses the DATA of an IpcCmdGetPowInfo response
+func DecodePowInfo(data []byte) (serverVersion string, powType string, powVersion string, err error) {
+	values := make([]string, 3)
+	for i := range values {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return "", "", "", errors.New("POW info truncated")
+		}
+		values[i] = string(data[1 : 1+int(data[0])])
+		data = data[1+int(data[0]):]
+	}
+	if len(data) > 0 {
+		return "", "", "", fmt.Errorf("POW info with %d trailing bytes", len(data))
+	}
+	return values[0], values[1], values[2], nil
+}
@@ -0,0 +1,63 @@
+package ipccommon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMessageWriteTo(t *testing.T) {
+	for _, version := range []byte{FrameVersionV1, FrameVersionV2} {
+		msg, err := NewIpcMessage(version, 1, IpcCmdResponse, bytes.Repeat([]byte("ABC9"), 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := msg.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The short writes are continued, so the written message matches ToBytes
+		w := &shortWriter{max: 7}
+		n, err := msg.WriteTo(w)
+		if err != nil {
+			t.Fatalf("Version %d: %v", version, err)
+		}
+		if n != int64(len(expected)) || !bytes.Equal(w.Bytes(), expected) {
+			t.Errorf("Version %d: wrote %d bytes, expected the %d bytes of ToBytes", version, n, len(expected))
+		}
+	}
+}
+
+// benchmarkLargeMessage sends a frame of 1 MiB, e.g. a large batch response, with the given function
+func benchmarkLargeMessage(b *testing.B, send func(msg Message) error) {
+	msg, err := NewIpcMessage(FrameVersionV2, 1, IpcCmdResponse, bytes.Repeat([]byte("A"), 1<<20))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := send(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMessageToBytes(b *testing.B) {
+	benchmarkLargeMessage(b, func(msg Message) error {
+		data, err := msg.ToBytes()
+		if err != nil {
+			return err
+		}
+		return WriteFrame(ioutil.Discard, data)
+	})
+}
+
+func BenchmarkMessageWriteTo(b *testing.B) {
+	benchmarkLargeMessage(b, func(msg Message) error {
+		_, err := msg.WriteTo(ioutil.Discard)
+		return err
+	})
+}
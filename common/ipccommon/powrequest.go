@@ -0,0 +1,133 @@
+package ipccommon
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/iotaledger/giota"
+)
+
+// ResendDigest returns the DATA of an IpcCmdResend request for the POW request with the given DATA
+// The ReqIDs of different clients collide, so the SHA-256 of the request identifies the response to send again.
+func ResendDigest(powRequestData []byte) []byte {
+	digest := sha256.Sum256(powRequestData)
+	return digest[:]
+}
+
+// PowRequest is the DATA of an IpcCmdPowFunc or IpcCmdPowFuncDryRun request
+type PowRequest struct {
+	MWM     int          // MinWeightMagnitude (0-255 for frame version 1, 0-65535 for frame version 2)
+	Flags   byte         // PowFlag*, require frame version 2
+	Backend string       // Name of the POW backend of the diverDriver, requires frame version 2 (empty = primary backend)
+	Trytes  giota.Trytes // Trytes of the transaction
+}
+
+// Encode creates the DATA of the request for the frame version
+// FRAME_VERSION==0x01: [0] MWM | [1..] Trytes
+// FRAME_VERSION==0x02: [0..1] MWM (uint16, big endian) | [2] Flags | [3..] Trytes (packed if PowFlagPackedTrytes is set)
+// A Backend is inserted before the trytes as [3] length | [4..] name and sets PowFlagBackend.
+func (r *PowRequest) Encode(version byte) ([]byte, error) {
+	var data []byte
+
+	switch version {
+
+	case FrameVersionV1:
+		if r.MWM < 0 || r.MWM > 0xFF {
+			return nil, fmt.Errorf("MinWeightMagnitude out of range for frame version 1 [0-255]: %v", r.MWM)
+		}
+		if r.Flags != 0 {
+			return nil, fmt.Errorf("POW request flags require frame version 2: %X", r.Flags)
+		}
+		if r.Backend != "" {
+			return nil, fmt.Errorf("POW backend requires frame version 2: %v", r.Backend)
+		}
+		data = []byte{byte(r.MWM)}
+
+	case FrameVersionV2:
+		if r.MWM < 0 || r.MWM > 0xFFFF {
+			return nil, fmt.Errorf("MinWeightMagnitude out of range for frame version 2 [0-65535]: %v", r.MWM)
+		}
+		flags := r.Flags &^ PowFlagBackend
+		if r.Backend != "" {
+			flags |= PowFlagBackend
+		}
+		data = []byte{byte(r.MWM >> 8), byte(r.MWM), flags}
+
+		if r.Backend != "" {
+			if len(r.Backend) > 0xFF {
+				return nil, fmt.Errorf("POW backend name too long [0-255]: %v", len(r.Backend))
+			}
+			data = append(data, byte(len(r.Backend)))
+			data = append(data, r.Backend...)
+		}
+
+		if r.Flags&PowFlagPackedTrytes != 0 {
+			packed, err := PackTrytes(string(r.Trytes))
+			if err != nil {
+				return nil, err
+			}
+			return append(data, packed...), nil
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported frame version! Version: %X", version)
+	}
+
+	return append(data, []byte(r.Trytes)...), nil
+}
+
+// DecodePowRequest parses the DATA of a request with the frame version (see PowRequest.Encode)
+// Unknown flags and invalid trytes are rejected
+func DecodePowRequest(version byte, data []byte) (*PowRequest, error) {
+	request := &PowRequest{}
+	var trytesData []byte
+
+	switch version {
+
+	case FrameVersionV1:
+		if len(data) < 1 {
+			return nil, errors.New("POW request without MinWeightMagnitude")
+		}
+		request.MWM = int(data[0])
+		trytesData = data[1:]
+
+	case FrameVersionV2:
+		if len(data) < 3 {
+			return nil, errors.New("POW request without MinWeightMagnitude and flags")
+		}
+		if unknownFlags := data[2] &^ knownPowFlags; unknownFlags != 0 {
+			return nil, fmt.Errorf("Unknown POW request flags: %X", unknownFlags)
+		}
+		request.MWM = int(data[0])<<8 | int(data[1])
+		request.Flags = data[2]
+		trytesData = data[3:]
+
+		if request.Flags&PowFlagBackend != 0 {
+			if len(trytesData) < 1 || len(trytesData) < 1+int(trytesData[0]) {
+				return nil, errors.New("POW request with incomplete backend name")
+			}
+			request.Backend = string(trytesData[1 : 1+int(trytesData[0])])
+			trytesData = trytesData[1+int(trytesData[0]):]
+		}
+
+		if request.Flags&PowFlagPackedTrytes != 0 {
+			unpacked, err := UnpackTrytes(trytesData)
+			if err != nil {
+				return nil, err
+			}
+			trytesData = []byte(unpacked)
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported frame version! Version: %X", version)
+	}
+
+	trytes, err := giota.ToTrytes(string(trytesData))
+	if err != nil {
+		return nil, err
+	}
+	request.Trytes = trytes
+
+	return request, nil
+}
@@ -0,0 +1,109 @@
+package ipccommon
+
+import (
+	"testing"
+)
+
+func TestPowRequestRoundtrip(t *testing.T) {
+	tests := []struct {
+		version byte
+		request PowRequest
+	}{
+		{FrameVersionV1, PowRequest{MWM: 14, Trytes: "ABC9"}},
+		{FrameVersionV2, PowRequest{MWM: 14, Trytes: "ABC9"}},
+		{FrameVersionV2, PowRequest{MWM: 300, Flags: PowFlagTimed | PowFlagHighPriority, Trytes: "XYZ"}},
+		{FrameVersionV2, PowRequest{MWM: 14, Flags: PowFlagPackedTrytes, Trytes: "HELLOWORLD99"}},
+		{FrameVersionV2, PowRequest{MWM: 14, Flags: PowFlagBackend | PowFlagPackedTrytes, Backend: "software", Trytes: "HELLOWORLD99"}},
+	}
+
+	for _, test := range tests {
+		data, err := test.request.Encode(test.version)
+		if err != nil {
+			t.Fatalf("Version %d: %v", test.version, err)
+		}
+		decoded, err := DecodePowRequest(test.version, data)
+		if err != nil {
+			t.Fatalf("Version %d: %v", test.version, err)
+		}
+		if *decoded != test.request {
+			t.Errorf("Version %d: decoded %+v, expected %+v", test.version, *decoded, test.request)
+		}
+	}
+}
+
+func TestPowRequestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		version byte
+		request PowRequest
+	}{
+		{FrameVersionV1, PowRequest{MWM: 256, Trytes: "ABC9"}},
+		{FrameVersionV1, PowRequest{MWM: 14, Flags: PowFlagTimed, Trytes: "ABC9"}},
+		{FrameVersionV1, PowRequest{MWM: 14, Backend: "software", Trytes: "ABC9"}},
+		{FrameVersionV2, PowRequest{MWM: -1, Trytes: "ABC9"}},
+		{0x03, PowRequest{MWM: 14, Trytes: "ABC9"}},
+	}
+
+	for _, test := range tests {
+		if _, err := test.request.Encode(test.version); err == nil {
+			t.Errorf("Version %d: %+v encoded without error", test.version, test.request)
+		}
+	}
+}
+
+func TestDecodePowRequestErrors(t *testing.T) {
+	tests := []struct {
+		version byte
+		data    []byte
+	}{
+		{FrameVersionV1, []byte{}},
+		{FrameVersionV2, []byte{0, 14}},
+		{FrameVersionV2, []byte{0, 14, 0x40, 'A'}},
+		{FrameVersionV2, []byte{0, 14, PowFlagPackedTrytes, 'A'}},
+		{FrameVersionV2, []byte{0, 14, PowFlagBackend, 5, 'A'}},
+		{FrameVersionV2, []byte{0, 14, 0, 'a'}},
+		{0x03, []byte{14, 'A'}},
+	}
+
+	for _, test := range tests {
+		if _, err := DecodePowRequest(test.version, test.data); err == nil {
+			t.Errorf("Version %d: %X decoded without error", test.version, test.data)
+		}
+	}
+}
+
+func TestPackTrytesRoundtrip(t *testing.T) {
+	for _, trytes := range []string{"", "9", "A", "Z", "M", "N", "ABC9", "9ABCDEFGHIJKLMNOPQRSTUVWXYZ", "HELLOWORLD99"} {
+		packed, err := PackTrytes(trytes)
+		if err != nil {
+			t.Fatalf("%q: %v", trytes, err)
+		}
+		if expected := 4 + (len(trytes)*3+4)/5; len(packed) != expected {
+			t.Errorf("%q: %d packed bytes, expected %d", trytes, len(packed), expected)
+		}
+
+		unpacked, err := UnpackTrytes(packed)
+		if err != nil {
+			t.Fatalf("%q: %v", trytes, err)
+		}
+		if unpacked != trytes {
+			t.Errorf("Unpacked %q, expected %q", unpacked, trytes)
+		}
+	}
+}
+
+func TestPackTrytesErrors(t *testing.T) {
+	if _, err := PackTrytes("AB-C"); err == nil {
+		t.Error("Invalid trytes packed without error")
+	}
+
+	for _, packed := range [][]byte{
+		{0, 0},             // Without length
+		{0, 0, 0, 2},       // Trits missing
+		{0, 0, 0, 1, 0, 0}, // Too many trits
+		{0, 0, 0, 1, 0x80}, // Out of range
+	} {
+		if _, err := UnpackTrytes(packed); err == nil {
+			t.Errorf("%X unpacked without error", packed)
+		}
+	}
+}
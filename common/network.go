@@ -0,0 +1,52 @@
+package common
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	NetworkUnix = "unix" // Unix domain socket, the default
+	NetworkTCP  = "tcp"  // Plain TCP, path "tcp://host:port"
+	NetworkTLS  = "tls"  // TCP secured by TLS, path "tls://host:port"
+	NetworkPipe = "pipe" // Windows named pipe, path "\\.\pipe\name"
+
+	PipePathPrefix  = `\\.\pipe\`                    // Prefix of the paths of Windows named pipes
+	DefaultPipePath = PipePathPrefix + "diverdriver" // Default path of the diverDriver on Windows
+
+	AbstractSocketPrefix = "@" // Prefix of Unix socket paths in the abstract namespace (Linux only), e.g. "@diverdriver"
+)
+
+// ErrAbstractSocketUnsupported is returned for Unix socket paths starting with "@" on platforms other than Linux
+var ErrAbstractSocketUnsupported = errors.New("Abstract Unix sockets are only supported on Linux")
+
+// ParseDiverDriverPath splits the path of the diverDriver into the network and the address
+// Paths starting with "\\.\pipe\" are Windows named pipes, other paths without a "tcp://" or "tls://" prefix are Unix socket paths
+// Unix socket paths starting with "@" are bound in the abstract namespace on Linux, see IsAbstractSocketPath
+func ParseDiverDriverPath(path string) (network string, address string) {
+	if strings.HasPrefix(path, PipePathPrefix) {
+		return NetworkPipe, path
+	}
+
+	for _, network := range []string{NetworkTCP, NetworkTLS} {
+		if prefix := network + "://"; strings.HasPrefix(path, prefix) {
+			return network, strings.TrimPrefix(path, prefix)
+		}
+	}
+	return NetworkUnix, path
+}
+
+// IsAbstractSocketPath returns true if the Unix socket path is a name in the abstract namespace ("@name")
+// Abstract sockets have no file, so there is no stale socket to remove and no file mode to set.
+func IsAbstractSocketPath(address string) bool {
+	return strings.HasPrefix(address, AbstractSocketPrefix)
+}
+
+// CheckUnixSocketPath returns ErrAbstractSocketUnsupported if the path is an abstract socket name on a platform without abstract sockets
+// Without the check, "@name" would silently be created as a socket file named "@name" in the working directory.
+func CheckUnixSocketPath(address string) error {
+	if IsAbstractSocketPath(address) && !abstractSocketsSupported {
+		return ErrAbstractSocketUnsupported
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build linux
+// +build linux
+
+package common
+
+// abstractSocketsSupported is true on Linux, the net package maps a leading "@" of a Unix socket path to the abstract namespace
+const abstractSocketsSupported = true
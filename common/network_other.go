@@ -0,0 +1,7 @@
+//go:build !linux
+// +build !linux
+
+package common
+
+// abstractSocketsSupported is false on other platforms, they have no abstract namespace for Unix sockets
+const abstractSocketsSupported = false
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"time"
+)
+
+// RetryPolicy defines how often a failed request to the diverDriver is repeated.
+// The IPC client only repeats failed connection attempts, a request that was sent is never repeated,
+// because the diverDriver would do the POW again. The zero value disables retries.
+type RetryPolicy struct {
+	MaxAttempts    int           // Maximum number of attempts including the first one (0 or 1 = no retry)
+	InitialBackoff time.Duration // Wait time before the first retry, doubled for every further retry
+	MaxBackoff     time.Duration // Upper limit of the wait time between two attempts (0 = no limit)
+}
+
+// Attempts returns the number of attempts allowed by the policy
+func (r *RetryPolicy) Attempts() int {
+	if r.MaxAttempts < 1 {
+		return 1
+	}
+	return r.MaxAttempts
+}
+
+// Backoff returns the wait time before the given retry (1 = first retry)
+func (r *RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := r.InitialBackoff
+	for i := 1; i < retry; i++ {
+		backoff *= 2
+		if r.MaxBackoff > 0 && backoff >= r.MaxBackoff {
+			break
+		}
+	}
+
+	if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+		backoff = r.MaxBackoff
+	}
+	return backoff
+}
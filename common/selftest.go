@@ -0,0 +1,28 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/iotaledger/giota"
+)
+
+// SelfTestMinWeightMagnitude is the MWM of the self-test, low enough to take only milliseconds on every POW implementation
+const SelfTestMinWeightMagnitude = 9
+
+// SelfTestTransaction is the fixed transaction the POW of the self-test is done for
+var SelfTestTransaction = giota.Trytes(strings.Repeat("9", TransactionTrinarySize))
+
+// VerifyNonce returns true if the hash of the transaction with the nonce ends with at least minWeightMagnitude zero trits
+// A nonce with the wrong length is never valid.
+func VerifyNonce(trytes giota.Trytes, nonce giota.Trytes, minWeightMagnitude int) bool {
+	transactionTrytes, err := SpliceNonce(trytes, nonce)
+	if err != nil {
+		return false
+	}
+
+	transaction, err := giota.NewTransaction(transactionTrytes)
+	if err != nil {
+		return false
+	}
+	return transaction.HasValidNonce(int64(minWeightMagnitude))
+}
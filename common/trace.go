@@ -0,0 +1,22 @@
+package common
+
+// Tracer receives the events of the requests of a DiverClient, e.g. to find out where the time of a slow POW is spent
+// The callbacks are called synchronously in the order of the events, so they should return quickly.
+// If the DiverClient has no Tracer, no events are created.
+type Tracer interface {
+	// DialDone is called when the connection to the diverDriver is established or dialing failed
+	DialDone(err error)
+	// WriteDone is called when the request was written to the connection
+	WriteDone(err error)
+	// FirstByte is called when the first byte of the response was received
+	FirstByte()
+	// FrameComplete is called when the response frame was received completely or receiving failed
+	FrameComplete(err error)
+}
+
+// EndpointTracer can be implemented in addition to Tracer to receive the endpoints of a DiverClient with
+// several remote POW servers, e.g. to find out which endpoint served a request
+type EndpointTracer interface {
+	// EndpointDone is called when a request to the endpoint succeeded or failed, before the next endpoint is tried
+	EndpointDone(endpoint string, err error)
+}
@@ -0,0 +1,78 @@
+package logs
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// durationRegex matches the duration in messages like "Finished PoW! Worker: 0, Time: 123 [ms]"
+var durationRegex = regexp.MustCompile(`Time: (\d+) \[ms\]`)
+
+// jsonRecord is a single log record in the JSON format
+type jsonRecord struct {
+	Level      string `json:"level"`
+	Time       string `json:"time"`
+	Package    string `json:"package"`
+	Func       string `json:"func"`
+	Message    string `json:"message"`
+	DurationMs *int64 `json:"duration_ms,omitempty"`
+}
+
+// jsonFormatter formats every log record as a single line JSON object
+type jsonFormatter struct{}
+
+// Format implements the logging.Formatter interface
+func (f *jsonFormatter) Format(calldepth int, r *logging.Record, output io.Writer) error {
+	record := jsonRecord{
+		Level:   r.Level.String(),
+		Time:    r.Time.Format(time.RFC3339Nano),
+		Message: r.Message(),
+	}
+
+	if pc, _, _, ok := runtime.Caller(calldepth + 1); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			record.Package, record.Func = splitFuncName(fn.Name())
+		}
+	}
+
+	// Durations are extracted, so they can be charted without parsing the message
+	if match := durationRegex.FindStringSubmatch(record.Message); match != nil {
+		if durationMs, err := strconv.ParseInt(match[1], 10, 64); err == nil {
+			record.DurationMs = &durationMs
+		}
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(line)
+	return err
+}
+
+// splitFuncName splits a full function name like "github.com/muxxer/diverdriver/server/ipc.powWorker"
+// into the short package name and the function name
+func splitFuncName(name string) (pkg string, fn string) {
+	i := strings.LastIndex(name, "/")
+	j := strings.Index(name[i+1:], ".")
+	if j < 0 {
+		return "", name
+	}
+	return name[i+1 : i+1+j], name[i+1+j+1:]
+}
+
+// SetupJSON logs to stdout with every record formatted as a JSON object
+// (fields: level, time, package, func, message and duration_ms for timing messages)
+func SetupJSON() {
+	backend1 := logging.NewLogBackend(os.Stdout, "", 0)
+	logging.SetFormatter(&jsonFormatter{})
+	logging.SetBackend(backend1)
+}
@@ -0,0 +1,35 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+func TestJSONFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	backend := logging.NewBackendFormatter(logging.NewLogBackend(&buf, "", 0), &jsonFormatter{})
+	leveled := logging.AddModuleLevel(backend)
+	leveled.SetLevel(logging.DEBUG, "")
+
+	logger := logging.MustGetLogger("test")
+	logger.SetBackend(leveled)
+	logger.Infof("Finished PoW! Worker: %d, Time: %d [ms]", 1, 123)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Output is not valid JSON: %v, %s", err, buf.String())
+	}
+
+	if record["level"] != "INFO" || record["package"] != "logs" || record["func"] != "TestJSONFormatter" {
+		t.Errorf("Unexpected record %v", record)
+	}
+	if record["message"] != "Finished PoW! Worker: 1, Time: 123 [ms]" {
+		t.Errorf("Unexpected message %v", record["message"])
+	}
+	if record["duration_ms"] != float64(123) {
+		t.Errorf("Unexpected duration_ms %v", record["duration_ms"])
+	}
+}
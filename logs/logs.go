@@ -4,9 +4,11 @@ import (
 	"os"
 
 	"github.com/op/go-logging"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var LOG_FORMAT = "%{color}[%{level:.4s}] %{time:15:04:05.000000} %{id:06x} [%{shortpkg}] %{longfunc} -> %{color:reset}%{message}"
+var LOG_FILE_FORMAT = "[%{level:.4s}] %{time:2006-01-02 15:04:05.000000} %{id:06x} [%{shortpkg}] %{longfunc} -> %{message}"
 var Log = logging.MustGetLogger("diverDriver")
 
 func Setup() {
@@ -15,6 +17,22 @@ func Setup() {
 	logging.SetBackend(backend1)
 }
 
+// SetupWithFile logs to stdout and additionally to a rotating log file
+// The file is rotated after it reached maxSizeMB megabytes, maxBackups old files are kept (0 = keep all)
+func SetupWithFile(path string, maxSizeMB int, maxBackups int) {
+	consoleBackend := logging.NewBackendFormatter(logging.NewLogBackend(os.Stdout, "", 0), logging.MustStringFormatter(LOG_FORMAT))
+
+	fileWriter := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+	}
+	fileBackend := logging.NewBackendFormatter(logging.NewLogBackend(fileWriter, "", 0), logging.MustStringFormatter(LOG_FILE_FORMAT))
+
+	// The log level is set for the combined backend, so it applies to both outputs
+	logging.SetBackend(consoleBackend, fileBackend)
+}
+
 func SetLogLevel(logLevel string) {
 	level, err := logging.LogLevel(logLevel)
 	if err == nil {
@@ -0,0 +1,54 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logs
+
+import (
+	"log/syslog"
+	"os"
+
+	"github.com/op/go-logging"
+)
+
+// SyslogPriority is the facility and severity of the messages sent to syslog, e.g. syslog.LOG_DAEMON|syslog.LOG_INFO
+// The severity of every message is derived from its log level, so only the facility is used.
+type SyslogPriority = syslog.Priority
+
+// DefaultSyslogPriority is the priority of the diverDriver when it runs as a daemon
+const DefaultSyslogPriority = syslog.LOG_DAEMON | syslog.LOG_INFO
+
+// LOG_SYSLOG_FORMAT omits the time and the colors, syslog and journald add the time themselves
+var LOG_SYSLOG_FORMAT = "[%{level:.4s}] [%{shortpkg}] %{longfunc} -> %{message}"
+
+// SetupSyslog logs to syslog (or journald) with the given tag instead of stdout
+// The log level set via SetLogLevel still applies.
+func SetupSyslog(tag string, priority SyslogPriority) error {
+	syslogBackend, err := newSyslogBackend(tag, priority)
+	if err != nil {
+		return err
+	}
+
+	logging.SetBackend(syslogBackend)
+	return nil
+}
+
+// SetupWithSyslog logs to stdout and additionally to syslog (or journald) with the given tag
+func SetupWithSyslog(tag string, priority SyslogPriority) error {
+	syslogBackend, err := newSyslogBackend(tag, priority)
+	if err != nil {
+		return err
+	}
+	consoleBackend := logging.NewBackendFormatter(logging.NewLogBackend(os.Stdout, "", 0), logging.MustStringFormatter(LOG_FORMAT))
+
+	// The log level is set for the combined backend, so it applies to both outputs
+	logging.SetBackend(consoleBackend, syslogBackend)
+	return nil
+}
+
+func newSyslogBackend(tag string, priority SyslogPriority) (logging.Backend, error) {
+	backend, err := logging.NewSyslogBackendPriority(tag, priority)
+	if err != nil {
+		return nil, err
+	}
+	return logging.NewBackendFormatter(backend, logging.MustStringFormatter(LOG_SYSLOG_FORMAT)), nil
+}
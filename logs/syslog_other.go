@@ -0,0 +1,24 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package logs
+
+import "errors"
+
+// SyslogPriority is the facility and severity of the messages sent to syslog (not supported on this platform)
+type SyslogPriority int
+
+// DefaultSyslogPriority is the priority of the diverDriver when it runs as a daemon
+const DefaultSyslogPriority SyslogPriority = 0
+
+var errSyslogNotSupported = errors.New("Syslog is not supported on this platform")
+
+// SetupSyslog is not supported on this platform, it returns an error and the log output is not changed
+func SetupSyslog(tag string, priority SyslogPriority) error {
+	return errSyslogNotSupported
+}
+
+// SetupWithSyslog is not supported on this platform, it returns an error and the log output is not changed
+func SetupWithSyslog(tag string, priority SyslogPriority) error {
+	return errSyslogNotSupported
+}
@@ -0,0 +1,59 @@
+package ipcserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+
+	"github.com/muxxer/diverdriver/common"
+)
+
+const authNonceSize = 32
+
+var (
+	errNotAuthenticated     = errors.New("not authenticated")
+	errAuthFailed           = errors.New("authentication failed")
+	errAuthNonceNotReceived = errors.New("authentication nonce not requested")
+)
+
+// authState is the authentication state of a single connection
+type authState struct {
+	key           []byte // Pre-shared key, authentication is disabled if empty
+	nonce         []byte // Nonce issued to the client, valid for a single attempt
+	authenticated bool
+}
+
+func newAuthState(key string) *authState {
+	return &authState{key: []byte(key), authenticated: key == ""}
+}
+
+// handleAuth handles the DATA of an IpcCmdAuth request and returns the DATA of the response
+// An empty request issues a new nonce, otherwise the request has to contain the HMAC of the nonce
+func (a *authState) handleAuth(data []byte) ([]byte, error) {
+	if len(a.key) == 0 {
+		// Authentication is disabled, an empty nonce tells the client that no HMAC is needed
+		return nil, nil
+	}
+
+	if len(data) == 0 {
+		a.nonce = make([]byte, authNonceSize)
+		if _, err := rand.Read(a.nonce); err != nil {
+			a.nonce = nil
+			return nil, err
+		}
+		return a.nonce, nil
+	}
+
+	if a.nonce == nil {
+		return nil, errAuthNonceNotReceived
+	}
+
+	expected := common.AuthHMAC(a.key, a.nonce)
+	a.nonce = nil
+	if !hmac.Equal(data, expected) {
+		return nil, errAuthFailed
+	}
+
+	a.authenticated = true
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package ipcserver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+func sendRequest(t *testing.T, c net.Conn, reqID byte, command byte, data []byte) *ipccommon.IpcFrame {
+	t.Helper()
+
+	msg, _ := ipccommon.NewIpcMessageV1(reqID, command, data)
+	request, _ := msg.ToBytes()
+	go c.Write(request)
+
+	return readResponse(t, c)
+}
+
+func TestHandleClientConnectionAuth(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	config := newTestConfig()
+	config.Set("server.authKey", "secret")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	powRequest := append([]byte{14}, []byte("ABC9")...)
+	if frame := sendRequest(t, client, 1, ipccommon.IpcCmdPowFunc, powRequest); string(frame.Data) != errNotAuthenticated.Error() {
+		t.Fatalf("POW was not rejected before authentication: %+v", frame)
+	}
+
+	// A wrong HMAC is rejected and invalidates the nonce
+	nonce := sendRequest(t, client, 2, ipccommon.IpcCmdAuth, nil).Data
+	if frame := sendRequest(t, client, 3, ipccommon.IpcCmdAuth, common.AuthHMAC([]byte("wrong"), nonce)); string(frame.Data) != errAuthFailed.Error() {
+		t.Fatalf("Wrong key was not rejected: %+v", frame)
+	}
+	if frame := sendRequest(t, client, 4, ipccommon.IpcCmdAuth, common.AuthHMAC([]byte("secret"), nonce)); string(frame.Data) != errAuthNonceNotReceived.Error() {
+		t.Fatalf("Nonce was accepted twice: %+v", frame)
+	}
+
+	nonce = sendRequest(t, client, 5, ipccommon.IpcCmdAuth, nil).Data
+	if frame := sendRequest(t, client, 6, ipccommon.IpcCmdAuth, common.AuthHMAC([]byte("secret"), nonce)); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Authentication failed: %s", frame.Data)
+	}
+
+	if frame := sendRequest(t, client, 7, ipccommon.IpcCmdPowFunc, powRequest); frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Errorf("POW was rejected after authentication: %+v", frame)
+	}
+}
@@ -0,0 +1,83 @@
+package ipcserver
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+// connRegistry keeps track of the active client connections and the command each one is handling,
+// so a wedged server can be inspected via IpcCmdListConnections
+type connRegistry struct {
+	mu    sync.Mutex
+	conns map[uint64]*common.ConnectionInfo
+}
+
+// activeConnections are the connections handled by HandleClientConnection
+var activeConnections = &connRegistry{conns: make(map[uint64]*common.ConnectionInfo)}
+
+// register adds the connection, it has to be removed with unregister when it is closed
+func (r *connRegistry) register(id uint64, peer string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.conns[id] = &common.ConnectionInfo{ID: id, Peer: peer, Opened: time.Now()}
+}
+
+func (r *connRegistry) unregister(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, id)
+}
+
+// setCommand records that the connection started handling the command
+func (r *connRegistry) setCommand(id uint64, command byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if conn, ok := r.conns[id]; ok {
+		conn.Command = ipccommon.CommandNames[command]
+		if conn.Command == "" {
+			conn.Command = "Unknown"
+		}
+		conn.CommandStarted = time.Now()
+	}
+}
+
+// clearCommand records that the connection waits for the next request
+func (r *connRegistry) clearCommand(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if conn, ok := r.conns[id]; ok {
+		conn.Command = ""
+		conn.CommandStarted = time.Time{}
+	}
+}
+
+// addPendingPow changes the number of unanswered POW requests of the connection by delta
+func (r *connRegistry) addPendingPow(id uint64, delta int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if conn, ok := r.conns[id]; ok {
+		conn.PendingPow += delta
+	}
+}
+
+// list returns a snapshot of the connections, ordered by their id
+func (r *connRegistry) list() []common.ConnectionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	connections := make([]common.ConnectionInfo, 0, len(r.conns))
+	for _, conn := range r.conns {
+		connections = append(connections, *conn)
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].ID < connections[j].ID })
+	return connections
+}
@@ -0,0 +1,117 @@
+package ipcserver
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+// listConnections requests the connections via IpcCmdListConnections
+func listConnections(t *testing.T, c net.Conn, reqID byte) []common.ConnectionInfo {
+	t.Helper()
+
+	frame := sendRequest(t, c, reqID, ipccommon.IpcCmdListConnections, nil)
+	if frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("ListConnections failed: %s", frame.Data)
+	}
+
+	var connections []common.ConnectionInfo
+	if err := json.Unmarshal(frame.Data, &connections); err != nil {
+		t.Fatal(err)
+	}
+	return connections
+}
+
+func TestHandleClientConnectionListConnections(t *testing.T) {
+	release := make(chan struct{})
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		<-release
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	config := newTestConfig()
+
+	powClient, powServer := net.Pipe()
+	defer powClient.Close()
+	go HandleClientConnection(powServer, config, "TestPow", "1.0")
+
+	adminClient, adminServer := net.Pipe()
+	defer adminClient.Close()
+	go HandleClientConnection(adminServer, config, "TestPow", "1.0")
+
+	// The POW request blocks in the POW implementation until it is released
+	msg, _ := ipccommon.NewIpcMessageV1(1, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...))
+	request, _ := msg.ToBytes()
+	go powClient.Write(request)
+
+	var powConn, adminConn *common.ConnectionInfo
+	deadline := time.Now().Add(2 * time.Second)
+	for powConn == nil && time.Now().Before(deadline) {
+		adminConn = nil
+		for _, conn := range listConnections(t, adminClient, 2) {
+			conn := conn
+			switch {
+			case conn.PendingPow == 1:
+				powConn = &conn
+			case conn.Command == ipccommon.CommandNames[ipccommon.IpcCmdListConnections]:
+				adminConn = &conn
+			}
+		}
+	}
+	if powConn == nil {
+		t.Fatal("The connection with the pending POW request was not listed")
+	}
+	if adminConn == nil || adminConn.CommandStarted.IsZero() || adminConn.ID == powConn.ID {
+		t.Errorf("The connection handling ListConnections was not listed: %+v", adminConn)
+	}
+	if powConn.Command != "" || !powConn.CommandStarted.IsZero() || powConn.Opened.IsZero() {
+		t.Errorf("Unexpected state of the connection with the pending POW request: %+v", powConn)
+	}
+
+	close(release)
+	if frame := readResponse(t, powClient); frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Fatalf("POW failed: %+v", frame)
+	}
+
+	// The connection is removed from the registry when it is closed
+	powClient.Close()
+	deadline = time.Now().Add(2 * time.Second)
+	for listed := true; listed; {
+		if time.Now().After(deadline) {
+			t.Fatal("The closed connection is still listed")
+		}
+		listed = false
+		for _, conn := range listConnections(t, adminClient, 3) {
+			if conn.ID == powConn.ID {
+				listed = true
+			}
+		}
+	}
+}
+
+func TestHandleClientConnectionListConnectionsAuth(t *testing.T) {
+	config := newTestConfig()
+	config.Set("server.authKey", "secret")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	if frame := sendRequest(t, client, 1, ipccommon.IpcCmdListConnections, nil); string(frame.Data) != errNotAuthenticated.Error() {
+		t.Fatalf("ListConnections was not rejected before authentication: %+v", frame)
+	}
+
+	nonce := sendRequest(t, client, 2, ipccommon.IpcCmdAuth, nil).Data
+	if frame := sendRequest(t, client, 3, ipccommon.IpcCmdAuth, common.AuthHMAC([]byte("secret"), nonce)); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Authentication failed: %s", frame.Data)
+	}
+	if connections := listConnections(t, client, 4); len(connections) == 0 {
+		t.Error("The own connection was not listed")
+	}
+}
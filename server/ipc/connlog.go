@@ -0,0 +1,67 @@
+package ipcserver
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/muxxer/diverdriver/logs"
+	"github.com/op/go-logging"
+)
+
+// lastConnID is the id of the last connection handled by HandleClientConnection
+var lastConnID uint64
+
+// connLogger prefixes the log messages of a connection with its id and peer,
+// so the log lines of interleaved connections can be told apart
+type connLogger struct {
+	logger *logging.Logger
+	prefix string
+	id     uint64
+	peer   string
+}
+
+// newConnLogger creates a logger for the next connection
+// The peer is determined once, at the time the connection was accepted
+func newConnLogger(c net.Conn) *connLogger {
+	id := atomic.AddUint64(&lastConnID, 1)
+	peer := describePeer(c)
+
+	return &connLogger{
+		// The logger uses the same module as logs.Log, so the log level applies to it as well.
+		// The extra call depth skips the wrapper, so the caller is reported in the log lines.
+		logger: &logging.Logger{Module: logs.Log.Module, ExtraCalldepth: 1},
+		prefix: fmt.Sprintf("[conn %d %s] ", id, peer),
+		id:     id,
+		peer:   peer,
+	}
+}
+
+func (l *connLogger) Debug(msg string) {
+	l.logger.Debug(l.prefix + msg)
+}
+
+func (l *connLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debugf(l.prefix+format, args...)
+}
+
+func (l *connLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Errorf(l.prefix+format, args...)
+}
+
+// describePeer returns the identity of the client for the audit log:
+// the PID and UID of the peer process for Unix sockets (if supported by the platform), the remote address otherwise
+func describePeer(c net.Conn) string {
+	if unixConn, ok := c.(*net.UnixConn); ok {
+		creds, err := peerCredentials(unixConn)
+		if err != nil {
+			return "unix"
+		}
+		return creds
+	}
+
+	if addr := c.RemoteAddr(); addr != nil && addr.String() != "" {
+		return addr.String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,66 @@
+package ipcserver
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+	"github.com/muxxer/diverdriver/logs"
+)
+
+var (
+	draining          int32 // 1 while the server is in drain mode
+	errServerDraining = errors.New(common.ErrMsgServerDraining)
+	errAdminNoAuthKey = errors.New("admin commands require server.authKey")
+	errUnknownAdminOp = errors.New("unknown admin operation")
+)
+
+// SetDraining enables or disables the drain mode of the server
+// While draining, new POW requests are rejected with "server draining", all other commands keep working,
+// so the clients can detect the state and switch to another server (e.g. before flashing the firmware of the device).
+// Running POW requests are not affected.
+func SetDraining(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	if atomic.SwapInt32(&draining, value) != value {
+		if enabled {
+			logs.Log.Info("Drain mode enabled, new POW requests are rejected")
+		} else {
+			logs.Log.Info("Drain mode disabled, accepting POW requests again")
+		}
+	}
+}
+
+// IsDraining returns true if the server is in drain mode
+func IsDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// handleAdmin handles the DATA of an IpcCmdAdmin request and returns the DATA of the response
+func handleAdmin(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errUnknownAdminOp
+	}
+
+	switch data[0] {
+	case ipccommon.AdminOpDrain:
+		SetDraining(true)
+	case ipccommon.AdminOpResume:
+		SetDraining(false)
+	case ipccommon.AdminOpReinit:
+		if err := ReinitPow(); err != nil {
+			return nil, err
+		}
+	case ipccommon.AdminOpStatus:
+	default:
+		return nil, errUnknownAdminOp
+	}
+
+	if IsDraining() {
+		return []byte{1}, nil
+	}
+	return []byte{0}, nil
+}
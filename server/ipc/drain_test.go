@@ -0,0 +1,69 @@
+package ipcserver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+func TestHandleClientConnectionDrain(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+	defer SetDraining(false)
+
+	config := newTestConfig()
+	config.Set("server.authKey", "secret")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	if frame := sendRequest(t, client, 1, ipccommon.IpcCmdAdmin, []byte{ipccommon.AdminOpDrain}); string(frame.Data) != errNotAuthenticated.Error() {
+		t.Fatalf("Admin command was not rejected before authentication: %+v", frame)
+	}
+
+	nonce := sendRequest(t, client, 2, ipccommon.IpcCmdAuth, nil).Data
+	if frame := sendRequest(t, client, 3, ipccommon.IpcCmdAuth, common.AuthHMAC([]byte("secret"), nonce)); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Authentication failed: %s", frame.Data)
+	}
+
+	if frame := sendRequest(t, client, 4, ipccommon.IpcCmdAdmin, []byte{ipccommon.AdminOpDrain}); frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "\x01" {
+		t.Fatalf("Drain mode was not enabled: %+v", frame)
+	}
+
+	powRequest := append([]byte{14}, []byte("ABC9")...)
+	frame := sendRequest(t, client, 5, ipccommon.IpcCmdPowFunc, powRequest)
+	if frame.Command != ipccommon.IpcCmdError || !common.IsServerDraining(&common.ErrServerError{Msg: string(frame.Data)}) {
+		t.Errorf("POW was not rejected while draining: %+v", frame)
+	}
+
+	// Everything except POW keeps working, so the clients can detect the state
+	if frame := sendRequest(t, client, 6, ipccommon.IpcCmdPing, nil); frame.Command != ipccommon.IpcCmdResponse {
+		t.Errorf("Ping failed while draining: %s", frame.Data)
+	}
+	if frame := sendRequest(t, client, 7, ipccommon.IpcCmdGetStats, nil); frame.Command != ipccommon.IpcCmdResponse || !getStats().Draining {
+		t.Errorf("Stats do not report the drain mode: %s", frame.Data)
+	}
+
+	if frame := sendRequest(t, client, 8, ipccommon.IpcCmdAdmin, []byte{ipccommon.AdminOpResume}); frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "\x00" {
+		t.Fatalf("Drain mode was not disabled: %+v", frame)
+	}
+	if frame := sendRequest(t, client, 9, ipccommon.IpcCmdPowFunc, powRequest); frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Errorf("POW was rejected after the drain mode was disabled: %+v", frame)
+	}
+}
+
+func TestHandleClientConnectionAdminWithoutAuthKey(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	if frame := sendRequest(t, client, 1, ipccommon.IpcCmdAdmin, []byte{ipccommon.AdminOpDrain}); string(frame.Data) != errAdminNoAuthKey.Error() || IsDraining() {
+		t.Errorf("Admin command was accepted without a pre-shared key: %+v", frame)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package ipcserver
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ToggleDrainOnSignal toggles the drain mode of the server every time the process receives SIGUSR1
+func ToggleDrainOnSignal() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGUSR1)
+	go func() {
+		for range sigc {
+			SetDraining(!IsDraining())
+		}
+	}()
+}
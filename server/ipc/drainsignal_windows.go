@@ -0,0 +1,7 @@
+//go:build windows
+// +build windows
+
+package ipcserver
+
+// ToggleDrainOnSignal does nothing on Windows, there is no SIGUSR1 (use IpcCmdAdmin instead)
+func ToggleDrainOnSignal() {}
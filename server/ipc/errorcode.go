@@ -0,0 +1,50 @@
+package ipcserver
+
+import (
+	"errors"
+
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+// errRateLimitExceeded is sent for POW requests beyond "pow.maxRequestsPerMinute" of the connection
+var errRateLimitExceeded = errors.New("rate limit exceeded")
+
+// codedError is an error with the ERROR_CODE of the IpcCmdError sent for it
+type codedError struct {
+	code byte
+	err  error
+}
+
+// withErrorCode classifies the error with the ERROR_CODE, the message is not changed
+func withErrorCode(code byte, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// errorCode returns the ERROR_CODE of the IpcCmdError sent for the error
+func errorCode(err error) byte {
+	var coded *codedError
+	var checksumErr *ipccommon.ErrChecksumMismatch
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.As(err, &checksumErr):
+		return ipccommon.ErrorCodeChecksum
+	case err == errServerOverloaded, err == errRateLimitExceeded:
+		return ipccommon.ErrorCodeOverloaded
+	}
+	return ipccommon.ErrorCodeUnknown
+}
+
+// newErrorMessage creates the IpcCmdError for the error
+// The ERROR_CODE is only sent with FRAME_VERSION==0x02, clients using 0x01 receive the message only.
+func newErrorMessage(version byte, reqID uint16, err error) (ipccommon.Message, error) {
+	return ipccommon.NewIpcMessage(version, reqID, ipccommon.IpcCmdError, ipccommon.EncodeErrorData(version, errorCode(err), err.Error()))
+}
@@ -0,0 +1,135 @@
+package ipcserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/logs"
+)
+
+// FailoverPowFunc sends all POW requests to the primary POW function until it becomes unhealthy.
+// Then the standby is promoted until the primary recovers.
+type FailoverPowFunc struct {
+	primary          giota.PowFunc
+	standby          giota.PowFunc
+	healthCheck      func() error  // Optional health check of the primary
+	failureThreshold int           // Number of consecutive failures until the primary is demoted
+	recheckInterval  time.Duration // Time to wait before the demoted primary is checked again
+
+	lock                sync.Mutex
+	primaryActive       bool
+	consecutiveFailures int
+	demotedAt           time.Time
+}
+
+// NewFailoverPowFunc creates a FailoverPowFunc with an active primary
+// If healthCheck is nil, the demoted primary is checked by sending the next request to it after the recheckInterval.
+func NewFailoverPowFunc(primary giota.PowFunc, standby giota.PowFunc, healthCheck func() error, failureThreshold int, recheckInterval time.Duration) *FailoverPowFunc {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+
+	return &FailoverPowFunc{
+		primary:          primary,
+		standby:          standby,
+		healthCheck:      healthCheck,
+		failureThreshold: failureThreshold,
+		recheckInterval:  recheckInterval,
+		primaryActive:    true,
+	}
+}
+
+// PrimaryActive returns true if the requests are sent to the primary
+func (f *FailoverPowFunc) PrimaryActive() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.primaryActive
+}
+
+// PowFunc does the POW with the active POW function
+// If the primary fails, the request is repeated with the standby
+func (f *FailoverPowFunc) PowFunc(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	if !f.usePrimary() {
+		return f.standby(trytes, mwm)
+	}
+
+	result, err := f.primary(trytes, mwm)
+	if err == nil {
+		f.primarySucceeded()
+		return result, nil
+	}
+
+	logs.Log.Debugf("Primary POW failed: %v", err)
+	if !f.primaryFailed() {
+		return "", err
+	}
+
+	return f.standby(trytes, mwm)
+}
+
+// usePrimary decides if the next request is sent to the primary
+func (f *FailoverPowFunc) usePrimary() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.primaryActive {
+		return true
+	}
+
+	if time.Since(f.demotedAt) < f.recheckInterval {
+		return false
+	}
+
+	if f.healthCheck == nil {
+		// Probe the primary with this request
+		return true
+	}
+
+	if err := f.healthCheck(); err != nil {
+		logs.Log.Debugf("Health check of the primary POW failed: %v", err)
+		f.demotedAt = time.Now()
+		return false
+	}
+
+	logs.Log.Info("Primary POW recovered. Demoting standby")
+	f.primaryActive = true
+	f.consecutiveFailures = 0
+	return true
+}
+
+// primarySucceeded resets the failure counter and promotes a recovered primary
+func (f *FailoverPowFunc) primarySucceeded() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.primaryActive {
+		logs.Log.Info("Primary POW recovered. Demoting standby")
+		f.primaryActive = true
+	}
+	f.consecutiveFailures = 0
+}
+
+// primaryFailed counts a failure of the primary and demotes it if necessary
+// It returns true if the request should be repeated with the standby
+func (f *FailoverPowFunc) primaryFailed() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.primaryActive {
+		// Probe of the demoted primary failed
+		f.demotedAt = time.Now()
+		return true
+	}
+
+	f.consecutiveFailures++
+	if f.consecutiveFailures < f.failureThreshold {
+		return false
+	}
+
+	logs.Log.Warningf("Primary POW failed %d times. Promoting standby", f.consecutiveFailures)
+	f.primaryActive = false
+	f.demotedAt = time.Now()
+	return true
+}
@@ -0,0 +1,53 @@
+package ipcserver
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+func TestFailoverPowFunc(t *testing.T) {
+	var primaryBroken int32
+	primary := func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if atomic.LoadInt32(&primaryBroken) == 1 {
+			return "", errors.New("device unplugged")
+		}
+		return "PRIMARY", nil
+	}
+	standby := func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "STANDBY", nil
+	}
+
+	f := NewFailoverPowFunc(primary, standby, nil, 2, 50*time.Millisecond)
+
+	expect := func(expected giota.Trytes, expectError bool) {
+		t.Helper()
+		result, err := f.PowFunc("ABC9", 14)
+		if (err != nil) != expectError || result != expected {
+			t.Fatalf("Unexpected result %q (error: %v), expected %q", result, err, expected)
+		}
+	}
+
+	expect("PRIMARY", false)
+
+	// The first failure is returned to the client, the second one promotes the standby
+	atomic.StoreInt32(&primaryBroken, 1)
+	expect("", true)
+	expect("STANDBY", false)
+	if f.PrimaryActive() {
+		t.Fatal("Primary still active after reaching the failure threshold")
+	}
+
+	// The primary is not probed before the recheck interval is over
+	atomic.StoreInt32(&primaryBroken, 0)
+	expect("STANDBY", false)
+
+	time.Sleep(60 * time.Millisecond)
+	expect("PRIMARY", false)
+	if !f.PrimaryActive() {
+		t.Fatal("Primary was not promoted after it recovered")
+	}
+}
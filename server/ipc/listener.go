@@ -0,0 +1,305 @@
+package ipcserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/muxxer/diverdriver/common/ipccommon"
+	"github.com/muxxer/diverdriver/logs"
+	"github.com/sigurn/crc8"
+	"github.com/spf13/viper"
+)
+
+// shutdownNotification is sent to all connected clients via IpcCmdNotification when the server shuts down
+const shutdownNotification = "server shutting down"
+
+// handlerPoolTimeout is the time a new connection waits for a free handler of "server.handlerPoolSize" before it is rejected
+const handlerPoolTimeout = 100 * time.Millisecond
+
+// Server accepts client connections on a listener and serves the IPC protocol until it is shut down
+type Server struct {
+	listener   net.Listener
+	config     *viper.Viper
+	powType    string
+	powVersion string
+
+	connSlots       chan struct{} // Counting semaphore for "server.maxConnections", nil = unlimited
+	handlerPoolSize int           // Number of goroutines handling the connections, see "server.handlerPoolSize" (0 = one per connection)
+	crc8Table       *crc8.Table   // CRC8 table of the variant selected by "server.crc8", used to reject connections
+	keepAlive       time.Duration // Interval of the TCP keepalive probes of accepted connections, see "server.keepAliveMs"
+
+	connsLock    sync.Mutex
+	conns        map[net.Conn]struct{}
+	connsWg      sync.WaitGroup
+	shuttingDown bool
+
+	serveDone chan struct{} // Closed when the accept loop started by Start returned
+}
+
+// NewServer creates a new Server that serves the clients accepted by the listener
+func NewServer(listener net.Listener, config *viper.Viper, powType string, powVersion string) *Server {
+	s := &Server{
+		listener:        listener,
+		config:          config,
+		powType:         powType,
+		powVersion:      powVersion,
+		conns:           make(map[net.Conn]struct{}),
+		crc8Table:       crc8TableFromConfig(config),
+		keepAlive:       time.Duration(config.GetInt("server.keepAliveMs")) * time.Millisecond,
+		handlerPoolSize: config.GetInt("server.handlerPoolSize"),
+	}
+
+	if maxConnections := config.GetInt("server.maxConnections"); maxConnections > 0 {
+		s.connSlots = make(chan struct{}, maxConnections)
+	}
+	return s
+}
+
+// Start accepts and serves client connections in the background until Stop or Shutdown is called
+func (s *Server) Start() {
+	s.serveDone = make(chan struct{})
+	go func() {
+		defer close(s.serveDone)
+		s.Serve()
+	}()
+}
+
+// Stop shuts the server down gracefully and waits until the accept loop started by Start returned
+func (s *Server) Stop() error {
+	err := s.Shutdown(context.Background())
+	if s.serveDone != nil {
+		<-s.serveDone
+	}
+	return err
+}
+
+// Serve accepts client connections until the server is shut down
+// With "server.handlerPoolSize", the connections are handled by a fixed number of goroutines. A connection that
+// finds no free handler within handlerPoolTimeout is rejected with "server busy".
+func (s *Server) Serve() error {
+	var handlerQueue chan net.Conn
+	if s.handlerPoolSize > 0 {
+		handlerQueue = make(chan net.Conn)
+		// The handlers finish their current connection and stop when the accept loop returned
+		defer close(handlerQueue)
+		for i := 0; i < s.handlerPoolSize; i++ {
+			go func() {
+				for c := range handlerQueue {
+					s.serveConnection(c)
+				}
+			}()
+		}
+	}
+
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			if s.isShuttingDown() {
+				return nil
+			}
+			logs.Log.Infof("Accept error: %v", err)
+			continue
+		}
+
+		if err := setKeepAlive(c, s.keepAlive); err != nil {
+			logs.Log.Debugf("Setting the keepalive of \"%v\" failed: %v", c.RemoteAddr(), err)
+		}
+
+		if !s.acquireConnectionSlot() {
+			logs.Log.Debugf("Connection limit reached, rejecting \"%v\"", c.RemoteAddr())
+			go rejectConnection(c, "server busy", s.crc8Table)
+			continue
+		}
+
+		if !s.addConnection(c) {
+			// Server is shutting down
+			s.releaseConnectionSlot()
+			c.Close()
+			return nil
+		}
+		logs.Log.Debugf("New connection accepted from \"%v\"", c.RemoteAddr())
+
+		if handlerQueue == nil {
+			go s.serveConnection(c)
+			continue
+		}
+
+		if !dispatchConnection(handlerQueue, c) {
+			logs.Log.Debugf("No free connection handler, rejecting \"%v\"", c.RemoteAddr())
+			s.removeConnection(c)
+			s.releaseConnectionSlot()
+			go rejectConnection(c, "server busy", s.crc8Table)
+		}
+	}
+}
+
+// serveConnection handles the connection until it is closed and frees its slot afterwards
+func (s *Server) serveConnection(c net.Conn) {
+	defer s.releaseConnectionSlot()
+	defer s.removeConnection(c)
+	HandleClientConnection(c, s.config, s.powType, s.powVersion)
+}
+
+// dispatchConnection waits up to handlerPoolTimeout for a free handler of the pool to take the connection
+// It returns false if all handlers stayed busy.
+func dispatchConnection(handlerQueue chan<- net.Conn, c net.Conn) bool {
+	timer := time.NewTimer(handlerPoolTimeout)
+	defer timer.Stop()
+
+	select {
+	case handlerQueue <- c:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Shutdown stops accepting new connections and waits until all active connections are closed.
+// All active connections receive an IpcCmdNotification, so the clients can send their next requests elsewhere.
+// Idle connections are closed immediately, requests that are already in progress are still answered.
+// If the context expires before all connections are closed, the remaining connections are closed forcefully.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connsLock.Lock()
+	s.shuttingDown = true
+	conns := make([]net.Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connsLock.Unlock()
+
+	s.notifyConnections(conns, shutdownNotification)
+
+	for _, c := range conns {
+		// Interrupt the connections that are waiting for the next request
+		c.SetReadDeadline(time.Now())
+	}
+
+	err := s.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.connsWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+
+	case <-ctx.Done():
+		s.connsLock.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.connsLock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// notifyConnections sends an IpcCmdNotification to all connections in parallel
+// Clients that don't read the notification within a second are skipped, so a stuck client can't block the shutdown.
+func (s *Server) notifyConnections(conns []net.Conn, notification string) {
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c net.Conn) {
+			defer wg.Done()
+
+			// The notification doesn't belong to a request, so it is sent with frame version 1 and ReqID 0
+			notificationMsg, _ := ipccommon.NewIpcMessageV1(0, ipccommon.IpcCmdNotification, []byte(notification))
+			c.SetWriteDeadline(time.Now().Add(time.Second))
+			if err := sendToClient(c, notificationMsg, clientLimits{}, s.crc8Table); err != nil {
+				logs.Log.Debugf("Sending notification to \"%v\" failed: %v", c.RemoteAddr(), err)
+			}
+			// Responses are written without a deadline
+			c.SetWriteDeadline(time.Time{})
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+
+	return s.shuttingDown
+}
+
+// addConnection registers an active connection. It returns false if the server is shutting down.
+func (s *Server) addConnection(c net.Conn) bool {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+
+	s.conns[c] = struct{}{}
+	s.connsWg.Add(1)
+	return true
+}
+
+// removeConnection unregisters a closed connection
+func (s *Server) removeConnection(c net.Conn) {
+	s.connsLock.Lock()
+	delete(s.conns, c)
+	s.connsLock.Unlock()
+
+	s.connsWg.Done()
+}
+
+// acquireConnectionSlot returns false if the maximum number of connections is reached
+func (s *Server) acquireConnectionSlot() bool {
+	if s.connSlots == nil {
+		return true
+	}
+
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseConnectionSlot frees the slot of a closed connection
+func (s *Server) releaseConnectionSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// setKeepAlive enables TCP keepalive probes with the given interval on TCP and TLS connections
+// (0 = default of Go, negative = disabled), so a connection to a dead peer is closed instead of waiting forever for the next request
+// Other connections are not changed, a Unix socket is closed by the system as soon as the peer is gone.
+func setKeepAlive(c net.Conn, period time.Duration) error {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+
+	tcpConn, ok := c.(*net.TCPConn)
+	if !ok || period == 0 {
+		return nil
+	}
+
+	if period < 0 {
+		return tcpConn.SetKeepAlive(false)
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return tcpConn.SetKeepAlivePeriod(period)
+}
+
+// rejectConnection sends a single IpcCmdError to the client and closes the connection
+// The request of the client was not read yet, so the error is sent with frame version 1 and ReqID 0
+func rejectConnection(c net.Conn, reason string, crc8Table *crc8.Table) {
+	defer c.Close()
+
+	c.SetWriteDeadline(time.Now().Add(time.Second))
+	responseMsg, _ := ipccommon.NewIpcMessageV1(0, ipccommon.IpcCmdError, []byte(reason))
+	sendToClient(c, responseMsg, clientLimits{}, crc8Table)
+}
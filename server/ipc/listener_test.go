@@ -0,0 +1,534 @@
+package ipcserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+func TestServerShutdownWaitsForRunningRequests(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		time.Sleep(200 * time.Millisecond)
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(ln, newTestConfig(), "TestPow", "1.0")
+	served := make(chan error, 1)
+	go func() { served <- server.Serve() }()
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	msg, _ := ipccommon.NewIpcMessageV1(1, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...))
+	request, _ := msg.ToBytes()
+	if _, err := c.Write(request); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait until the server started the POW
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- server.Shutdown(ctx) }()
+
+	// The client is notified before the running request is answered
+	frame := readResponse(t, c)
+	if frame.Command != ipccommon.IpcCmdNotification || string(frame.Data) != shutdownNotification {
+		t.Errorf("Unexpected notification %+v", frame)
+	}
+
+	frame = readResponse(t, c)
+	if frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Errorf("Unexpected response %+v", frame)
+	}
+
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+
+	if _, err := net.Dial("unix", path); err == nil {
+		t.Error("Server still accepts connections after shutdown")
+	}
+}
+
+func TestServerStartStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server := NewServer(ln, newTestConfig(), "TestPow", "1.0")
+		server.Start()
+
+		// Keep an idle connection open, it has to be closed by Stop
+		c, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request := newServerVersionRequest(t, byte(i))
+		if _, err := c.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		readResponse(t, c)
+
+		if err := server.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+
+	// Give the runtime some time to clean up the finished goroutines
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leaked := runtime.NumGoroutine() - goroutines; leaked > 0 {
+		t.Errorf("%d goroutines leaked", leaked)
+	}
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := newTestConfig()
+	config.Set("server.maxConnections", 1)
+	server := NewServer(ln, config, "TestPow", "1.0")
+	server.Start()
+	defer server.Stop()
+
+	c1, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the first connection is accepted before the second one is opened
+	c1.Write(newServerVersionRequest(t, 1))
+	if frame := readResponse(t, c1); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Unexpected response %+v", frame)
+	}
+
+	c2, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if frame := readResponse(t, c2); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != "server busy" {
+		t.Errorf("Connection over the limit was not rejected: %+v", frame)
+	}
+
+	// The slot is freed when the first connection is closed
+	c1.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	c3, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c3.Close()
+
+	c3.Write(newServerVersionRequest(t, 3))
+	if frame := readResponse(t, c3); frame.Command != ipccommon.IpcCmdResponse {
+		t.Errorf("Connection was rejected after a slot was freed: %+v", frame)
+	}
+}
+
+func TestServerHandlerPool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := newTestConfig()
+	config.Set("server.handlerPoolSize", 1)
+	server := NewServer(ln, config, "TestPow", "1.0")
+	server.Start()
+	defer server.Stop()
+
+	c1, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The single handler is busy with the first connection
+	c1.Write(newServerVersionRequest(t, 1))
+	if frame := readResponse(t, c1); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Unexpected response %+v", frame)
+	}
+
+	c2, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if frame := readResponse(t, c2); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != "server busy" {
+		t.Errorf("Connection without a free handler was not rejected: %+v", frame)
+	}
+
+	// The handler takes the next connection when the first one is closed
+	c1.Close()
+
+	c3, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c3.Close()
+
+	c3.Write(newServerVersionRequest(t, 3))
+	if frame := readResponse(t, c3); frame.Command != ipccommon.IpcCmdResponse {
+		t.Errorf("Connection was rejected after the handler was freed: %+v", frame)
+	}
+}
+
+// BenchmarkServerConnectionStorm opens short-lived connections from many clients at once and reports the
+// highest number of goroutines, with one goroutine per connection and with a pool of connection handlers
+func BenchmarkServerConnectionStorm(b *testing.B) {
+	for _, handlerPoolSize := range []int{0, 8} {
+		b.Run(fmt.Sprintf("handlerPoolSize=%d", handlerPoolSize), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "diverDriver.sock")
+			ln, err := net.Listen("unix", path)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			config := newTestConfig()
+			config.Set("server.handlerPoolSize", handlerPoolSize)
+			server := NewServer(ln, config, "TestPow", "1.0")
+			server.Start()
+			defer server.Stop()
+
+			var maxGoroutines int64
+			stopSampling := make(chan struct{})
+			samplingDone := make(chan struct{})
+			go func() {
+				defer close(samplingDone)
+				for {
+					if n := int64(runtime.NumGoroutine()); n > atomic.LoadInt64(&maxGoroutines) {
+						atomic.StoreInt64(&maxGoroutines, n)
+					}
+					select {
+					case <-stopSampling:
+						return
+					case <-time.After(100 * time.Microsecond):
+					}
+				}
+			}()
+
+			msg, _ := ipccommon.NewIpcMessageV1(1, ipccommon.IpcCmdGetServerVersion, nil)
+			request, _ := msg.ToBytes()
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				response := make([]byte, 64)
+				for pb.Next() {
+					c, err := net.Dial("unix", path)
+					if err != nil {
+						continue
+					}
+					// The response (or "server busy") is read before the connection is closed
+					c.SetDeadline(time.Now().Add(2 * time.Second))
+					c.Write(request)
+					c.Read(response)
+					c.Close()
+				}
+			})
+			b.StopTimer()
+
+			close(stopSampling)
+			<-samplingDone
+			b.ReportMetric(float64(atomic.LoadInt64(&maxGoroutines)), "max-goroutines")
+		})
+	}
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+
+	// A crashed diverDriver leaves the socket file behind
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	ln, err = Listen(path, nil)
+	if err != nil {
+		t.Fatalf("Listening on a stale socket failed: %v", err)
+	}
+	defer ln.Close()
+
+	// A socket that is still in use is not replaced
+	if _, err := Listen(path, nil); err == nil {
+		t.Error("Listening on a socket in use succeeded")
+	}
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Live socket was removed: %v", err)
+	}
+	c.Close()
+}
+
+func TestListenAbstractSocket(t *testing.T) {
+	path := fmt.Sprintf("@diverdriver-test-%d", os.Getpid())
+
+	ln, err := Listen(path, nil)
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			ln.Close()
+			t.Fatal("Listening on an abstract socket succeeded on a platform without abstract sockets")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Listening on an abstract socket failed: %v", err)
+	}
+
+	// No socket file is created in the working directory
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Socket file \"%v\" was created: %v", path, err)
+	}
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		ln.Close()
+		t.Fatalf("Dialing the abstract socket failed: %v", err)
+	}
+	c.Close()
+
+	// The name is free again as soon as the listener is closed
+	ln.Close()
+	ln, err = Listen(path, nil)
+	if err != nil {
+		t.Fatalf("Listening on the abstract socket again failed: %v", err)
+	}
+	ln.Close()
+}
+
+// panicListener returns connections whose reads panic, until panics is used up
+type panicListener struct {
+	net.Listener
+	panics int32
+}
+
+func (l *panicListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil && atomic.AddInt32(&l.panics, -1) >= 0 {
+		c = &panicConn{c}
+	}
+	return c, err
+}
+
+type panicConn struct {
+	net.Conn
+}
+
+func (c *panicConn) Read(b []byte) (int, error) {
+	panic("handler bug")
+}
+
+func TestServerRecoversFromHandlerPanic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(&panicListener{Listener: ln, panics: 1}, newTestConfig(), "TestPow", "1.0")
+	server.Start()
+	defer server.Stop()
+
+	// The handler of the first connection panics, only this connection is closed
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+	c.Close()
+
+	// The server keeps serving the following connections
+	c, err = net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if frame := sendRequest(t, c, 1, ipccommon.IpcCmdGetServerVersion, nil); frame.Command != ipccommon.IpcCmdResponse {
+		t.Errorf("Unexpected response after the panic %+v", frame)
+	}
+}
+
+func TestSetSocketPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("File modes of Unix sockets are not supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := Listen(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The own group is always allowed for the owner of the file
+	if err := SetSocketPermissions(path, "0660", strconv.Itoa(os.Getgid())); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("Unexpected socket mode %o, expected 660", info.Mode().Perm())
+	}
+
+	for _, mode := range []string{"rw", "0999", "07777"} {
+		if err := SetSocketPermissions(path, mode, ""); err == nil {
+			t.Errorf("Invalid mode %q accepted", mode)
+		}
+	}
+	if err := SetSocketPermissions(path, "", "no-such-group-diverdriver"); err == nil {
+		t.Error("Unknown group accepted")
+	}
+}
+
+func TestListenTCPBacklog(t *testing.T) {
+	ln, err := ListenWithBacklog("tcp://127.0.0.1:0", nil, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestListenTLSOnlyForTLSPath(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "diverDriver.sock")
+	for _, path := range []string{"tcp://127.0.0.1:0", sockPath} {
+		if ln, err := Listen(path, &tls.Config{}); err == nil {
+			ln.Close()
+			t.Errorf("TLS was accepted for %q", path)
+		}
+	}
+
+	if ln, err := Listen("tls://127.0.0.1:0", nil); err == nil {
+		ln.Close()
+		t.Error("A \"tls://\" path was accepted without TLS")
+	}
+}
+
+func TestServerRestartOnSamePath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+
+	for i := 0; i < 2; i++ {
+		ln, err := Listen(path, nil)
+		if err != nil {
+			t.Fatalf("Start %d: %v", i+1, err)
+		}
+
+		server := NewServer(ln, newTestConfig(), "TestPow", "1.0")
+		server.Start()
+		if err := server.Stop(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Socket file still exists after the shutdown: %v", err)
+		}
+	}
+}
+
+func TestDescribePeer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diverDriver.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	expected := "unix"
+	if runtime.GOOS == "linux" {
+		expected = fmt.Sprintf("pid=%d uid=%d", os.Getpid(), os.Getuid())
+	}
+	if peer := describePeer(server); peer != expected {
+		t.Errorf("Peer of the Unix socket: %q, expected %q", peer, expected)
+	}
+
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpLn.Close()
+
+	tcpClient, err := net.Dial("tcp", tcpLn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpClient.Close()
+
+	tcpServer, err := tcpLn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpServer.Close()
+
+	if peer := describePeer(tcpServer); peer != tcpClient.LocalAddr().String() {
+		t.Errorf("Peer of the TCP connection: %q, expected %q", peer, tcpClient.LocalAddr().String())
+	}
+}
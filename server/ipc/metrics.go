@@ -0,0 +1,107 @@
+package ipcserver
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/muxxer/diverdriver/common/ipccommon"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	metricsPowTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "diverdriver",
+		Name:      "pow_total",
+		Help:      "Number of successful POW operations.",
+	})
+	metricsPowFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "diverdriver",
+		Name:      "pow_failures_total",
+		Help:      "Number of failed POW operations.",
+	})
+	metricsPowDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "diverdriver",
+		Name:      "pow_duration_seconds",
+		Help:      "Duration of the successful POW operations.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10), // 50ms .. 25.6s
+	})
+	metricsQueueWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "diverdriver",
+		Name:      "pow_queue_wait_seconds",
+		Help:      "Time the POW requests waited for a worker.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10), // 1ms .. 262s
+	})
+	metricsPowMwm = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "diverdriver",
+		Name:      "pow_mwm",
+		Help:      "MinWeightMagnitude of the POW requests.",
+		Buckets:   prometheus.LinearBuckets(9, 1, 10), // 9 (testnet) .. 18, 14 = mainnet
+	})
+	metricsRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "diverdriver",
+		Name:      "requests_total",
+		Help:      "Number of received IPC requests by command.",
+	}, []string{"command"})
+	metricsActiveConnections = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "diverdriver",
+		Name:      "active_connections",
+		Help:      "Number of connected clients.",
+	}, func() float64 {
+		return float64(atomic.LoadInt64(&statsActiveConnections))
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(metricsPowTotal, metricsPowFailuresTotal, metricsPowDuration, metricsQueueWait, metricsPowMwm, metricsRequestsTotal, metricsActiveConnections)
+}
+
+// addRequestMetrics counts a received IPC request
+func addRequestMetrics(command byte) {
+	name, ok := ipccommon.CommandNames[command]
+	if !ok {
+		name = fmt.Sprintf("0x%02X", command)
+	}
+	metricsRequestsTotal.WithLabelValues(name).Inc()
+}
+
+// addPowMetrics counts a finished POW operation
+func addPowMetrics(durationMs int64, err error) {
+	if err != nil {
+		metricsPowFailuresTotal.Inc()
+		return
+	}
+	metricsPowTotal.Inc()
+	metricsPowDuration.Observe(float64(durationMs) / 1000)
+}
+
+// addMwmMetrics records the MWM of a POW request, like the MWM histogram of the stats
+func addMwmMetrics(mwm int) {
+	metricsPowMwm.Observe(float64(mwm))
+}
+
+// addQueueWaitMetrics records the time a POW request waited for a worker
+func addQueueWaitMetrics(waitMs int64) {
+	metricsQueueWait.Observe(float64(waitMs) / 1000)
+}
+
+// StartMetricsServer serves the Prometheus metrics on "/metrics" of the given address in the background
+// Errors of the HTTP server are reported on the returned channel
+func StartMetricsServer(addr string) (*http.Server, <-chan error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	errs := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	return server, errs
+}
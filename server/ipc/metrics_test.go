@@ -0,0 +1,62 @@
+package ipcserver
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+func TestMetricsServer(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	if frame := sendRequest(t, client, 1, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...)); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("POW failed: %s", frame.Data)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	metricsServer, _ := StartMetricsServer(addr)
+	defer metricsServer.Close()
+
+	var body string
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(20 * time.Millisecond) {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			continue
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		body = string(b)
+		break
+	}
+
+	for _, metric := range []string{
+		"diverdriver_pow_total",
+		"diverdriver_pow_duration_seconds_bucket",
+		`diverdriver_pow_mwm_bucket{le="14"}`,
+		`diverdriver_requests_total{command="PowFunc"}`,
+		"diverdriver_active_connections 1",
+	} {
+		if !strings.Contains(body, metric) {
+			t.Errorf("Metric %s missing:\n%s", metric, body)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package ipcserver
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerCredentials returns the PID and UID of the process on the other side of the Unix socket (SO_PEERCRED)
+func peerCredentials(c *net.UnixConn) (string, error) {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return "", err
+	}
+	if credErr != nil {
+		return "", credErr
+	}
+
+	return fmt.Sprintf("pid=%d uid=%d", cred.Pid, cred.Uid), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package ipcserver
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is only supported on Linux (SO_PEERCRED)
+func peerCredentials(c *net.UnixConn) (string, error) {
+	return "", errors.New("Peer credentials are only supported on Linux")
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package ipcserver
+
+import (
+	"errors"
+	"net"
+)
+
+// listenPipe is only supported on Windows, other platforms use Unix sockets
+func listenPipe(path string) (net.Listener, error) {
+	return nil, errors.New("Named pipes are only supported on Windows")
+}
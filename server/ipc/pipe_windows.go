@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package ipcserver
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// listenPipe creates a listener on a Windows named pipe
+func listenPipe(path string) (net.Listener, error) {
+	return winio.ListenPipe(path, nil)
+}
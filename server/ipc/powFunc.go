@@ -1,10 +1,19 @@
 package ipcserver
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/iotaledger/giota"
 	"github.com/muxxer/diverdriver/common"
 	"github.com/muxxer/diverdriver/common/ipccommon"
 	"github.com/muxxer/diverdriver/logs"
@@ -16,27 +25,44 @@ import (
 	Interprocess communication protocol
 	===================================
 
+	FRAME_VERSION==0x01:
 	[0] START_BYTE | [1] FRAME_VERSION | [2..3] FRAME_LENGTH | [4..4+FRAME_LENGTH] FRAME_DATA | [4+FRAME_LENGTH] CRC8
 
+	FRAME_VERSION==0x02:
+	[0] START_BYTE | [1] FRAME_VERSION | [2..5] FRAME_LENGTH | [6..6+FRAME_LENGTH] FRAME_DATA | [6+FRAME_LENGTH] CRC8
+
 	START_BYTE:
 		Start of the IPC frame
 		ENQ Byte (0x05) - Enquiry
 
 	FRAME_VERSION:
 		Version of the IPC frame, for future extensions of the protocol
+		The server responds with the same frame version the client used for the request.
+		0x01: 8 bit REQ_ID, 16 bit FRAME_LENGTH and DATA_LENGTH
+		0x02: 16 bit REQ_ID, 32 bit FRAME_LENGTH and DATA_LENGTH
+		Frames with an unknown version are answered with IpcCmdError "unsupported frame version: 0x<VERSION>",
+		sent with FRAME_VERSION==0x01 and REQ_ID 0 because the rest of the frame can't be parsed.
 
 	FRAME_LENGTH:
-		Size of the FRAME_DATA
+		Size of the FRAME_DATA (big endian)
 
 	FRAME_DATA:
 		----- FRAME_VERSION==0x01 -----
 
 		[4] REQ_ID | [5] IPC_CMD | [6..7] DATA_LENGTH | [8..8+DATA_LENGTH] DATA
 
+		----- FRAME_VERSION==0x02 -----
+
+		[6..7] REQ_ID | [8] IPC_CMD | [9..12] DATA_LENGTH | [13..13+DATA_LENGTH] DATA
+
+		The offsets of the DATA below are given for FRAME_VERSION==0x01.
+
 		REQ_ID:
 			ID of the message, set by the client.
 			Server will respond to the client with the same ID.
 			This way the client knows which response is assigned to which request.
+			A client may send further requests on the connection while a POW request is running. The POW requests
+			are answered as soon as they are done, so their responses may arrive out of order.
 
 		IPC_CMD:
 			IpcCmdNotification     = 0x01 // S => C: Text messages to the client
@@ -46,6 +72,19 @@ import (
 			IpcCmdGetPowType       = 0x05 // C => S: Get the name of the used POW implementation (e.g. PiDiver)
 			IpcCmdGetPowVersion    = 0x06 // C => S: Get the version of the used POW implementation (e.g. PiDiver FPGA Core Version)
 			IpcCmdPowFunc          = 0x07 // C => S: Do POW
+			IpcCmdGetVersions      = 0x08 // C => S: Get the versions of this application, the protocol and the used POW implementation
+			IpcCmdGetStats         = 0x09 // C => S: Get the POW statistics of the server
+			IpcCmdPing             = 0x0A // C => S: Check if the server is alive (answered immediately, without POW)
+			IpcCmdCancelPow        = 0x0B // C => S: Cancel a running POW request
+			IpcCmdAuth             = 0x0C // C => S: Authenticate the connection with a pre-shared key
+			IpcCmdGetCapabilities  = 0x0D // C => S: Get the supported commands and the highest frame version of the server
+			IpcCmdGetPowInfo       = 0x0E // C => S: Get the server version, the POW type and the POW version in a single request
+			IpcCmdPowFuncDryRun    = 0x0F // C => S: Validate a POW request without doing POW (answered with a placeholder nonce)
+			IpcCmdResend           = 0x10 // C => S: Send the response of a recent POW request with the same REQ_ID again (e.g. after a checksum error)
+			IpcCmdGetServerInfo    = 0x11 // C => S: Get the version, the build and the highest frame version of this application
+			IpcCmdAdmin            = 0x12 // C => S: Administrative operations, e.g. the drain mode (requires authentication with a pre-shared key)
+			IpcCmdSelfTest         = 0x13 // C => S: Do the POW of a known transaction and verify the nonce, to test the whole chain including the device
+			IpcCmdListConnections  = 0x14 // C => S: Get the client connections of the server and the command each one is handling
 
 		DATA_LENGTH:
 			Size of the DATA
@@ -55,12 +94,22 @@ import (
 
 			----- IPC_CMD==IpcCmdNotification -----
 			[8..8+DATA_LENGTH]	String	Notification
+			Sent with FRAME_VERSION==0x01 and REQ_ID 0 at any time, e.g. "server shutting down" to all active connections
+			or "MinWeightMagnitude clamped to <MWM>" before the result of a POW request.
 
 			----- IPC_CMD==IpcCmdResponse -----
 			[8..8+DATA_LENGTH] ReponseData
 
 			----- IPC_CMD==IpcCmdError -----
+			FRAME_VERSION==0x01:
 			[8..8+DATA_LENGTH] ExceptionMessage
+			FRAME_VERSION==0x02:
+			[13] 				Byte	ERROR_CODE, so clients can classify the error without parsing the message
+										0x00: Unknown, 0x01: Checksum mismatch, 0x02: Invalid request or trytes,
+										0x03: MinWeightMagnitude too high or not allowed, 0x04: Error of the POW implementation,
+										0x05: Server overloaded or rate limit exceeded,
+										0x06: Invalid transaction (only with "pow.validateTransaction")
+			[14..13+DATA_LENGTH] String	ExceptionMessage
 
 			----- IPC_CMD==IpcCmdGetServerVersion -----
 			[8..8+DATA_LENGTH] 	String	ServerVersion
@@ -72,172 +121,844 @@ import (
 			[8..8+DATA_LENGTH] 	String	PowVersion
 
 			----- IPC_CMD==IpcCmdPowFunc ----
+			C => S (FRAME_VERSION==0x01):
+			[8] 				Byte	MinWeightMagnitude
+			[9..8+DATA_LENGTH] 	Trytes	Transaction
+			C => S (FRAME_VERSION==0x02, offsets relative to DATA):
+			[0..1] 				Uint16	MinWeightMagnitude (big endian)
+			[2] 				Byte	Flags (see below, 0x00 = none)
+			[3..DATA_LENGTH] 	Trytes	Transaction
+			S => C:
 			[8..8+DATA_LENGTH] 	Trytes POW result
+			IpcCmdError "PoW backend not ready" until the POW implementation is initialized
+			IpcCmdError "server draining" while the server is in drain mode (see IpcCmdAdmin)
+			Requests with more DATA than a single transaction are dropped with IpcCmdError "Frame too long! ..."
+			as soon as the IPC_CMD is received, before the DATA is buffered.
+			IpcCmdError "server overloaded" if "pow.maxConcurrent" requests of all clients are already queued or running
+			If "pow.allowedMwm" is set, a MWM that is not in the list is rejected, independent of the maximum.
+			If "pow.clampMwm" is set, a MWM above the maximum is lowered to the maximum instead of being rejected,
+			and the client receives the IpcCmdNotification "MinWeightMagnitude clamped to <MWM>" before the result.
+			S => C (Flag 0x01 "timed", offsets relative to DATA):
+			[0..3] 				Uint32	Duration of the POW in ms (big endian)
+			[4..DATA_LENGTH] 	Trytes	POW result
+			Flag 0x02 "high priority": The request is dequeued before all waiting requests without this flag.
+			The priority only affects the order of the queue, a running POW is never preempted.
+			Flag 0x04 "packed trytes" (C => S, offsets relative to DATA):
+			[3..6] 				Uint32	Number of trytes (big endian)
+			[7..DATA_LENGTH] 	Bytes	Trits of the trytes, 5 balanced trits per byte (int8, lowest trit first)
+			Flag 0x08 "backend" (C => S, offsets relative to DATA, before the trytes and their count):
+			[3] 				Byte	Length N of the name
+			[4..3+N] 			String	Name of the POW backend (see RegisterPowBackend, empty = "pow.primaryBackend")
+			IpcCmdError "unknown PoW backend: <name>" if no backend is registered with the name
+
+			----- IPC_CMD==IpcCmdGetVersions ----
+			[8..8+DATA_LENGTH] 	JSON	Versions (see common.Versions), including the features of the POW implementation
+
+			----- IPC_CMD==IpcCmdGetStats ----
+			C => S:
+			[8] 				Byte	Optional: Flags
+										0x01: Set the counters to zero after the snapshot was taken (requires authentication)
+			S => C:
+			[8..8+DATA_LENGTH] 	JSON	Stats (see common.Stats)
+
+			----- IPC_CMD==IpcCmdPing ----
+			[8..8+DATA_LENGTH] 	String	"pong <uptime of the server in seconds>"
+
+			----- IPC_CMD==IpcCmdCancelPow ----
+			C => S:
+			[8] 				ReqID	REQ_ID of the POW request to cancel (FRAME_VERSION==0x02: 2 bytes, big endian)
+			S => C:
+			IpcCmdResponse without DATA, or IpcCmdError if the POW implementation does not support cancellation
+			The cancelled POW request is answered with IpcCmdError "POW cancelled".
+			Only POW requests sent on the same connection can be cancelled, the ReqIDs of other clients are not affected.
+
+			----- IPC_CMD==IpcCmdAuth ----
+			If the server is configured with a pre-shared key, IpcCmdPowFunc, IpcCmdCancelPow, IpcCmdResend, IpcCmdAdmin,
+			IpcCmdSelfTest, IpcCmdListConnections and IpcCmdGetStats with reset are rejected until the connection is authenticated.
+			1. C => S: Without DATA
+			   S => C: [8..8+DATA_LENGTH] 	Bytes	Nonce (empty if authentication is disabled)
+			2. C => S: [8..8+DATA_LENGTH] 	Bytes	HMAC-SHA256 of the nonce with the pre-shared key
+			   S => C: IpcCmdResponse without DATA, or IpcCmdError "authentication failed"
+			The nonce is only valid for a single attempt.
+
+			----- IPC_CMD==IpcCmdGetCapabilities ----
+			C => S:
+			[8..11] 			Uint32	Optional: Maximum FRAME_LENGTH the client accepts (big endian)
+										The server never sends longer frames on this connection, responses that would
+										exceed it are replaced by an IpcCmdError. It has to fit a POW response.
+			[12] 				Byte	Optional: Client flags
+										0x01: Responses that exceed the maximum FRAME_LENGTH are split into several frames
+										with the same REQ_ID instead of being replaced by an IpcCmdError. The flag 0x80
+										is set in the IPC_CMD of every frame except the last one ("more follows"),
+										the client concatenates the DATA of all frames.
+			S => C:
+			[8] 				Byte	Highest supported FRAME_VERSION
+			[9..8+DATA_LENGTH] 	Bytes	IPC_CMD of every supported C => S command
+
+			----- IPC_CMD==IpcCmdGetPowInfo ----
+			[8] 				Byte	Length of ServerVersion
+			[9..]				String	ServerVersion
+			followed by the length and the string of PowType and PowVersion in the same way
+
+			----- IPC_CMD==IpcCmdPowFuncDryRun ----
+			C => S:
+			Same as IpcCmdPowFunc, the request is validated in the same way but the POW implementation is not used
+			S => C:
+			Same as IpcCmdPowFunc, with a nonce of only '9' trytes (duration 0 with flag 0x01 "timed")
+
+			----- IPC_CMD==IpcCmdResend ----
+			C => S:
+			[8..39] 			Bytes	SHA-256 of the DATA of the POW request (see ipccommon.ResendDigest)
+			FRAME_VERSION and REQ_ID of the POW request whose response should be sent again
+			S => C:
+			Same as IpcCmdPowFunc, or IpcCmdError "no cached response for this ReqID"
+			The server keeps the successful responses of the last 16 POW requests of all clients.
+			Only a response to a request with the same FRAME_VERSION, REQ_ID and DATA is sent, so a REQ_ID used by
+			several clients never returns the nonce of another transaction.
+
+			----- IPC_CMD==IpcCmdGetServerInfo ----
+			[8..8+DATA_LENGTH] 	JSON	ServerInfo (see common.ServerInfo)
+			Older servers only answer IpcCmdGetServerVersion, clients check IpcCmdGetCapabilities before falling back to it.
+
+			----- IPC_CMD==IpcCmdAdmin ----
+			Only accepted on authenticated connections of a server configured with a pre-shared key.
+			C => S:
+			[8] 				Byte	Operation
+										0x00: Report the drain mode
+										0x01: Enable the drain mode
+										0x02: Disable the drain mode
+										0x03: Reinitialize the POW backend, e.g. after the connection to the device dropped
+			S => C:
+			[8] 				Byte	1 if the server is in drain mode, otherwise 0
+			While draining (also toggled via SIGUSR1), IpcCmdPowFunc is rejected with IpcCmdError "server draining".
+			All other commands keep working, so the clients can detect the state and switch to another server.
+			The reinit (also triggered via SIGUSR2) is answered when it is done, or with IpcCmdError if it failed.
+			While it is in progress, IpcCmdPowFunc is rejected with IpcCmdError "PoW backend reinitializing".
+
+			----- IPC_CMD==IpcCmdSelfTest ----
+			C => S:
+			Without DATA, requires authentication like IpcCmdPowFunc (but is accepted while draining)
+			S => C:
+			[8] 				Byte	1 if the nonce is valid, otherwise 0
+			[9..12] 			Uint32	Duration of the POW in ms (big endian)
+			The POW is done by the primary backend for common.SelfTestTransaction with common.SelfTestMinWeightMagnitude.
+			IpcCmdError if the POW failed, e.g. "PoW backend not ready".
+
+			----- IPC_CMD==IpcCmdListConnections ----
+			C => S:
+			Without DATA, requires authentication like IpcCmdPowFunc
+			S => C:
+			[8..8+DATA_LENGTH] 	JSON	Connections (see common.ConnectionInfo), ordered by their id
+			The command of a connection is the request its handler is processing, POW requests are counted as pending
+			until they are answered. The connection of the request itself is listed with IpcCmdListConnections.
 
 	CRC8:
-		Checksum of the whole FRAME_DATA
+		Checksum of the whole FRAME_DATA (CRC-8/MAXIM, other variants can be selected via "server.crc8" for migrations)
 
 */
 
-// sendToClient sends an IpcMessage to a client
-func sendToClient(c net.Conn, responseMsg *ipccommon.IpcMessage) (err error) {
+// powRequestDataLengthLimit limits the DATA of POW requests to a single transaction, so oversized
+// requests are rejected before they are buffered, independent of "server.maxFrameLength"
+func powRequestDataLengthLimit(version byte, command byte) int {
+	if command == ipccommon.IpcCmdPowFunc || command == ipccommon.IpcCmdPowFuncDryRun {
+		return ipccommon.MaxPowRequestDataLength(version)
+	}
+	return 0
+}
+
+// supportedCommands are the commands handled by the server, sent to the clients via IpcCmdGetCapabilities
+var supportedCommands = []byte{
+	ipccommon.IpcCmdGetServerVersion,
+	ipccommon.IpcCmdGetPowType,
+	ipccommon.IpcCmdGetPowVersion,
+	ipccommon.IpcCmdPowFunc,
+	ipccommon.IpcCmdGetVersions,
+	ipccommon.IpcCmdGetStats,
+	ipccommon.IpcCmdPing,
+	ipccommon.IpcCmdCancelPow,
+	ipccommon.IpcCmdAuth,
+	ipccommon.IpcCmdGetCapabilities,
+	ipccommon.IpcCmdGetPowInfo,
+	ipccommon.IpcCmdPowFuncDryRun,
+	ipccommon.IpcCmdResend,
+	ipccommon.IpcCmdGetServerInfo,
+	ipccommon.IpcCmdAdmin,
+	ipccommon.IpcCmdSelfTest,
+	ipccommon.IpcCmdListConnections,
+}
+
+// dryRunNonce is the placeholder nonce of the responses to IpcCmdPowFuncDryRun
+var dryRunNonce = strings.Repeat("9", ipccommon.NonceTrytesSize)
+
+// idleTimeoutReader renews the read deadline of the connection before every read (0 = no timeout)
+type idleTimeoutReader struct {
+	c       net.Conn
+	timeout time.Duration
+}
+
+func (r *idleTimeoutReader) Read(b []byte) (int, error) {
+	if r.timeout > 0 {
+		r.c.SetReadDeadline(time.Now().Add(r.timeout))
+	}
+	return r.c.Read(b)
+}
+
+// writeTimeoutConn sets the write deadline of the connection before every write (0 = no timeout)
+// A write either sends all bytes or fails, if it fails the connection is closed, because the client
+// may have received a partial frame and the stream can't be continued.
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *writeTimeoutConn) Write(b []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.Conn.Close()
+	}
+	return n, err
+}
+
+// lockedConn serializes the frames written to a connection
+// The responses of pipelined POW requests are written concurrently, sendToClient holds the lock for a whole frame.
+type lockedConn struct {
+	net.Conn
+	sync.Mutex
+}
+
+// clientLimits are the limits of the frames sent to a client, negotiated via IpcCmdGetCapabilities
+type clientLimits struct {
+	maxFrameLength int  // Maximum length of the FRAME_DATA (0 = no limit)
+	chunked        bool // Longer responses are split into several frames instead of being replaced by an IpcCmdError
+}
+
+// parseClientLimits parses the maximum frame length and the optional client flags advertised via IpcCmdGetCapabilities
+func parseClientLimits(version byte, data []byte) (clientLimits, error) {
+	if len(data) != 4 && len(data) != 5 {
+		return clientLimits{}, fmt.Errorf("Wrong length of the maximum frame length! Length: %d, Expected: 4", len(data))
+	}
+
+	maxFrameLength := int(binary.BigEndian.Uint32(data))
+	if minFrameLength := ipccommon.MinPowResponseFrameLength(version); maxFrameLength < minFrameLength {
+		return clientLimits{}, fmt.Errorf("Maximum frame length too small! Length: %d, Required: %d", maxFrameLength, minFrameLength)
+	}
+
+	limits := clientLimits{maxFrameLength: maxFrameLength}
+	if len(data) == 5 {
+		limits.chunked = data[4]&ipccommon.ClientFlagChunkedResponses != 0
+	}
+	return limits, nil
+}
+
+// DefaultMaxFrameLength is the default of the maximum accepted FRAME_LENGTH ("server.maxFrameLength")
+// It is sized to the trytes of one transaction plus the overhead of the frame
+const DefaultMaxFrameLength = 3072
+
+// crc8TableFromConfig returns the CRC8 table of the variant selected by "server.crc8"
+// Unknown variants are logged and the default table is used, the clients will then receive checksum errors
+func crc8TableFromConfig(config *viper.Viper) *crc8.Table {
+	table, err := ipccommon.Crc8TableByName(config.GetString("server.crc8"))
+	if err != nil {
+		logs.Log.Error(err.Error())
+		return ipccommon.Crc8Table
+	}
+	return table
+}
+
+// sendToClient sends an IpcMessage to a client with the CRC8 calculated by the given table
+// If the FRAME_DATA is longer than the maximum frame length negotiated with the client, the response is split into
+// several frames if the client accepts chunked responses, otherwise an IpcCmdError is sent instead
+func sendToClient(c net.Conn, responseMsg ipccommon.Message, limits clientLimits, crc8Table *crc8.Table) (err error) {
+	if l, ok := c.(sync.Locker); ok {
+		l.Lock()
+		defer l.Unlock()
+	}
+
+	responseMsg.SetCrc8Table(crc8Table)
+	if limits.maxFrameLength <= 0 {
+		// Without a negotiated limit the message is packed directly into the connection
+		_, err = responseMsg.WriteTo(c)
+		return err
+	}
+
 	response, err := responseMsg.ToBytes()
 	if err != nil {
 		return err
 	}
 
-	_, err = c.Write(response)
+	version := response[1]
+	headerLength := 2 + ipccommon.FrameLengthSize(version)
+	frameLength := len(response) - headerLength - 1
+	if frameLength > limits.maxFrameLength {
+		frame, err := ipccommon.ParseFrame(response, crc8Table)
+		if err != nil {
+			return err
+		}
+
+		if limits.chunked {
+			chunks, err := ipccommon.NewChunkedIpcMessages(version, frame.ReqID, frame.Command, frame.Data, limits.maxFrameLength)
+			if err != nil {
+				return err
+			}
 
-	return err
+			response = nil
+			for _, chunk := range chunks {
+				chunk.SetCrc8Table(crc8Table)
+				chunkBytes, err := chunk.ToBytes()
+				if err != nil {
+					return err
+				}
+				response = append(response, chunkBytes...)
+			}
+		} else {
+			// The error always fits, the negotiated length is at least the length of a POW response
+			errorMsg, err := newErrorMessage(version, frame.ReqID, fmt.Errorf("Response too long! Length: %d, Allowed: %d", frameLength, limits.maxFrameLength))
+			if err != nil {
+				return err
+			}
+			errorMsg.SetCrc8Table(crc8Table)
+			response, err = errorMsg.ToBytes()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return ipccommon.WriteFrame(c, response)
+}
+
+// powRequestPolicy are the checks of the POW requests of a connection, configured via the "pow.*" keys
+type powRequestPolicy struct {
+	maxMinWeightMagnitude int   // Highest accepted MWM
+	clampMwm              bool  // A MWM above the maximum is lowered to the maximum instead of being rejected
+	allowedMwm            []int // Accepted MWM values, independent of the maximum (empty = all values up to the maximum)
+	validateTrytesLength  bool  // Trytes that are not a whole transaction are rejected
+	validateTransaction   bool  // Trytes that don't parse into a valid transaction are rejected
+}
+
+// newPowRequestPolicy reads the checks of the POW requests from the config
+func newPowRequestPolicy(config *viper.Viper) powRequestPolicy {
+	return powRequestPolicy{
+		maxMinWeightMagnitude: config.GetInt("pow.maxMinWeightMagnitude"),
+
+		// Requests above the maximum MWM are done with the maximum MWM instead of being rejected.
+		// The result is not valid for the requested MWM, so this should only be enabled if all clients accept that.
+		clampMwm: config.GetBool("pow.clampMwm"),
+
+		// Trytes that are not a whole transaction are rejected before the POW, unless the validation is disabled
+		validateTrytesLength: !config.IsSet("pow.validateTrytesLength") || config.GetBool("pow.validateTrytesLength"),
+
+		// The transaction is parsed before the POW, so malformed transactions don't waste time of the device.
+		// Disabled by default, the trytes are passed to the POW implementation as they are.
+		validateTransaction: config.GetBool("pow.validateTransaction"),
+
+		// Operators can restrict the POW to the MWM of their network, e.g. to prevent low difficulty POW on a mainnet device
+		allowedMwm: config.GetIntSlice("pow.allowedMwm"),
+	}
+}
+
+// isMwmAllowed returns true if the MWM is in the allowed MWM values, or if all values are allowed
+func (p *powRequestPolicy) isMwmAllowed(mwm int) bool {
+	if len(p.allowedMwm) == 0 {
+		return true
+	}
+	for _, allowed := range p.allowedMwm {
+		if mwm == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// decodePowRequest parses the DATA of an IpcCmdPowFunc or IpcCmdPowFuncDryRun request and validates the MWM and the trytes
+// A MWM that is not allowed is rejected. A MWM above the maximum is rejected, or lowered to the maximum
+// if the policy clamps the MWM (clamped is true in that case).
+func decodePowRequest(frame *ipccommon.IpcFrame, policy powRequestPolicy) (request *ipccommon.PowRequest, clamped bool, err error) {
+	request, err = ipccommon.DecodePowRequest(frame.Version, frame.Data)
+	if err != nil {
+		return nil, false, withErrorCode(ipccommon.ErrorCodeInvalidTrytes, err)
+	}
+
+	if !policy.isMwmAllowed(request.MWM) {
+		return nil, false, withErrorCode(ipccommon.ErrorCodeMwmTooHigh, fmt.Errorf("MinWeightMagnitude not allowed. MWM: %v Allowed: %v", request.MWM, policy.allowedMwm))
+	}
+
+	if request.MWM > policy.maxMinWeightMagnitude {
+		if !policy.clampMwm {
+			return nil, false, withErrorCode(ipccommon.ErrorCodeMwmTooHigh, fmt.Errorf("MinWeightMagnitude too high. MWM: %v Allowed: %v", request.MWM, policy.maxMinWeightMagnitude))
+		}
+		request.MWM = policy.maxMinWeightMagnitude
+		clamped = true
+	}
+
+	if policy.validateTrytesLength && len(request.Trytes) != ipccommon.TransactionTrytesSize {
+		return nil, false, withErrorCode(ipccommon.ErrorCodeInvalidTrytes, fmt.Errorf("Wrong length of the transaction trytes! Length: %d, Expected: %d", len(request.Trytes), ipccommon.TransactionTrytesSize))
+	}
+
+	if policy.validateTransaction {
+		if err := validateTransaction(request.Trytes); err != nil {
+			return nil, false, withErrorCode(ipccommon.ErrorCodeInvalidTransaction, err)
+		}
+	}
+
+	return request, clamped, nil
 }
 
 // HandleClientConnection handles the communication to the client until the socket is closed
 func HandleClientConnection(c net.Conn, config *viper.Viper, powType string, powVersion string) {
-	frameState := ipccommon.FrameStateSearchEnq
-	frameLength := 0
-	var frameData []byte
 	defer c.Close()
 
+	// Every log line of the connection contains its id and the identity of the client
+	log := newConnLogger(c)
+	log.Debug("Connection opened")
+	defer log.Debug("Connection closed")
+
+	// A panic while handling a request only closes this connection, the server keeps serving the other clients
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Panic while handling the connection: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	atomic.AddInt64(&statsActiveConnections, 1)
+	defer atomic.AddInt64(&statsActiveConnections, -1)
+
+	activeConnections.register(log.id, log.peer)
+	defer activeConnections.unregister(log.id)
+
+	// Frames are rejected before buffering their data, if they announce more than one transaction plus overhead
+	maxFrameLength := config.GetInt("server.maxFrameLength")
+	if maxFrameLength <= 0 {
+		maxFrameLength = DefaultMaxFrameLength
+	}
+
+	// Server and client have to use the same CRC8 variant, otherwise every frame fails with a checksum error
+	crc8Table := crc8TableFromConfig(config)
+
+	// Checks of the MWM and the trytes of the POW requests
+	powPolicy := newPowRequestPolicy(config)
+
+	// Requests without a backend name are done by the primary backend (empty = the pool set via SetPowFunc)
+	primaryPowBackend := config.GetString("pow.primaryBackend")
+
+	// Limits of the frames sent to the client, negotiated via IpcCmdGetCapabilities (default = no limit)
+	limits := clientLimits{}
+
+	// POW requests are only accepted after the client authenticated, if a pre-shared key is configured
+	auth := newAuthState(config.GetString("server.authKey"))
+
+	// The rate limiter is bound to this connection and released when the connection is closed
+	var rateLimiter *tokenBucket
+	if maxRequestsPerMinute := config.GetInt("pow.maxRequestsPerMinute"); maxRequestsPerMinute > 0 {
+		rateLimiter = newTokenBucket(maxRequestsPerMinute)
+	}
+
+	readBufferSize := config.GetInt("server.readBufferSize")
+	if readBufferSize <= 0 {
+		readBufferSize = ipccommon.DefaultReadBufferSize
+	}
+
+	// Connections without incoming data are closed after the idle timeout (0 = never).
+	// The deadline is renewed before every read, so clients streaming a frame are not affected.
+	idleTimeout := time.Duration(config.GetInt("server.idleTimeoutMs")) * time.Millisecond
+
+	// A client that stopped reading can't block the connection forever, the connection is closed
+	// if a response is not written within the write timeout (0 = never)
+	c = &writeTimeoutConn{Conn: c, timeout: time.Duration(config.GetInt("server.writeTimeoutMs")) * time.Millisecond}
+
+	// POW requests are answered asynchronously, so a client can pipeline further requests on the connection while
+	// a POW is running. The responses are written as soon as they are ready, the client assigns them by their REQ_ID.
+	// The connection is closed after all pending POW requests were answered.
+	c = &lockedConn{Conn: c}
+	var pendingPow sync.WaitGroup
+	defer pendingPow.Wait()
+
+	reader := ipccommon.NewFrameReader(&idleTimeoutReader{c: c, timeout: idleTimeout}, readBufferSize, maxFrameLength, crc8Table)
+	reader.SetDataLengthLimit(powRequestDataLengthLimit)
 	for {
-		buf := make([]byte, 3072) // ((8019 is the TransactionTrinarySize) / 3) + Overhead) => 3072
-		bufLength, err := c.Read(buf)
+		frame, err := reader.ReadFrame()
 		if err != nil {
+			var frameErr *ipccommon.FrameError
+			if errors.As(err, &frameErr) {
+				// The reader already searches the next frame
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frameErr.Version, frameErr.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				continue
+			}
+
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Debugf("Closing idle connection after %v", idleTimeout)
+			} else if err == io.ErrShortBuffer {
+				log.Debug(err.Error())
+			}
 			break
 		}
 
-		bufferIdx := -1
-		for {
-			bufferIdx++
+		addRequestMetrics(frame.Command)
+		activeConnections.setCommand(log.id, frame.Command)
 
-			if bufLength > bufferIdx {
+		switch frame.Command {
 
-				switch frameState {
+		case ipccommon.IpcCmdGetServerVersion:
+			log.Debug("Received Command GetServerVersion")
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, []byte(common.DiverDriverVersion))
+			sendToClient(c, responseMsg, limits, crc8Table)
 
-				case ipccommon.FrameStateSearchEnq:
-					if buf[bufferIdx] == 0x05 {
-						// Init variables for new message
-						frameLength = -1
-						frameData = nil
-						frameState = ipccommon.FrameStateSearchVersion
-					}
+		case ipccommon.IpcCmdGetPowType:
+			log.Debug("Received Command GetPowType")
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, []byte(powType))
+			sendToClient(c, responseMsg, limits, crc8Table)
 
-				case ipccommon.FrameStateSearchVersion:
-					if buf[bufferIdx] == 0x01 {
-						frameState = ipccommon.FrameStateSearchLength
-					} else {
-						frameState = ipccommon.FrameStateSearchEnq
-					}
+		case ipccommon.IpcCmdGetPowVersion:
+			log.Debug("Received Command GetPowVersion")
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, []byte(powVersion))
+			sendToClient(c, responseMsg, limits, crc8Table)
 
-				case ipccommon.FrameStateSearchLength:
-					if frameLength == -1 {
-						// Receive first byte
-						frameLength = int(buf[bufferIdx]) << 8
-					} else {
-						// Receive second byte and go on
-						frameLength |= int(buf[bufferIdx])
-						frameState = ipccommon.FrameStateSearchData
-					}
+		case ipccommon.IpcCmdPowFunc:
+			log.Debug("Received Command PowFunc")
+			if !auth.authenticated {
+				log.Debug(errNotAuthenticated.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errNotAuthenticated)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
 
-				case ipccommon.FrameStateSearchData:
-					missingByteCount := frameLength - len(frameData)
-					if (bufLength - bufferIdx) >= missingByteCount {
-						// Frame completely received
-						frameData = append(frameData, buf[bufferIdx:(bufferIdx+missingByteCount)]...)
-						bufferIdx += missingByteCount - 1
-						frameState = ipccommon.FrameStateSearchCRC
-					} else {
-						// Frame not completed in this read => Copy the remaining bytes
-						frameData = append(frameData, buf[bufferIdx:bufLength]...)
-						bufferIdx = bufLength
-					}
+			if IsDraining() {
+				log.Debug(errServerDraining.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errServerDraining)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
 
-				case ipccommon.FrameStateSearchCRC:
-					frame, err := ipccommon.BytesToIpcFrameV1(frameData)
-					if err != nil {
-						logs.Log.Debug(err.Error())
-						responseMsg, _ := ipccommon.NewIpcMessageV1(0, ipccommon.IpcCmdError, []byte(err.Error()))
-						sendToClient(c, responseMsg)
-						frameState = ipccommon.FrameStateSearchEnq
-						break
-					}
+			if IsReinitializing() {
+				log.Debug(errPowReinitializing.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errPowReinitializing)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
 
-					crc := crc8.Checksum(frameData, ipccommon.Crc8Table)
-					if buf[bufferIdx] != crc {
-						logs.Log.Debugf("Wrong Checksum! CRC: %X, Expected: %X", crc, buf[bufferIdx])
-						responseMsg, _ := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdError, []byte(fmt.Sprintf("Wrong Checksum! CRC: %X, Expected: %X", crc, buf[bufferIdx])))
-						sendToClient(c, responseMsg)
-						frameState = ipccommon.FrameStateSearchEnq
-						break
-					}
+			if !isPowReady() {
+				log.Debug(errPowNotReady.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errPowNotReady)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			if rateLimiter != nil && !rateLimiter.allow() {
+				log.Debug(errRateLimitExceeded.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errRateLimitExceeded)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			request, clamped, err := decodePowRequest(frame, powPolicy)
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			if clamped {
+				log.Debugf("MinWeightMagnitude clamped to %v", request.MWM)
+				notificationMsg, _ := ipccommon.NewIpcMessageV1(0, ipccommon.IpcCmdNotification, []byte(fmt.Sprintf("MinWeightMagnitude clamped to %v", request.MWM)))
+				sendToClient(c, notificationMsg, limits, crc8Table)
+			}
 
-					switch frame.Command {
-
-					case ipccommon.IpcCmdGetServerVersion:
-						logs.Log.Debug("Received Command GetServerVersion")
-						responseMsg, _ := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdResponse, []byte(common.DiverDriverVersion))
-						sendToClient(c, responseMsg)
-
-					case ipccommon.IpcCmdGetPowType:
-						logs.Log.Debug("Received Command GetPowType")
-						responseMsg, _ := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdResponse, []byte(powType))
-						sendToClient(c, responseMsg)
-
-					case ipccommon.IpcCmdGetPowVersion:
-						logs.Log.Debug("Received Command GetPowVersion")
-						responseMsg, _ := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdResponse, []byte(powVersion))
-						sendToClient(c, responseMsg)
-
-					case ipccommon.IpcCmdPowFunc:
-						logs.Log.Debug("Received Command PowFunc")
-						mwm := int(frame.Data[0])
-
-						if mwm > config.GetInt("pow.maxMinWeightMagnitude") {
-							logs.Log.Debugf("MinWeightMagnitude too high. MWM: %v Allowed: %v", mwm, config.GetInt("pow.maxMinWeightMagnitude"))
-							responseMsg, _ := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdError, []byte(fmt.Sprintf("MinWeightMagnitude too high. MWM: %v Allowed: %v", mwm, config.GetInt("pow.maxMinWeightMagnitude"))))
-							sendToClient(c, responseMsg)
-							frameState = ipccommon.FrameStateSearchEnq
-							break
-						}
-
-						trytes, err := giota.ToTrytes(string(frame.Data[1:]))
-						if err != nil {
-							logs.Log.Debug(err.Error())
-							responseMsg, _ := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdError, []byte(err.Error()))
-							sendToClient(c, responseMsg)
-							frameState = ipccommon.FrameStateSearchEnq
-							break
-						}
-
-						result, err := powFunc(trytes, mwm)
-						if err != nil {
-							logs.Log.Debug(err.Error())
-							responseMsg, _ := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdError, []byte(err.Error()))
-							sendToClient(c, responseMsg)
-							frameState = ipccommon.FrameStateSearchEnq
-							break
-						} else {
-							responseMsg, err := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdResponse, []byte(result))
-							if err != nil {
-								frameState = ipccommon.FrameStateSearchEnq
-								break
-							}
-							sendToClient(c, responseMsg)
-						}
-
-					default:
-						// IpcCmdNotification, IpcCmdResponse, IpcCmdError
-						logs.Log.Debugf("Unknown command! Cmd: %X", frame.Command)
-						responseMsg, _ := ipccommon.NewIpcMessageV1(frame.ReqID, ipccommon.IpcCmdError, []byte(fmt.Sprintf("Unknown command! Cmd: %X", frame.Command)))
-						sendToClient(c, responseMsg)
+			releasePowSlot, ok := acquirePowSlot()
+			if !ok {
+				log.Debug(errServerOverloaded.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errServerOverloaded)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			backend := request.Backend
+			if backend == "" {
+				backend = primaryPowBackend
+			}
+
+			pendingPow.Add(1)
+			activeConnections.addPendingPow(log.id, 1)
+			go func(frame *ipccommon.IpcFrame, limits clientLimits) {
+				defer pendingPow.Done()
+				defer activeConnections.addPendingPow(log.id, -1)
+				defer func() {
+					if r := recover(); r != nil {
+						log.Errorf("Panic while handling a POW request: %v\n%s", r, debug.Stack())
+						c.Close()
 					}
+				}()
+
+				result, durationMs, err := powFunc(log.id, frame.ReqID, backend, request.Trytes, request.MWM, request.Flags&ipccommon.PowFlagHighPriority != 0)
+				releasePowSlot()
+				if err != nil {
+					log.Debug(err.Error())
+					responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+					sendToClient(c, responseMsg, limits, crc8Table)
+					return
+				}
 
-					// Search for the next message
-					frameState = ipccommon.FrameStateSearchEnq
+				response := []byte(result)
+				if request.Flags&ipccommon.PowFlagTimed != 0 {
+					response = ipccommon.EncodeTimedPowResponse(durationMs, string(result))
 				}
-			} else {
-				// Received Buffer completely handled, break the loop to receive the next message
+				recentResponses.add(frame.Version, frame.ReqID, ipccommon.ResendDigest(frame.Data), response)
+				responseMsg, err := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, response)
+				if err != nil {
+					return
+				}
+				sendToClient(c, responseMsg, limits, crc8Table)
+			}(frame, limits)
+
+		case ipccommon.IpcCmdPowFuncDryRun:
+			log.Debug("Received Command PowFuncDryRun")
+			request, _, err := decodePowRequest(frame, powPolicy)
+			if err == nil && request.Backend != "" && !hasPowBackend(request.Backend) {
+				err = unknownPowBackendError(request.Backend)
+			}
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
 				break
 			}
+
+			response := []byte(dryRunNonce)
+			if request.Flags&ipccommon.PowFlagTimed != 0 {
+				response = ipccommon.EncodeTimedPowResponse(0, dryRunNonce)
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, response)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdResend:
+			log.Debug("Received Command Resend")
+			if !auth.authenticated {
+				log.Debug(errNotAuthenticated.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errNotAuthenticated)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			response, err := recentResponses.get(frame.Version, frame.ReqID, frame.Data)
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, response)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdGetVersions:
+			log.Debug("Received Command GetVersions")
+			versions, err := json.Marshal(common.Versions{
+				ServerVersion:   common.DiverDriverVersion,
+				ProtocolVersion: ipccommon.MaxFrameVersion,
+				PowType:         powType,
+				PowVersion:      powVersion,
+				PowFeatures:     getPowDescriptor().Features,
+				PowBackends:     getPowBackends(),
+			})
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, versions)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdGetServerInfo:
+			log.Debug("Received Command GetServerInfo")
+			serverInfo, err := json.Marshal(common.ServerInfo{
+				Version:         common.DiverDriverVersion,
+				GitCommit:       common.GitCommit,
+				BuildDate:       common.BuildDate,
+				GoVersion:       runtime.Version(),
+				MaxFrameVersion: ipccommon.MaxFrameVersion,
+			})
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, serverInfo)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdGetStats:
+			log.Debug("Received Command GetStats")
+			reset := len(frame.Data) > 0 && frame.Data[0]&ipccommon.StatsFlagReset != 0
+			if reset && !auth.authenticated {
+				log.Debug(errNotAuthenticated.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errNotAuthenticated)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			snapshot := getStats
+			if reset {
+				snapshot = getStatsAndReset
+			}
+			stats, err := json.Marshal(snapshot())
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, stats)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdCancelPow:
+			log.Debug("Received Command CancelPow")
+			if !auth.authenticated {
+				log.Debug(errNotAuthenticated.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errNotAuthenticated)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			if len(frame.Data) != ipccommon.ReqIDSize(frame.Version) {
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, fmt.Errorf("Wrong ReqID length! Length: %d, Expected: %d", len(frame.Data), ipccommon.ReqIDSize(frame.Version)))
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			cancelReqID := uint16(0)
+			for _, b := range frame.Data {
+				cancelReqID = cancelReqID<<8 | uint16(b)
+			}
+
+			if err := cancelPow(log.id, cancelReqID); err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, nil)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdAdmin:
+			log.Debug("Received Command Admin")
+			if len(auth.key) == 0 {
+				log.Debug(errAdminNoAuthKey.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errAdminNoAuthKey)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			if !auth.authenticated {
+				log.Debug(errNotAuthenticated.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errNotAuthenticated)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			response, err := handleAdmin(frame.Data)
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, response)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdSelfTest:
+			log.Debug("Received Command SelfTest")
+			if !auth.authenticated {
+				log.Debug(errNotAuthenticated.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errNotAuthenticated)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			passed, durationMs, err := selfTest(log.id, frame.ReqID, primaryPowBackend)
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, ipccommon.EncodeSelfTestResponse(passed, durationMs))
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdListConnections:
+			log.Debug("Received Command ListConnections")
+			if !auth.authenticated {
+				log.Debug(errNotAuthenticated.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, errNotAuthenticated)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+
+			connections, err := json.Marshal(activeConnections.list())
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, connections)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdAuth:
+			log.Debug("Received Command Auth")
+			response, err := auth.handleAuth(frame.Data)
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, response)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdGetCapabilities:
+			log.Debug("Received Command GetCapabilities")
+			if len(frame.Data) > 0 {
+				newLimits, err := parseClientLimits(frame.Version, frame.Data)
+				if err != nil {
+					log.Debug(err.Error())
+					responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+					sendToClient(c, responseMsg, limits, crc8Table)
+					break
+				}
+				limits = newLimits
+			}
+
+			capabilities := append([]byte{ipccommon.MaxFrameVersion}, supportedCommands...)
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, capabilities)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdPing:
+			log.Debug("Received Command Ping")
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, []byte(fmt.Sprintf("pong %d", getUptimeSeconds())))
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		case ipccommon.IpcCmdGetPowInfo:
+			log.Debug("Received Command GetPowInfo")
+			powInfo, err := ipccommon.EncodePowInfo(common.DiverDriverVersion, powType, powVersion)
+			if err != nil {
+				log.Debug(err.Error())
+				responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, err)
+				sendToClient(c, responseMsg, limits, crc8Table)
+				break
+			}
+			responseMsg, _ := ipccommon.NewIpcMessage(frame.Version, frame.ReqID, ipccommon.IpcCmdResponse, powInfo)
+			sendToClient(c, responseMsg, limits, crc8Table)
+
+		default:
+			// IpcCmdNotification, IpcCmdResponse, IpcCmdError
+			log.Debugf("%s Cmd: %X", common.ErrMsgUnknownCommand, frame.Command)
+			responseMsg, _ := newErrorMessage(frame.Version, frame.ReqID, fmt.Errorf("%s Cmd: %X", common.ErrMsgUnknownCommand, frame.Command))
+			sendToClient(c, responseMsg, limits, crc8Table)
 		}
+
+		activeConnections.clearCommand(log.id)
 	}
 }
@@ -0,0 +1,950 @@
+package ipcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+	"github.com/spf13/viper"
+)
+
+func newTestConfig() *viper.Viper {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	// The tests use short trytes instead of whole transactions
+	config.Set("pow.validateTrytesLength", false)
+	return config
+}
+
+// readResponse reads a single IPC message from the connection and returns the embedded frame
+func readResponse(t *testing.T, c net.Conn) *ipccommon.IpcFrame {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c, header); err != nil {
+		t.Fatalf("Reading header failed: %v", err)
+	}
+
+	lengthBytes := make([]byte, ipccommon.FrameLengthSize(header[1]))
+	if _, err := io.ReadFull(c, lengthBytes); err != nil {
+		t.Fatalf("Reading header failed: %v", err)
+	}
+
+	frameLength := 0
+	for _, b := range lengthBytes {
+		frameLength = frameLength<<8 | int(b)
+	}
+
+	rest := make([]byte, frameLength+1)
+	if _, err := io.ReadFull(c, rest); err != nil {
+		t.Fatalf("Reading frame failed: %v", err)
+	}
+
+	frame, err := ipccommon.BytesToIpcFrame(header[1], rest[:frameLength])
+	if err != nil {
+		t.Fatalf("Parsing frame failed: %v", err)
+	}
+	return frame
+}
+
+func newServerVersionRequest(t *testing.T, reqID byte) []byte {
+	t.Helper()
+
+	msg, err := ipccommon.NewIpcMessageV1(reqID, ipccommon.IpcCmdGetServerVersion, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := msg.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestHandleClientConnectionPartialReads(t *testing.T) {
+	request := newServerVersionRequest(t, 0x42)
+
+	for _, chunkSize := range []int{1, 2, 3, 4, len(request) - 1, len(request)} {
+		client, server := net.Pipe()
+		go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+		go func(chunkSize int) {
+			for i := 0; i < len(request); i += chunkSize {
+				end := i + chunkSize
+				if end > len(request) {
+					end = len(request)
+				}
+				if _, err := client.Write(request[i:end]); err != nil {
+					return
+				}
+			}
+		}(chunkSize)
+
+		frame := readResponse(t, client)
+		if frame.ReqID != 0x42 || frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != common.DiverDriverVersion {
+			t.Errorf("Chunk size %d: unexpected response %+v", chunkSize, frame)
+		}
+		client.Close()
+	}
+}
+
+func TestHandleClientConnectionMultipleFramesInOneRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	request := append(newServerVersionRequest(t, 1), newServerVersionRequest(t, 2)...)
+	go client.Write(request)
+
+	for _, reqID := range []uint16{1, 2} {
+		frame := readResponse(t, client)
+		if frame.ReqID != reqID || string(frame.Data) != common.DiverDriverVersion {
+			t.Errorf("Unexpected response %+v, expected ReqID %d", frame, reqID)
+		}
+	}
+}
+
+func TestHandleClientConnectionFrameAtBufferBoundary(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	// Fill the read buffer with garbage, so the frame ends exactly at the end of the buffer
+	request := newServerVersionRequest(t, 7)
+	data := make([]byte, ipccommon.DefaultReadBufferSize-len(request))
+	data = append(data, request...)
+	go client.Write(data)
+
+	frame := readResponse(t, client)
+	if frame.ReqID != 7 || string(frame.Data) != common.DiverDriverVersion {
+		t.Errorf("Unexpected response %+v", frame)
+	}
+}
+
+// oversizedReadConn reports more read bytes than fit into the given buffer
+type oversizedReadConn struct {
+	net.Conn
+}
+
+func (c *oversizedReadConn) Read(b []byte) (int, error) {
+	return len(b) + 1, nil
+}
+
+func TestHandleClientConnectionOversizedRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		HandleClientConnection(&oversizedReadConn{server}, newTestConfig(), "TestPow", "1.0")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection was not closed after an oversized read")
+	}
+}
+
+func TestHandleClientConnectionRateLimit(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	config := newTestConfig()
+	config.Set("pow.maxRequestsPerMinute", 1)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	msg, _ := ipccommon.NewIpcMessageV1(1, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...))
+	request, _ := msg.ToBytes()
+
+	go client.Write(request)
+	if frame := readResponse(t, client); frame.Command != ipccommon.IpcCmdResponse {
+		t.Errorf("First request was rejected: %s", frame.Data)
+	}
+
+	go client.Write(request)
+	if frame := readResponse(t, client); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != "rate limit exceeded" {
+		t.Errorf("Second request was not rate limited: %+v", frame)
+	}
+}
+
+func TestHandleClientConnectionFrameTooLong(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	// Header of a frame that announces 16 MB of FRAME_DATA, the data itself is never sent
+	go client.Write([]byte{ipccommon.FrameStartByte, ipccommon.FrameVersionV2, 0x01, 0x00, 0x00, 0x00})
+
+	frame := readResponse(t, client)
+	if frame.Command != ipccommon.IpcCmdError {
+		t.Fatalf("Oversized frame was not rejected: %+v", frame)
+	}
+
+	// The parser is reset and handles the next frame
+	go client.Write(newServerVersionRequest(t, 3))
+	if frame := readResponse(t, client); frame.ReqID != 3 || string(frame.Data) != common.DiverDriverVersion {
+		t.Errorf("Unexpected response %+v", frame)
+	}
+}
+
+func TestHandleClientConnectionPowFuncTooLong(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	// Header of a POW request that announces 3000 bytes, below "server.maxFrameLength" but longer than a transaction.
+	// Only the REQ_ID and the IPC_CMD are sent.
+	go client.Write([]byte{ipccommon.FrameStartByte, ipccommon.FrameVersionV1, 0x0B, 0xB8, 0x05, ipccommon.IpcCmdPowFunc})
+
+	frame := readResponse(t, client)
+	if frame.ReqID != 5 || frame.Command != ipccommon.IpcCmdError || !strings.HasPrefix(string(frame.Data), "Frame too long") {
+		t.Fatalf("Oversized POW request was not rejected: %+v", frame)
+	}
+
+	// A complete frame that is only slightly too long is rejected as well
+	powRequest := append([]byte{14}, []byte(strings.Repeat("A", ipccommon.TransactionTrytesSize+1))...)
+	if frame := sendRequest(t, client, 6, ipccommon.IpcCmdPowFunc, powRequest); frame.ReqID != 6 || frame.Command != ipccommon.IpcCmdError || !strings.HasPrefix(string(frame.Data), "Frame too long") {
+		t.Errorf("Oversized POW request was not rejected: %+v", frame)
+	}
+
+	go client.Write(newServerVersionRequest(t, 7))
+	if frame := readResponse(t, client); frame.ReqID != 7 || string(frame.Data) != common.DiverDriverVersion {
+		t.Errorf("Unexpected response %+v", frame)
+	}
+}
+
+func TestHandleClientConnectionPipelining(t *testing.T) {
+	release := make(chan struct{})
+	slowPowFunc := func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if trytes == "SLOW9" {
+			<-release
+		}
+		return "NONCE" + trytes, nil
+	}
+	SetPowFuncPool([]giota.PowFunc{slowPowFunc, slowPowFunc})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	// All requests are sent on the same connection without waiting for the responses
+	var requests []byte
+	for _, request := range []struct {
+		reqID   byte
+		command byte
+		data    []byte
+	}{
+		{1, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("SLOW9")...)},
+		{2, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("FAST9")...)},
+		{3, ipccommon.IpcCmdPing, nil},
+	} {
+		msg, _ := ipccommon.NewIpcMessageV1(request.reqID, request.command, request.data)
+		requestBytes, _ := msg.ToBytes()
+		requests = append(requests, requestBytes...)
+	}
+	go client.Write(requests)
+
+	// The requests behind the running POW are answered first
+	for received := map[uint16]bool{}; len(received) < 2; {
+		frame := readResponse(t, client)
+		if frame.ReqID == 1 || frame.Command != ipccommon.IpcCmdResponse {
+			t.Fatalf("Unexpected response %+v while the first POW is running", frame)
+		}
+		if frame.ReqID == 2 && string(frame.Data) != "NONCEFAST9" {
+			t.Errorf("Unexpected POW result %s", frame.Data)
+		}
+		received[frame.ReqID] = true
+	}
+
+	close(release)
+	if frame := readResponse(t, client); frame.ReqID != 1 || string(frame.Data) != "NONCESLOW9" {
+		t.Errorf("Unexpected response %+v of the first POW", frame)
+	}
+}
+
+func TestHandleClientConnectionCancelPow(t *testing.T) {
+	started := make(chan struct{})
+	SetCancellablePowFunc(func(ctx context.Context, trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	defer SetPowFunc(nil)
+
+	powClient, powServer := net.Pipe()
+	defer powClient.Close()
+	go HandleClientConnection(powServer, newTestConfig(), "TestPow", "1.0")
+
+	go powClient.Write(newV2PowRequest(t, 0x1234))
+	<-started
+
+	// The cancel is sent on the same connection, the responses of both requests arrive in any order
+	msg, _ := ipccommon.NewIpcMessage(ipccommon.FrameVersionV2, 1, ipccommon.IpcCmdCancelPow, []byte{0x12, 0x34})
+	request, _ := msg.ToBytes()
+	go powClient.Write(request)
+
+	for i := 0; i < 2; i++ {
+		frame := readResponse(t, powClient)
+		switch frame.ReqID {
+		case 1:
+			if frame.Command != ipccommon.IpcCmdResponse {
+				t.Errorf("Cancel was rejected: %s", frame.Data)
+			}
+		default:
+			if _, msg := ipccommon.DecodeErrorData(frame.Version, frame.Data); frame.ReqID != 0x1234 || frame.Command != ipccommon.IpcCmdError || msg != errPowCancelled.Error() {
+				t.Errorf("Unexpected response of the cancelled request %+v", frame)
+			}
+		}
+	}
+}
+
+// newV2PowRequest creates a POW request with FRAME_VERSION==0x02 and the given ReqID
+func newV2PowRequest(t *testing.T, reqID uint16) []byte {
+	t.Helper()
+
+	data, _ := (&ipccommon.PowRequest{MWM: 14, Trytes: "ABC9"}).Encode(ipccommon.FrameVersionV2)
+	msg, err := ipccommon.NewIpcMessage(ipccommon.FrameVersionV2, reqID, ipccommon.IpcCmdPowFunc, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := msg.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestHandleClientConnectionCancelPowOtherConnection(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	pow := func(ctx context.Context, trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		started <- struct{}{}
+		select {
+		case <-ctx.Done():
+		case <-release:
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "NONCE", nil
+	}
+	SetCancellablePowFuncPool([]CancellablePowFunc{pow, pow})
+	defer SetPowFunc(nil)
+
+	// Both clients number their requests on their own and use the same ReqID
+	clients := make([]net.Conn, 2)
+	for i := range clients {
+		client, server := net.Pipe()
+		defer client.Close()
+		go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+		go client.Write(newV2PowRequest(t, 0x1234))
+		<-started
+		clients[i] = client
+	}
+
+	// The cancel of the first client only cancels its own request
+	msg, _ := ipccommon.NewIpcMessage(ipccommon.FrameVersionV2, 1, ipccommon.IpcCmdCancelPow, []byte{0x12, 0x34})
+	request, _ := msg.ToBytes()
+	go clients[0].Write(request)
+	for i := 0; i < 2; i++ {
+		if frame := readResponse(t, clients[0]); frame.ReqID == 1 && frame.Command != ipccommon.IpcCmdResponse {
+			t.Fatalf("Cancel was rejected: %s", frame.Data)
+		}
+	}
+
+	close(release)
+	if frame := readResponse(t, clients[1]); frame.ReqID != 0x1234 || frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Errorf("The POW of the other client was affected by the cancel: %+v", frame)
+	}
+}
+
+func TestHandleClientConnectionCancelPowNotSupported(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	msg, _ := ipccommon.NewIpcMessageV1(1, ipccommon.IpcCmdCancelPow, []byte{2})
+	request, _ := msg.ToBytes()
+	go client.Write(request)
+
+	if frame := readResponse(t, client); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != "cancel not supported" {
+		t.Errorf("Unexpected response %+v", frame)
+	}
+}
+
+func TestHandleClientConnectionClientMaxFrameLength(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return giota.Trytes(strings.Repeat("A", ipccommon.TransactionTrytesSize+100)), nil
+	})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	if frame := sendRequest(t, client, 1, ipccommon.IpcCmdGetCapabilities, []byte{0x00, 0x00, 0x00, 0x64}); frame.Command != ipccommon.IpcCmdError {
+		t.Fatalf("Maximum frame length smaller than a POW response was accepted: %+v", frame)
+	}
+
+	maxFrameLength := ipccommon.MinPowResponseFrameLength(ipccommon.FrameVersionV1)
+	if frame := sendRequest(t, client, 2, ipccommon.IpcCmdGetCapabilities, []byte{0x00, 0x00, byte(maxFrameLength >> 8), byte(maxFrameLength)}); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Maximum frame length was rejected: %s", frame.Data)
+	}
+
+	frame := sendRequest(t, client, 3, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...))
+	if frame.ReqID != 3 || frame.Command != ipccommon.IpcCmdError || !strings.HasPrefix(string(frame.Data), "Response too long!") {
+		t.Errorf("Response exceeding the maximum frame length was sent: ReqID %d, Cmd %X, Length %d", frame.ReqID, frame.Command, len(frame.Data))
+	}
+}
+
+func BenchmarkHandleClientConnectionPowFunc(b *testing.B) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	msg, _ := ipccommon.NewIpcMessageV1(1, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte(strings.Repeat("A", ipccommon.TransactionTrytesSize))...))
+	request, _ := msg.ToBytes()
+	response := make([]byte, len(request))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		go client.Write(request)
+		// The response contains the trytes without the MWM byte of the request
+		if _, err := io.ReadFull(client, response[:len(request)-1]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestHandleClientConnectionMwmFrameVersionV2(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return giota.Trytes(fmt.Sprintf("MWM%d", mwm)), nil
+	})
+	defer SetPowFunc(nil)
+
+	config := newTestConfig()
+	config.Set("pow.maxMinWeightMagnitude", 300)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	for _, mwm := range []int{14, 300, 301} {
+		data, err := (&ipccommon.PowRequest{MWM: mwm, Trytes: "ABC9"}).Encode(ipccommon.FrameVersionV2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := ipccommon.NewIpcMessage(ipccommon.FrameVersionV2, 1, ipccommon.IpcCmdPowFunc, data)
+		request, _ := msg.ToBytes()
+		go client.Write(request)
+
+		frame := readResponse(t, client)
+		if mwm <= 300 && string(frame.Data) != fmt.Sprintf("MWM%d", mwm) {
+			t.Errorf("MWM %d: unexpected response %s", mwm, frame.Data)
+		}
+		if mwm > 300 && frame.Command != ipccommon.IpcCmdError {
+			t.Errorf("MWM %d above the maximum was accepted", mwm)
+		}
+	}
+}
+
+func TestHandleClientConnectionResyncAfterCorruptFrame(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	// The truncated frame swallows the start of the valid frame, so the parser has to search the
+	// frame start inside the bytes of the dropped frame
+	corrupt := newServerVersionRequest(t, 1)
+	request := append(corrupt[:len(corrupt)-3], newServerVersionRequest(t, 2)...)
+	go client.Write(request)
+
+	for {
+		frame := readResponse(t, client)
+		if frame.ReqID != 2 {
+			if frame.Command != ipccommon.IpcCmdError {
+				t.Fatalf("Unexpected response %+v for the corrupt frame", frame)
+			}
+			continue
+		}
+		if frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != common.DiverDriverVersion {
+			t.Errorf("Unexpected response %+v", frame)
+		}
+		break
+	}
+}
+
+func TestHandleClientConnectionUnsupportedFrameVersion(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	go client.Write([]byte{ipccommon.FrameStartByte, 0x7F, 0x00, 0x05, 0x01, 0x02})
+
+	frame := readResponse(t, client)
+	if frame.Version != ipccommon.FrameVersionV1 || frame.ReqID != 0 || frame.Command != ipccommon.IpcCmdError || !strings.HasPrefix(string(frame.Data), "unsupported frame version") {
+		t.Errorf("Unexpected response %+v for an unsupported frame version", frame)
+	}
+}
+
+func TestHandleClientConnectionIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	config := newTestConfig()
+	config.Set("server.idleTimeoutMs", 100)
+
+	done := make(chan struct{})
+	go func() {
+		HandleClientConnection(server, config, "TestPow", "1.0")
+		close(done)
+	}()
+
+	// A client streaming a frame slower than the timeout, but with data in between, is not disconnected
+	request := newServerVersionRequest(t, 5)
+	go func() {
+		for i := range request {
+			time.Sleep(30 * time.Millisecond)
+			if _, err := client.Write(request[i : i+1]); err != nil {
+				return
+			}
+		}
+	}()
+
+	frame := readResponse(t, client)
+	if frame.ReqID != 5 || frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Unexpected response %+v", frame)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Idle connection was not closed")
+	}
+}
+
+func TestHandleClientConnectionValidateTrytesLength(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	frame := sendRequest(t, client, 1, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...))
+	if frame.Command != ipccommon.IpcCmdError || !strings.HasPrefix(string(frame.Data), "Wrong length of the transaction trytes") {
+		t.Errorf("Truncated trytes were not rejected: %+v", frame)
+	}
+
+	frame = sendRequest(t, client, 2, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte(strings.Repeat("A", ipccommon.TransactionTrytesSize))...))
+	if frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Errorf("Unexpected response %+v for a whole transaction", frame)
+	}
+}
+
+func TestHandleClientConnectionValidateTransaction(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	var invalidErr *ErrInvalidTransaction
+	if err := validateTransaction("ABC9"); !errors.As(err, &invalidErr) || invalidErr.Field != "trytes" {
+		t.Errorf("Unexpected error for trytes that are not a transaction: %v", err)
+	}
+
+	config := newTestConfig()
+	config.Set("pow.validateTransaction", true)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	// The trytes length is not validated by the test config, so the parser rejects the truncated transaction
+	request := &ipccommon.PowRequest{MWM: 14, Trytes: "ABC9"}
+	data, _ := request.Encode(ipccommon.FrameVersionV2)
+	msg, _ := ipccommon.NewIpcMessage(ipccommon.FrameVersionV2, 1, ipccommon.IpcCmdPowFunc, data)
+	requestBytes, _ := msg.ToBytes()
+	go client.Write(requestBytes)
+
+	frame := readResponse(t, client)
+	code, errMsg := ipccommon.DecodeErrorData(frame.Version, frame.Data)
+	if frame.Command != ipccommon.IpcCmdError || code != ipccommon.ErrorCodeInvalidTransaction || !strings.HasPrefix(errMsg, "Invalid transaction! Field: trytes") {
+		t.Errorf("Invalid transaction was not rejected, code %d, message %q", code, errMsg)
+	}
+
+	transaction := strings.Repeat("A", ipccommon.TransactionTrytesSize)
+	frame = sendRequest(t, client, 2, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte(transaction)...))
+	if frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Errorf("Unexpected response %+v for a valid transaction", frame)
+	}
+}
+
+func TestHandleClientConnectionDataLengthMismatch(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	// The DATA_LENGTH announces more data than the frame contains, but the CRC is valid
+	frameData := []byte{1, ipccommon.IpcCmdGetServerVersion, 0x00, 0x10, 'A', 'B', 'C', '9'}
+	msg := &ipccommon.IpcMessage{StartByte: ipccommon.FrameStartByte, FrameVersion: ipccommon.FrameVersionV1, FrameLength: len(frameData), FrameData: frameData}
+	msg.SetCrc8Table(ipccommon.Crc8Table)
+	request, err := msg.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Write(append(request, newServerVersionRequest(t, 2)...))
+
+	frame := readResponse(t, client)
+	if frame.Command != ipccommon.IpcCmdError || !strings.HasPrefix(string(frame.Data), "Wrong data length!") {
+		t.Errorf("Inconsistent frame was not rejected: %+v", frame)
+	}
+
+	// The connection is still usable
+	if frame := readResponse(t, client); frame.ReqID != 2 || frame.Command != ipccommon.IpcCmdResponse {
+		t.Errorf("Unexpected response %+v", frame)
+	}
+}
+
+func TestHandleClientConnectionClampMwm(t *testing.T) {
+	powMwm := make(chan int, 1)
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		powMwm <- mwm
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	config := newTestConfig()
+	config.Set("pow.clampMwm", true)
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	frame := sendRequest(t, client, 1, ipccommon.IpcCmdPowFunc, append([]byte{20}, []byte("ABC9")...))
+	if frame.Command != ipccommon.IpcCmdNotification || string(frame.Data) != "MinWeightMagnitude clamped to 14" {
+		t.Errorf("Unexpected notification %+v", frame)
+	}
+	if frame := readResponse(t, client); frame.ReqID != 1 || frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Errorf("Unexpected response %+v", frame)
+	}
+	if mwm := <-powMwm; mwm != 14 {
+		t.Errorf("POW done with MWM %d, expected 14", mwm)
+	}
+}
+
+func TestHandleClientConnectionMaxConcurrent(t *testing.T) {
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		started <- struct{}{}
+		<-unblock
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+	SetMaxConcurrentPow(1)
+	defer SetMaxConcurrentPow(0)
+
+	client1, server1 := net.Pipe()
+	defer client1.Close()
+	go HandleClientConnection(server1, newTestConfig(), "TestPow", "1.0")
+
+	client2, server2 := net.Pipe()
+	defer client2.Close()
+	go HandleClientConnection(server2, newTestConfig(), "TestPow", "1.0")
+
+	msg, _ := ipccommon.NewIpcMessageV1(1, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...))
+	request, _ := msg.ToBytes()
+	go client1.Write(request)
+	<-started
+
+	// The only slot is taken by the request of the first connection
+	if frame := sendRequest(t, client2, 2, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...)); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != "server overloaded" {
+		t.Errorf("Request above the limit was not rejected: %+v", frame)
+	}
+
+	close(unblock)
+	if frame := readResponse(t, client1); frame.Command != ipccommon.IpcCmdResponse {
+		t.Errorf("First request failed: %s", frame.Data)
+	}
+
+	// The slot is free again
+	if frame := sendRequest(t, client2, 3, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...)); frame.Command != ipccommon.IpcCmdResponse {
+		t.Errorf("Request after the release was rejected: %s", frame.Data)
+	}
+}
+
+func TestHandleClientConnectionResend(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	if frame := sendRequest(t, client, 0x71, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte("ABC9")...)); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("POW request failed: %s", frame.Data)
+	}
+
+	// The resend is usually requested on a new connection
+	client2, server2 := net.Pipe()
+	defer client2.Close()
+	go HandleClientConnection(server2, newTestConfig(), "TestPow", "1.0")
+
+	digest := ipccommon.ResendDigest(append([]byte{14}, []byte("ABC9")...))
+	if frame := sendRequest(t, client2, 0x71, ipccommon.IpcCmdResend, digest); frame.Command != ipccommon.IpcCmdResponse || frame.ReqID != 0x71 || string(frame.Data) != "NONCE" {
+		t.Errorf("Unexpected resent response %+v", frame)
+	}
+	if frame := sendRequest(t, client2, 0x72, ipccommon.IpcCmdResend, digest); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != errResponseNotCached.Error() {
+		t.Errorf("Resend of an unknown request not rejected: %+v", frame)
+	}
+	if frame := sendRequest(t, client2, 0x71, ipccommon.IpcCmdResend, nil); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != errResponseNotCached.Error() {
+		t.Errorf("Resend without the digest of the request not rejected: %+v", frame)
+	}
+}
+
+func TestHandleClientConnectionResendSameReqIDOtherClient(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE" + trytes, nil
+	})
+	defer SetPowFunc(nil)
+
+	// Every client numbers its requests on its own, so both use the same ReqID
+	requests := [][]byte{append([]byte{14}, []byte("ABC9")...), append([]byte{14}, []byte("DEF9")...)}
+	for _, request := range requests {
+		client, server := net.Pipe()
+		go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+		if frame := sendRequest(t, client, 0x71, ipccommon.IpcCmdPowFunc, request); frame.Command != ipccommon.IpcCmdResponse {
+			t.Fatalf("POW request failed: %s", frame.Data)
+		}
+		client.Close()
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, newTestConfig(), "TestPow", "1.0")
+
+	for _, expected := range []struct {
+		request  []byte
+		response string
+	}{{requests[0], "NONCEABC9"}, {requests[1], "NONCEDEF9"}} {
+		frame := sendRequest(t, client, 0x71, ipccommon.IpcCmdResend, ipccommon.ResendDigest(expected.request))
+		if frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != expected.response {
+			t.Errorf("Expected the response %s, got %+v", expected.response, frame)
+		}
+	}
+}
+
+func TestHandleClientConnectionWriteTimeout(t *testing.T) {
+	config := newTestConfig()
+	config.Set("server.writeTimeoutMs", 100)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		HandleClientConnection(server, config, "TestPow", "1.0")
+		close(done)
+	}()
+
+	// The client sends a request but never reads the response
+	go client.Write(newServerVersionRequest(t, 1))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection of a client that stopped reading was not closed")
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Connection not closed by the server: %v", err)
+	}
+}
+
+func TestHandleClientConnectionAllowedMwm(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	config := newTestConfig()
+	config.Set("pow.allowedMwm", []int{9, 14})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	for mwm, allowed := range map[byte]bool{9: true, 13: false, 14: true} {
+		frame := sendRequest(t, client, 1, ipccommon.IpcCmdPowFunc, append([]byte{mwm}, []byte("ABC9")...))
+		if allowed && frame.Command != ipccommon.IpcCmdResponse {
+			t.Errorf("MWM %d: rejected: %s", mwm, frame.Data)
+		}
+		if !allowed && (frame.Command != ipccommon.IpcCmdError || !strings.Contains(string(frame.Data), "not allowed")) {
+			t.Errorf("MWM %d: not rejected: %+v", mwm, frame)
+		}
+	}
+}
+
+func TestHandleClientConnectionPowBackend(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "DEVICE", nil
+	})
+	defer SetPowFunc(nil)
+	RegisterPowBackend("software", func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "SOFTWARE", nil
+	}, SoftwarePowType, "1.0")
+	defer UnregisterPowBackend("software")
+
+	tests := []struct {
+		primaryBackend string
+		backend        string
+		response       string
+	}{
+		{"", "", "DEVICE"},
+		{"", "software", "SOFTWARE"},
+		{"software", "", "SOFTWARE"},
+		{"", "unknown", "unknown PoW backend: unknown"},
+	}
+
+	for _, test := range tests {
+		config := newTestConfig()
+		config.Set("pow.primaryBackend", test.primaryBackend)
+
+		client, server := net.Pipe()
+		go HandleClientConnection(server, config, "TestPow", "1.0")
+
+		data, err := (&ipccommon.PowRequest{MWM: 14, Backend: test.backend, Trytes: "ABC9"}).Encode(ipccommon.FrameVersionV2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := ipccommon.NewIpcMessage(ipccommon.FrameVersionV2, 1, ipccommon.IpcCmdPowFunc, data)
+		request, _ := msg.ToBytes()
+		go client.Write(request)
+
+		frame := readResponse(t, client)
+		response := string(frame.Data)
+		if frame.Command == ipccommon.IpcCmdError {
+			_, response = ipccommon.DecodeErrorData(frame.Version, frame.Data)
+		}
+		if response != test.response {
+			t.Errorf("Backend %q, primary %q: unexpected response %s", test.backend, test.primaryBackend, response)
+		}
+		client.Close()
+	}
+}
+
+// shortWriteConn writes at most 5 bytes per call without returning an error, like a full non-blocking socket
+type shortWriteConn struct {
+	net.Conn
+}
+
+func (c *shortWriteConn) Write(b []byte) (int, error) {
+	if len(b) > 5 {
+		b = b[:5]
+	}
+	return c.Conn.Write(b)
+}
+
+func TestHandleClientConnectionShortWrites(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	})
+	defer SetPowFunc(nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(&shortWriteConn{server}, newTestConfig(), "TestPow", "1.0")
+
+	trytes := strings.Repeat("ABC9", 50)
+	for reqID := byte(1); reqID <= 3; reqID++ {
+		frame := sendRequest(t, client, reqID, ipccommon.IpcCmdPowFunc, append([]byte{14}, []byte(trytes)...))
+		if frame.ReqID != uint16(reqID) || frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != trytes {
+			t.Fatalf("Frame not delivered completely: ReqID %d, Cmd %X, Length %d", frame.ReqID, frame.Command, len(frame.Data))
+		}
+	}
+}
+
+func TestHandleClientConnectionErrorCodes(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "", errors.New("device not responding")
+	})
+	defer SetPowFunc(nil)
+
+	config := newTestConfig()
+	config.Set("pow.maxRequestsPerMinute", 4)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleClientConnection(server, config, "TestPow", "1.0")
+
+	// FRAME_VERSION==0x01 only carries the message
+	if frame := sendRequest(t, client, 1, ipccommon.IpcCmdPowFunc, append([]byte{15}, []byte("ABC9")...)); string(frame.Data) != "MinWeightMagnitude too high. MWM: 15 Allowed: 14" {
+		t.Errorf("Unexpected error of FRAME_VERSION 0x01: %q", frame.Data)
+	}
+
+	// The rate limit is exceeded by the last request
+	tests := []struct {
+		request *ipccommon.PowRequest
+		code    byte
+		msg     string
+	}{
+		{&ipccommon.PowRequest{MWM: 15, Trytes: "ABC9"}, ipccommon.ErrorCodeMwmTooHigh, "MinWeightMagnitude too high"},
+		{&ipccommon.PowRequest{MWM: 14, Trytes: "abc"}, ipccommon.ErrorCodeInvalidTrytes, ""},
+		{&ipccommon.PowRequest{MWM: 14, Trytes: "ABC9"}, ipccommon.ErrorCodeDevice, "device not responding"},
+		{&ipccommon.PowRequest{MWM: 14, Trytes: "ABC9"}, ipccommon.ErrorCodeOverloaded, "rate limit exceeded"},
+	}
+	for i, test := range tests {
+		data, err := test.request.Encode(ipccommon.FrameVersionV2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := ipccommon.NewIpcMessage(ipccommon.FrameVersionV2, uint16(i), ipccommon.IpcCmdPowFunc, data)
+		request, _ := msg.ToBytes()
+		go client.Write(request)
+
+		frame := readResponse(t, client)
+		code, errMsg := ipccommon.DecodeErrorData(frame.Version, frame.Data)
+		if frame.Command != ipccommon.IpcCmdError || code != test.code || !strings.HasPrefix(errMsg, test.msg) {
+			t.Errorf("Request %d: unexpected error code %d, message %q, expected %d", i, code, errMsg, test.code)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package ipcserver
+
+import (
+	"time"
+)
+
+// tokenBucket limits the rate of requests of a single connection
+// It is not safe for concurrent use, every connection owns its own bucket
+type tokenBucket struct {
+	capacity   float64   // Maximum number of tokens (burst size)
+	tokens     float64   // Number of currently available tokens
+	refillRate float64   // Number of tokens refilled per second
+	lastRefill time.Time // Time of the last refill
+}
+
+// newTokenBucket creates a bucket that allows requestsPerMinute requests per minute
+func newTokenBucket(requestsPerMinute int) *tokenBucket {
+	return &tokenBucket{
+		capacity:   float64(requestsPerMinute),
+		tokens:     float64(requestsPerMinute),
+		refillRate: float64(requestsPerMinute) / 60.0,
+		lastRefill: time.Now(),
+	}
+}
+
+// allow takes a token out of the bucket. It returns false if no token is available.
+func (b *tokenBucket) allow() bool {
+	now := time.Now()
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.refillRate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.lastRefill = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
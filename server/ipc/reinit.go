@@ -0,0 +1,56 @@
+package ipcserver
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/logs"
+)
+
+var (
+	reinitializing        int32 // 1 while the POW backend is reinitialized
+	powReinitLock         = &sync.Mutex{}
+	powReinitFunc         func() error // Re-runs the init of the POW backend, registered via SetPowReinitFunc
+	errPowReinitializing  = errors.New(common.ErrMsgPowReinitializing)
+	errReinitNotSupported = errors.New("reinit of the PoW backend not supported")
+)
+
+// SetPowReinitFunc registers the function that re-runs the init of the POW backend, e.g. of the device,
+// and sets the new POW function via SetPowFunc or one of its variants (nil = reinit not supported)
+func SetPowReinitFunc(f func() error) {
+	powReinitLock.Lock()
+	powReinitFunc = f
+	powReinitLock.Unlock()
+}
+
+// ReinitPow re-runs the init of the POW backend via the function registered by SetPowReinitFunc,
+// e.g. after the USB connection to the device dropped. The client connections are kept.
+// While the reinit is in progress, new POW requests are rejected with "PoW backend reinitializing".
+// Concurrent calls wait for the running reinit and then run it again. POW requests that were already queued
+// are finished by the previous POW function, requests still waiting for room in a full queue are queued for the new one.
+func ReinitPow() error {
+	powReinitLock.Lock()
+	defer powReinitLock.Unlock()
+
+	if powReinitFunc == nil {
+		return errReinitNotSupported
+	}
+
+	atomic.StoreInt32(&reinitializing, 1)
+	defer atomic.StoreInt32(&reinitializing, 0)
+
+	logs.Log.Info("Reinitializing the PoW backend...")
+	if err := powReinitFunc(); err != nil {
+		logs.Log.Errorf("Reinitializing the PoW backend failed: %v", err)
+		return err
+	}
+	logs.Log.Info("PoW backend reinitialized")
+	return nil
+}
+
+// IsReinitializing returns true while the POW backend is reinitialized via ReinitPow
+func IsReinitializing() bool {
+	return atomic.LoadInt32(&reinitializing) == 1
+}
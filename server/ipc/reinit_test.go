@@ -0,0 +1,194 @@
+package ipcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
+)
+
+func TestHandleClientConnectionReinit(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "", errors.New("device disconnected")
+	})
+	defer SetPowFunc(nil)
+
+	started := make(chan struct{})
+	release := make(chan error)
+	SetPowReinitFunc(func() error {
+		started <- struct{}{}
+		if err := <-release; err != nil {
+			return err
+		}
+		SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+			return "NONCE", nil
+		})
+		return nil
+	})
+	defer SetPowReinitFunc(nil)
+
+	config := newTestConfig()
+	config.Set("server.authKey", "secret")
+
+	adminClient, adminServer := net.Pipe()
+	defer adminClient.Close()
+	go HandleClientConnection(adminServer, config, "TestPow", "1.0")
+
+	nonce := sendRequest(t, adminClient, 1, ipccommon.IpcCmdAuth, nil).Data
+	if frame := sendRequest(t, adminClient, 2, ipccommon.IpcCmdAuth, common.AuthHMAC([]byte("secret"), nonce)); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Authentication failed: %s", frame.Data)
+	}
+
+	powClient, powServer := net.Pipe()
+	defer powClient.Close()
+	go HandleClientConnection(powServer, newTestConfig(), "TestPow", "1.0")
+
+	// A failed reinit is reported to the admin, the old POW function stays in place
+	reinitMsg, _ := ipccommon.NewIpcMessageV1(3, ipccommon.IpcCmdAdmin, []byte{ipccommon.AdminOpReinit})
+	reinitRequest, _ := reinitMsg.ToBytes()
+	go adminClient.Write(reinitRequest)
+	<-started
+	release <- errors.New("device not found")
+	if frame := readResponse(t, adminClient); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != "device not found" {
+		t.Errorf("Failed reinit was not reported: %+v", frame)
+	}
+
+	// POW requests are rejected while the reinit is in progress, the connections are kept
+	go adminClient.Write(reinitRequest)
+	<-started
+	powRequest := append([]byte{14}, []byte("ABC9")...)
+	if frame := sendRequest(t, powClient, 4, ipccommon.IpcCmdPowFunc, powRequest); frame.Command != ipccommon.IpcCmdError || string(frame.Data) != common.ErrMsgPowReinitializing {
+		t.Errorf("POW was not rejected during the reinit: %+v", frame)
+	}
+	release <- nil
+	if frame := readResponse(t, adminClient); frame.Command != ipccommon.IpcCmdResponse {
+		t.Fatalf("Reinit failed: %s", frame.Data)
+	}
+
+	if frame := sendRequest(t, powClient, 5, ipccommon.IpcCmdPowFunc, powRequest); frame.Command != ipccommon.IpcCmdResponse || string(frame.Data) != "NONCE" {
+		t.Errorf("The reinitialized POW function was not used: %+v", frame)
+	}
+}
+
+func TestReinitPowNotSupported(t *testing.T) {
+	if err := ReinitPow(); err != errReinitNotSupported {
+		t.Errorf("Expected errReinitNotSupported, got %v", err)
+	}
+}
+
+// startQueueFiller queues POW requests until the wedged worker runs one, one waits in the full queue
+// and another one is blocked sending to it. The results are sent to the returned channel.
+func startQueueFiller(t *testing.T, started chan struct{}) chan error {
+	t.Helper()
+
+	results := make(chan error, 3)
+	depth := atomic.LoadInt64(&statsQueueDepth)
+	for reqID := uint16(1); reqID <= 3; reqID++ {
+		go func(reqID uint16) {
+			_, _, err := powFunc(1, reqID, "", "ABC9", 14, false)
+			results <- err
+		}(reqID)
+		if reqID == 1 {
+			<-started
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&statsQueueDepth) != depth+2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Requests were not queued in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return results
+}
+
+func TestReinitPowWithFullQueue(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		started <- struct{}{}
+		<-release
+		return "", errors.New("device disconnected")
+	})
+	defer SetPowFunc(nil)
+
+	SetPowReinitFunc(func() error {
+		SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+			return "NONCE", nil
+		})
+		return nil
+	})
+	defer SetPowReinitFunc(nil)
+
+	results := startQueueFiller(t, started)
+
+	reinitDone := make(chan error, 1)
+	go func() { reinitDone <- ReinitPow() }()
+	select {
+	case err := <-reinitDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ReinitPow blocked on the full queue")
+	}
+
+	if !isPowReady() {
+		t.Error("POW not ready after the reinit")
+	}
+
+	// The request blocked on the full queue is done by the new POW function
+	select {
+	case err := <-results:
+		if err != nil {
+			t.Errorf("The blocked request was not queued for the new POW function: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The blocked request was not queued for the new POW function")
+	}
+
+	// The requests that were already queued are finished by the previous POW function
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err == nil || err.Error() != "device disconnected" {
+			t.Errorf("Expected the error of the previous POW function, got %v", err)
+		}
+	}
+}
+
+func TestPowFuncCancelBlockedOnFullQueue(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	SetCancellablePowFunc(func(ctx context.Context, trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		started <- struct{}{}
+		<-release
+		return "", errors.New("device disconnected")
+	})
+	defer SetPowFunc(nil)
+
+	results := startQueueFiller(t, started)
+
+	// The requests are sent in order, but the one that is blocked isn't known, so all queued ones are cancelled
+	cancelPow(1, 2)
+	cancelPow(1, 3)
+	select {
+	case err := <-results:
+		if err != errPowCancelled {
+			t.Errorf("Expected errPowCancelled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The request blocked on the full queue was not cancelled")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		<-results
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package ipcserver
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ReinitPowOnSignal reinitializes the POW backend every time the process receives SIGUSR2
+func ReinitPowOnSignal() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGUSR2)
+	go func() {
+		for range sigc {
+			// The result is logged by ReinitPow
+			ReinitPow()
+		}
+	}()
+}
@@ -0,0 +1,7 @@
+//go:build windows
+// +build windows
+
+package ipcserver
+
+// ReinitPowOnSignal does nothing on Windows, there is no SIGUSR2 (use IpcCmdAdmin instead)
+func ReinitPowOnSignal() {}
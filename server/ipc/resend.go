@@ -0,0 +1,80 @@
+package ipcserver
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// responseCacheSize is the number of POW responses kept for IpcCmdResend
+const responseCacheSize = 16
+
+var errResponseNotCached = errors.New("no cached response for this ReqID")
+
+// responseCacheKey identifies a request, the REQ_ID alone is ambiguous across frame versions and clients
+type responseCacheKey struct {
+	version byte
+	reqID   uint16
+	digest  string // ipccommon.ResendDigest of the DATA of the request
+}
+
+// responseCacheEntry is the DATA of a response sent to the client
+type responseCacheEntry struct {
+	key  responseCacheKey
+	data []byte
+}
+
+// responseCache keeps the most recent POW responses, so a response that was corrupted on the way to the
+// client can be sent again via IpcCmdResend instead of repeating the POW
+// Requests are identified by frame version, ReqID and the digest of the request, because every client numbers
+// its requests on its own and a resend is usually requested on a new connection.
+type responseCache struct {
+	lock    sync.Mutex
+	size    int
+	order   *list.List // Most recently added entries first
+	entries map[responseCacheKey]*list.Element
+}
+
+// recentResponses are the POW responses of all connections, a resend is usually requested on a new connection
+var recentResponses = newResponseCache(responseCacheSize)
+
+func newResponseCache(size int) *responseCache {
+	return &responseCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[responseCacheKey]*list.Element),
+	}
+}
+
+// add stores the response of a request and evicts the oldest response if the cache is full
+// A previous response of the same request is replaced
+func (r *responseCache) add(version byte, reqID uint16, digest []byte, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := responseCacheKey{version: version, reqID: reqID, digest: string(digest)}
+	if element, ok := r.entries[key]; ok {
+		element.Value.(*responseCacheEntry).data = data
+		r.order.MoveToFront(element)
+		return
+	}
+
+	r.entries[key] = r.order.PushFront(&responseCacheEntry{key: key, data: data})
+	if r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*responseCacheEntry).key)
+	}
+}
+
+// get returns the cached response of a request
+func (r *responseCache) get(version byte, reqID uint16, digest []byte) ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	element, ok := r.entries[responseCacheKey{version: version, reqID: reqID, digest: string(digest)}]
+	if !ok {
+		return nil, errResponseNotCached
+	}
+	return element.Value.(*responseCacheEntry).data, nil
+}
@@ -0,0 +1,36 @@
+package ipcserver
+
+import (
+	"testing"
+)
+
+func TestResponseCacheEvictsOldest(t *testing.T) {
+	cache := newResponseCache(2)
+	cache.add(1, 1, nil, []byte("A"))
+	cache.add(1, 2, nil, []byte("B"))
+	cache.add(2, 2, nil, []byte("C"))
+
+	if _, err := cache.get(1, 1, nil); err != errResponseNotCached {
+		t.Errorf("Oldest response not evicted: %v", err)
+	}
+	for _, expected := range []struct {
+		version  byte
+		reqID    uint16
+		response string
+	}{{1, 2, "B"}, {2, 2, "C"}} {
+		response, err := cache.get(expected.version, expected.reqID, nil)
+		if err != nil || string(response) != expected.response {
+			t.Errorf("Version %d, ReqID %d: %q (%v), expected %q", expected.version, expected.reqID, response, err, expected.response)
+		}
+	}
+
+	// A replaced response becomes the most recent one
+	cache.add(1, 2, nil, []byte("D"))
+	cache.add(1, 3, nil, []byte("E"))
+	if response, err := cache.get(1, 2, nil); err != nil || string(response) != "D" {
+		t.Errorf("Replaced response: %q (%v), expected \"D\"", response, err)
+	}
+	if _, err := cache.get(2, 2, nil); err != errResponseNotCached {
+		t.Errorf("Oldest response not evicted: %v", err)
+	}
+}
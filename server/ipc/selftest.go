@@ -0,0 +1,31 @@
+package ipcserver
+
+import (
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/logs"
+)
+
+// selfTest does the POW of the self-test transaction with the backend and verifies the nonce
+// It returns an error if the POW failed, and passed == false if the POW implementation returned a wrong nonce.
+func selfTest(connID uint64, reqID uint16, backend string) (passed bool, durationMs int64, err error) {
+	if !isPowReady() {
+		return false, 0, errPowNotReady
+	}
+
+	releasePowSlot, ok := acquirePowSlot()
+	if !ok {
+		return false, 0, errServerOverloaded
+	}
+	defer releasePowSlot()
+
+	nonce, durationMs, err := powFunc(connID, reqID, backend, common.SelfTestTransaction, common.SelfTestMinWeightMagnitude, false)
+	if err != nil {
+		return false, 0, err
+	}
+
+	passed = common.VerifyNonce(common.SelfTestTransaction, nonce, common.SelfTestMinWeightMagnitude)
+	if !passed {
+		logs.Log.Errorf("Self-test failed, the POW implementation returned the invalid nonce %v", nonce)
+	}
+	return passed, durationMs, nil
+}
@@ -1,37 +1,476 @@
 package ipcserver
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/iotaledger/giota"
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/common/ipccommon"
 	"github.com/muxxer/diverdriver/logs"
 )
 
+// CancellablePowFunc is a POW function that stops the POW as soon as the context is cancelled
+type CancellablePowFunc func(ctx context.Context, trytes giota.Trytes, mwm int) (giota.Trytes, error)
+
+// powAdmissionTimeout is the time a POW request waits for a free slot of "pow.maxConcurrent" before it is rejected
+const powAdmissionTimeout = 100 * time.Millisecond
+
+// PowDescriptor describes the POW implementation of the worker pool
+type PowDescriptor struct {
+	Type     string   // Name of the POW implementation (e.g. PiDiver)
+	Version  string   // Version of the POW implementation (e.g. PiDiver FPGA Core Version)
+	Features []string // Features supported by the POW implementation, e.g. of the firmware of the device (reported via IpcCmdGetVersions)
+}
+
+// powJob is a single POW request waiting in the queue of the worker pool
+type powJob struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	trytes   giota.Trytes
+	mwm      int
+	queuedAt time.Time // Time the job was queued, to measure the wait for a worker
+	result   chan powJobResult
+}
+
+// powJobResult is the result of a powJob, sent back by the worker
+type powJobResult struct {
+	trytes     giota.Trytes
+	durationMs int64
+	err        error
+}
+
+// powQueues are the queues of the worker pool or of a POW backend registered by name
+// The queues are never closed while a request may still send to them. Replaced queues are stopped via done,
+// which wakes the requests blocked on a full queue, and closed after the last of these requests left.
+type powQueues struct {
+	queueHigh chan *powJob   // Queue of the high priority POW requests, dequeued first by the workers
+	queue     chan *powJob   // Queue of the normal priority POW requests
+	done      chan struct{}  // Closed when the queues are replaced
+	senders   sync.WaitGroup // Requests that may still send to the queues
+}
+
+// newPowQueues creates the queues for a pool of the given number of workers
+func newPowQueues(size int) *powQueues {
+	return &powQueues{
+		queueHigh: make(chan *powJob, size),
+		queue:     make(chan *powJob, size),
+		done:      make(chan struct{}),
+	}
+}
+
+// stop wakes the requests waiting to send to the queues and stops the workers after the last of them left
+// Jobs that were already queued are still done by the workers.
+func (q *powQueues) stop() {
+	close(q.done)
+	go func() {
+		q.senders.Wait()
+		close(q.queueHigh)
+		close(q.queue)
+	}()
+}
+
 var (
-	powMutex   = &sync.Mutex{}
-	powFuncPtr giota.PowFunc
+	powQueueLock          = &sync.RWMutex{}
+	powPool               *powQueues // Queues of the worker pool set via SetPowFunc
+	powCancelSupport      bool       // True if the POW functions of the pool support cancellation
+	powReady              bool       // True if the pool contains at least one POW function, false until the backend is initialized
+	powDescriptor         PowDescriptor
+	powBackends           = make(map[string]*powBackend) // POW backends registered by name via RegisterPowBackend
+	errPowNotReady        = errors.New(common.ErrMsgPowNotReady)
+	errPowCancelled       = errors.New("POW cancelled")
+	errCancelNotFound     = errors.New("no running POW request with this ReqID")
+	errCancelNotSupported = errors.New("cancel not supported")
+
+	powSlotsLock        = &sync.Mutex{}
+	powSlots            chan struct{} // Counting semaphore for "pow.maxConcurrent", nil = unlimited
+	errServerOverloaded = errors.New("server overloaded")
+
+	runningPowJobsLock = &sync.Mutex{}
+	runningPowJobs     = make(map[powJobKey]*powJob) // Running POW requests by connection and ReqID
 )
 
+// powJobKey identifies a running POW request: the ReqIDs are only unique per connection,
+// every client numbers its requests on its own
+type powJobKey struct {
+	connID uint64
+	reqID  uint16
+}
+
 // SetPowFunc sets the function pointer for POW
 func SetPowFunc(f giota.PowFunc) {
-	powFuncPtr = f
+	SetPowFuncPool([]giota.PowFunc{f})
+}
+
+// SetCancellablePowFunc sets the function pointer for POW of a POW implementation that supports cancellation
+func SetCancellablePowFunc(f CancellablePowFunc) {
+	SetCancellablePowFuncPool([]CancellablePowFunc{f})
+}
+
+// SetPowFuncPool starts one POW worker for every function pointer in the pool
+// Every function should be bound to a distinct device, so the workers can do POW in parallel
+func SetPowFuncPool(funcs []giota.PowFunc) {
+	cancellableFuncs := make([]CancellablePowFunc, len(funcs))
+	for i, f := range funcs {
+		if f == nil {
+			continue
+		}
+		f := f
+		cancellableFuncs[i] = func(ctx context.Context, trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+			return f(trytes, mwm)
+		}
+	}
+	setPowFuncPool(cancellableFuncs, false)
+}
+
+// SetPowFuncPoolWithDescriptor starts the workers like SetPowFuncPool and registers the descriptor of the POW implementation,
+// so the clients can adapt to the features it supports
+func SetPowFuncPoolWithDescriptor(funcs []giota.PowFunc, descriptor PowDescriptor) {
+	SetPowFuncPool(funcs)
+
+	powQueueLock.Lock()
+	powDescriptor = descriptor
+	powQueueLock.Unlock()
+}
+
+// getPowDescriptor returns the descriptor registered via SetPowFuncPoolWithDescriptor
+func getPowDescriptor() PowDescriptor {
+	powQueueLock.RLock()
+	defer powQueueLock.RUnlock()
+
+	return powDescriptor
+}
+
+// powBackend is a POW implementation registered by name, with its own worker and queues
+type powBackend struct {
+	powType    string
+	powVersion string
+	queues     *powQueues
+}
+
+// RegisterPowBackend starts a worker for the POW function, which is used for POW requests that name the backend,
+// e.g. a software fallback next to a device. The worker pool set via SetPowFunc stays the backend of all other requests.
+// A backend that is already registered with the name is replaced.
+func RegisterPowBackend(name string, f giota.PowFunc, powType string, powVersion string) {
+	backend := &powBackend{
+		powType:    powType,
+		powVersion: powVersion,
+		queues:     newPowQueues(1),
+	}
+	go powWorker(0, func(ctx context.Context, trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return f(trytes, mwm)
+	}, backend.queues.queueHigh, backend.queues.queue)
+
+	powQueueLock.Lock()
+	oldBackend := powBackends[name]
+	powBackends[name] = backend
+	powQueueLock.Unlock()
+
+	if oldBackend != nil {
+		oldBackend.queues.stop()
+	}
+}
+
+// UnregisterPowBackend stops the worker of the POW backend registered with the name
+// Requests naming the backend are rejected afterwards, requests that are already queued are still done.
+func UnregisterPowBackend(name string) {
+	powQueueLock.Lock()
+	backend := powBackends[name]
+	delete(powBackends, name)
+	powQueueLock.Unlock()
+
+	if backend != nil {
+		backend.queues.stop()
+	}
 }
 
-// powFunc calls the hardware POW secured by a Mutex
-func powFunc(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
-	powMutex.Lock()
-	defer powMutex.Unlock()
+// hasPowBackend returns true if a POW backend is registered with the name
+func hasPowBackend(name string) bool {
+	powQueueLock.RLock()
+	defer powQueueLock.RUnlock()
 
-	if powFuncPtr == nil {
-		return "", errors.New("powFunc not initialized")
+	_, ok := powBackends[name]
+	return ok
+}
+
+// unknownPowBackendError is returned for POW requests that name a backend that is not registered
+func unknownPowBackendError(name string) error {
+	return fmt.Errorf("unknown PoW backend: %v", name)
+}
+
+// getPowBackends returns the POW backends registered via RegisterPowBackend, sorted by name
+func getPowBackends() []common.PowBackend {
+	powQueueLock.RLock()
+	defer powQueueLock.RUnlock()
+
+	var backends []common.PowBackend
+	for name, backend := range powBackends {
+		backends = append(backends, common.PowBackend{Name: name, PowType: backend.powType, PowVersion: backend.powVersion})
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].Name < backends[j].Name })
+	return backends
+}
+
+// SetCancellablePowFuncPool starts one POW worker for every function pointer in the pool
+// A running POW is cancelled via IpcCmdCancelPow
+func SetCancellablePowFuncPool(funcs []CancellablePowFunc) {
+	setPowFuncPool(funcs, true)
+}
+
+// SetMaxConcurrentPow limits the number of POW requests that are queued or running at once across all connections (0 = unlimited)
+// Unlike the number of workers, this bounds the requests that are admitted to the queue at all.
+func SetMaxConcurrentPow(maxConcurrent int) {
+	var slots chan struct{}
+	if maxConcurrent > 0 {
+		slots = make(chan struct{}, maxConcurrent)
 	}
 
-	logs.Log.Debugf("Starting PoW! Weight: %d", mwm)
+	powSlotsLock.Lock()
+	powSlots = slots
+	powSlotsLock.Unlock()
+}
+
+// acquirePowSlot waits up to powAdmissionTimeout for a free slot of "pow.maxConcurrent"
+// It returns false if no slot became free in time, otherwise the slot has to be released via the returned function.
+func acquirePowSlot() (release func(), ok bool) {
+	powSlotsLock.Lock()
+	slots := powSlots
+	powSlotsLock.Unlock()
+
+	if slots == nil {
+		return func() {}, true
+	}
+
+	timer := time.NewTimer(powAdmissionTimeout)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		// The slot is released to the semaphore it was taken from, even if the limit was changed in the meantime
+		return func() { <-slots }, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
+func setPowFuncPool(funcs []CancellablePowFunc, cancelSupport bool) {
+	pool := newPowQueues(len(funcs))
+	ready := false
+	for workerID, f := range funcs {
+		go powWorker(workerID, f, pool.queueHigh, pool.queue)
+		ready = ready || f != nil
+	}
+
+	powQueueLock.Lock()
+	oldPool := powPool
+	powPool = pool
+	powCancelSupport = cancelSupport
+	powReady = ready
+	powQueueLock.Unlock()
+
+	if oldPool != nil {
+		// Stop the workers of the old pool
+		oldPool.stop()
+	}
+}
+
+// powWorker does POW for all the jobs received via the queues until both queues are closed
+// Whenever the worker is free, a waiting high priority job is taken before any normal priority job.
+// The priority only affects the order of the queue, a running job is never preempted.
+func powWorker(workerID int, f CancellablePowFunc, queueHigh chan *powJob, queue chan *powJob) {
+	// A closed queue is set to nil, so it blocks forever and the other queue is drained
+	for queueHigh != nil || queue != nil {
+		var job *powJob
+		var ok bool
+
+		select {
+		case job, ok = <-queueHigh:
+			if !ok {
+				queueHigh = nil
+				continue
+			}
+		default:
+			select {
+			case job, ok = <-queueHigh:
+				if !ok {
+					queueHigh = nil
+					continue
+				}
+			case job, ok = <-queue:
+				if !ok {
+					queue = nil
+					continue
+				}
+			}
+		}
+
+		doPowJob(workerID, f, job)
+	}
+}
+
+// doPowJob does the POW of a single job and sends the result back to the waiting request
+func doPowJob(workerID int, f CancellablePowFunc, job *powJob) {
+	atomic.AddInt64(&statsQueueDepth, -1)
+
+	// A high wait compared to the duration of the POW shows that more workers are needed
+	waitMs := int64(time.Since(job.queuedAt) / time.Millisecond)
+	addQueueWaitStats(waitMs)
+	addQueueWaitMetrics(waitMs)
+
+	if f == nil {
+		job.result <- powJobResult{err: errPowNotReady}
+		return
+	}
+
+	if job.ctx.Err() != nil {
+		// Cancelled while waiting in the queue
+		job.result <- powJobResult{err: errPowCancelled}
+		return
+	}
+
+	logs.Log.Debugf("Starting PoW! Worker: %d, Weight: %d, Queue wait: %d [ms]", workerID, job.mwm, waitMs)
 	ts := time.Now()
-	result, err := powFuncPtr(trytes, mwm)
-	logs.Log.Debugf("Finished PoW! Time: %d [ms]", (int64(time.Since(ts) / time.Millisecond)))
+	result, err := f(job.ctx, job.trytes, job.mwm)
+	if err != nil && job.ctx.Err() != nil {
+		err = errPowCancelled
+	} else if err != nil {
+		err = withErrorCode(ipccommon.ErrorCodeDevice, err)
+	}
+	durationMs := int64(time.Since(ts) / time.Millisecond)
+	logs.Log.Debugf("Finished PoW! Worker: %d, Time: %d [ms]", workerID, durationMs)
+
+	if err == nil {
+		addPowStats(durationMs, job.mwm)
+	}
+	addPowMetrics(durationMs, err)
+
+	job.result <- powJobResult{trytes: result, durationMs: durationMs, err: err}
+}
+
+// isPowReady returns true if a POW function was set via SetPowFunc or one of its variants, or a backend was registered
+// Until then POW requests are rejected, while all other commands are answered.
+func isPowReady() bool {
+	powQueueLock.RLock()
+	defer powQueueLock.RUnlock()
+
+	return powReady || len(powBackends) > 0
+}
+
+// powFunc queues the POW request for the worker pool and waits for the result
+// If all workers are busy and the queue is full, the request blocks until a slot is free, the request is cancelled
+// or the pool is replaced, e.g. by ReinitPow, in which case it is queued for the new pool
+// High priority requests are dequeued before all normal priority requests, but never preempt a running POW
+// Requests of the same priority are served in arrival order, because the senders blocked on a channel are queued FIFO
+// (unlike the waiters of a sync.Mutex), so no request starves behind later ones under load
+// The request can be cancelled via cancelPow with the given connection id and ReqID while it is running
+// The request is queued for the POW backend with the given name (empty = the pool set via SetPowFunc)
+// It returns the result and the time in ms the worker needed for the POW
+func powFunc(connID uint64, reqID uint16, backend string, trytes giota.Trytes, mwm int, highPriority bool) (giota.Trytes, int64, error) {
+	addMwmStats(mwm)
+	addMwmMetrics(mwm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	job := &powJob{ctx: ctx, cancel: cancel, trytes: trytes, mwm: mwm, queuedAt: time.Now(), result: make(chan powJobResult, 1)}
+
+	key := powJobKey{connID: connID, reqID: reqID}
+	runningPowJobsLock.Lock()
+	runningPowJobs[key] = job
+	runningPowJobsLock.Unlock()
+	defer func() {
+		runningPowJobsLock.Lock()
+		// Another request of the connection with the same ReqID may have replaced the entry in the meantime
+		if runningPowJobs[key] == job {
+			delete(runningPowJobs, key)
+		}
+		runningPowJobsLock.Unlock()
+	}()
+
+	atomic.AddInt64(&statsQueueDepth, 1)
+	if err := enqueuePowJob(backend, job, highPriority); err != nil {
+		atomic.AddInt64(&statsQueueDepth, -1)
+		return "", 0, err
+	}
+
+	result := <-job.result
+	return result.trytes, result.durationMs, result.err
+}
+
+// enqueuePowJob sends the job to the queues of the POW backend with the given name (empty = the pool set via SetPowFunc)
+// powQueueLock is not held during the send, so a full queue of a wedged device never blocks the replacement of the pool.
+func enqueuePowJob(backend string, job *powJob, highPriority bool) error {
+	for {
+		queues, err := acquirePowQueues(backend)
+		if err != nil {
+			return err
+		}
+
+		queue := queues.queue
+		if highPriority {
+			queue = queues.queueHigh
+		}
+
+		select {
+		case queue <- job:
+			queues.senders.Done()
+			return nil
+		case <-queues.done:
+			// The queues were replaced while the request was waiting, try again with the current ones
+			queues.senders.Done()
+		case <-job.ctx.Done():
+			queues.senders.Done()
+			return errPowCancelled
+		}
+	}
+}
+
+// acquirePowQueues returns the current queues of the POW backend with the given name (empty = the pool set via SetPowFunc)
+// The caller is registered as sender of the queues and has to call senders.Done after the send.
+func acquirePowQueues(backend string) (*powQueues, error) {
+	powQueueLock.RLock()
+	defer powQueueLock.RUnlock()
+
+	queues := powPool
+	if backend != "" {
+		namedBackend, ok := powBackends[backend]
+		if !ok {
+			return nil, unknownPowBackendError(backend)
+		}
+		queues = namedBackend.queues
+	}
+	if queues == nil {
+		return nil, errPowNotReady
+	}
+
+	queues.senders.Add(1)
+	return queues, nil
+}
+
+// cancelPow cancels the running POW request with the given ReqID of the connection
+// Only requests sent on the same connection can be cancelled, so clients can't cancel the POW of each other
+func cancelPow(connID uint64, reqID uint16) error {
+	powQueueLock.RLock()
+	cancelSupport := powCancelSupport
+	powQueueLock.RUnlock()
+
+	if !cancelSupport {
+		return errCancelNotSupported
+	}
+
+	runningPowJobsLock.Lock()
+	job, ok := runningPowJobs[powJobKey{connID: connID, reqID: reqID}]
+	runningPowJobsLock.Unlock()
+
+	if !ok {
+		return errCancelNotFound
+	}
 
-	return result, err
+	job.cancel()
+	return nil
 }
@@ -0,0 +1,144 @@
+package ipcserver
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+func TestPowFuncHighPriorityDequeuedFirst(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	order := make(chan giota.Trytes, 3)
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if trytes == "BLOCK9" {
+			close(started)
+			<-release
+		}
+		order <- trytes
+		return trytes, nil
+	})
+	defer SetPowFunc(nil)
+
+	done := make(chan struct{}, 3)
+	request := func(trytes giota.Trytes, highPriority bool) {
+		powFunc(0, 0, "", trytes, 14, highPriority)
+		done <- struct{}{}
+	}
+
+	// The single worker is busy with the first request, the others have to wait in the queues
+	go request("BLOCK9", false)
+	<-started
+	go request("NORMAL9", false)
+	waitForQueues(t, func(high, normal int) bool { return normal == 1 })
+	go request("HIGH9", true)
+	waitForQueues(t, func(high, normal int) bool { return high == 1 })
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	for _, expected := range []giota.Trytes{"BLOCK9", "HIGH9", "NORMAL9"} {
+		if trytes := <-order; trytes != expected {
+			t.Errorf("Expected %s, got %s", expected, trytes)
+		}
+	}
+}
+
+// waitForQueues waits until the number of queued requests satisfies the condition
+func waitForQueues(t *testing.T, condition func(high, normal int) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		powQueueLock.RLock()
+		high, normal := len(powPool.queueHigh), len(powPool.queue)
+		powQueueLock.RUnlock()
+
+		if condition(high, normal) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Requests were not queued in time")
+}
+
+func TestPowFuncServedInArrivalOrder(t *testing.T) {
+	const requests = 32
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	order := make(chan giota.Trytes, requests+1)
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if trytes == "BLOCK9" {
+			close(started)
+			<-release
+		}
+		order <- trytes
+		return trytes, nil
+	})
+	defer SetPowFunc(nil)
+
+	done := make(chan struct{}, requests+1)
+	request := func(trytes giota.Trytes) {
+		powFunc(0, 0, "", trytes, 14, false)
+		done <- struct{}{}
+	}
+
+	// The single worker is busy with the first request, the others arrive one after another.
+	// The first one fills the queue, the rest block on the send to the queue.
+	go request("BLOCK9")
+	<-started
+	go request(queueOrderTrytes(0))
+	waitForQueues(t, func(high, normal int) bool { return normal == 1 })
+	for i := 1; i < requests; i++ {
+		go request(queueOrderTrytes(i))
+		waitForBlockedPowRequests(t, i)
+	}
+
+	close(release)
+	for i := 0; i < requests+1; i++ {
+		<-done
+	}
+
+	// Every request waits only for the requests that arrived before it
+	if trytes := <-order; trytes != "BLOCK9" {
+		t.Fatalf("Expected BLOCK9, got %s", trytes)
+	}
+	for i := 0; i < requests; i++ {
+		if trytes := <-order; trytes != queueOrderTrytes(i) {
+			t.Errorf("Request %d: expected %s, got %s", i, queueOrderTrytes(i), trytes)
+		}
+	}
+}
+
+// queueOrderTrytes returns distinct trytes for the i-th request of TestPowFuncServedInArrivalOrder
+func queueOrderTrytes(i int) giota.Trytes {
+	return giota.Trytes(fmt.Sprintf("REQ%c%c", 'A'+i/26, 'A'+i%26))
+}
+
+// waitForBlockedPowRequests waits until the number of requests blocked on the send to a full POW queue reaches n
+func waitForBlockedPowRequests(t *testing.T, n int) {
+	t.Helper()
+
+	buf := make([]byte, 1<<20)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		blocked := 0
+		for _, g := range strings.Split(string(buf[:runtime.Stack(buf, true)]), "\n\n") {
+			if strings.Contains(g, "[select") && strings.Contains(g, ".enqueuePowJob(") {
+				blocked++
+			}
+		}
+		if blocked >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d requests were not blocked on the queue in time", n)
+}
@@ -0,0 +1,47 @@
+package ipcserver
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// SetSocketPermissions changes the file mode and the group of the Unix socket file at the path,
+// so only the members of a group can connect to the diverDriver on a multi-user system.
+// The mode is given in octal, e.g. "0660" (empty = unchanged). The group is a name or a numeric id (empty = unchanged).
+// It has to be called right after Listen created the socket.
+func SetSocketPermissions(path string, mode string, group string) error {
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0777 {
+			return fmt.Errorf("Invalid socket mode! Mode: %v, Expected: octal permissions, e.g. 0660", mode)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			return err
+		}
+	}
+
+	if group != "" {
+		gid, err := lookupGroupID(group)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupGroupID returns the id of the group with the given name or numeric id
+func lookupGroupID(group string) (int, error) {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		g, err = user.LookupGroupId(group)
+		if err != nil {
+			return 0, fmt.Errorf("Unknown socket group: %v", group)
+		}
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,17 @@
+package ipcserver
+
+import (
+	"github.com/iotaledger/giota"
+)
+
+// SoftwarePowType is the POW type of SoftwarePowFunc
+const SoftwarePowType = "SoftwarePoW"
+
+// SoftwarePowFunc does POW in software with the fastest POW implementation of giota available on this machine
+// It needs no device, so a diverDriver can be run and tested end-to-end without hardware, e.g. in CI:
+//
+//	ipcserver.SetPowFunc(ipcserver.SoftwarePowFunc)
+func SoftwarePowFunc(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	_, powFunc := giota.GetBestPoW()
+	return powFunc(trytes, mwm)
+}
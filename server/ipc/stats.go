@@ -0,0 +1,197 @@
+package ipcserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/logs"
+)
+
+var (
+	startTime = time.Now() // Start of the server, used for the uptime
+
+	statsPowCount          uint64 // Number of successful POW requests
+	statsPowDurationMs     uint64 // Summed up duration of all successful POW requests in ms
+	statsQueueDepth        int64  // Number of POW requests waiting for a worker
+	statsQueueWaitCount    uint64 // Number of POW requests taken from the queue by a worker
+	statsQueueWaitMs       uint64 // Summed up time the POW requests waited for a worker in ms
+	statsActiveConnections int64  // Number of connected clients
+
+	// The counters are added with the read lock, so a reset with the write lock takes a consistent snapshot
+	// of all counters without losing concurrent increments
+	statsResetLock = &sync.RWMutex{}
+
+	mwmHistogramLock = &sync.Mutex{}
+	mwmHistogram     = make(map[int]uint64) // Number of POW requests per MWM
+	mostRequestedMwm = -1                   // MWM with the most POW requests
+
+	// Successful POW requests and their MWM of the last minute, the rate reflects the recent load
+	powRate = newRateWindow(time.Now())
+)
+
+// rateWindowSeconds is the length of the window of the POW rate in seconds
+const rateWindowSeconds = 60
+
+// rateWindow counts events and sums up a value of the events per second over the last rateWindowSeconds
+type rateWindow struct {
+	lock    sync.Mutex
+	start   time.Time // Start of the counting, a shorter time than the window is not extrapolated
+	buckets [rateWindowSeconds]rateBucket
+}
+
+// rateBucket are the events of a single second of a rateWindow
+type rateBucket struct {
+	second int64  // Unix time of the bucket, older buckets are reused for later seconds
+	count  uint64 // Number of events
+	sum    uint64 // Summed up value of the events
+}
+
+func newRateWindow(start time.Time) *rateWindow {
+	return &rateWindow{start: start}
+}
+
+// add counts an event with the value at the given time
+func (w *rateWindow) add(now time.Time, value int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	second := now.Unix()
+	bucket := &w.buckets[second%rateWindowSeconds]
+	if bucket.second != second {
+		*bucket = rateBucket{second: second}
+	}
+	bucket.count++
+	bucket.sum += uint64(value)
+}
+
+// get returns the events per second and the average value of the events within the window before the given time
+func (w *rateWindow) get(now time.Time) (perSecond float64, average float64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var count, sum uint64
+	second := now.Unix()
+	for _, bucket := range w.buckets {
+		if second-bucket.second < rateWindowSeconds {
+			count += bucket.count
+			sum += bucket.sum
+		}
+	}
+	if count == 0 {
+		return 0, 0
+	}
+
+	elapsed := now.Sub(w.start).Seconds()
+	if elapsed > rateWindowSeconds {
+		elapsed = rateWindowSeconds
+	} else if elapsed < 1 {
+		elapsed = 1
+	}
+	return float64(count) / elapsed, float64(sum) / float64(count)
+}
+
+// addMwmStats adds the MWM of a POW request to the histogram
+// A shift of the most requested MWM is logged for capacity planning
+func addMwmStats(mwm int) {
+	statsResetLock.RLock()
+	defer statsResetLock.RUnlock()
+
+	mwmHistogramLock.Lock()
+	defer mwmHistogramLock.Unlock()
+
+	mwmHistogram[mwm]++
+
+	if mostRequestedMwm == mwm {
+		return
+	}
+
+	if mostRequestedMwm == -1 || mwmHistogram[mwm] > mwmHistogram[mostRequestedMwm] {
+		if mostRequestedMwm != -1 {
+			logs.Log.Infof("MWM distribution shifted! Most requested MWM: %d (%d requests), before: %d (%d requests)", mwm, mwmHistogram[mwm], mostRequestedMwm, mwmHistogram[mostRequestedMwm])
+		}
+		mostRequestedMwm = mwm
+	}
+}
+
+// getMwmHistogram returns a copy of the MWM histogram
+func getMwmHistogram() map[int]uint64 {
+	mwmHistogramLock.Lock()
+	defer mwmHistogramLock.Unlock()
+
+	histogram := make(map[int]uint64, len(mwmHistogram))
+	for mwm, count := range mwmHistogram {
+		histogram[mwm] = count
+	}
+	return histogram
+}
+
+// addPowStats adds a successful POW request with the MWM to the statistics
+func addPowStats(durationMs int64, mwm int) {
+	statsResetLock.RLock()
+	defer statsResetLock.RUnlock()
+
+	atomic.AddUint64(&statsPowCount, 1)
+	atomic.AddUint64(&statsPowDurationMs, uint64(durationMs))
+	powRate.add(time.Now(), mwm)
+}
+
+// addQueueWaitStats adds the time a POW request waited for a worker to the statistics
+func addQueueWaitStats(waitMs int64) {
+	statsResetLock.RLock()
+	defer statsResetLock.RUnlock()
+
+	atomic.AddUint64(&statsQueueWaitCount, 1)
+	atomic.AddUint64(&statsQueueWaitMs, uint64(waitMs))
+}
+
+// getStats returns a snapshot of the current statistics
+func getStats() common.Stats {
+	stats := common.Stats{
+		PowCount:          atomic.LoadUint64(&statsPowCount),
+		QueueDepth:        atomic.LoadInt64(&statsQueueDepth),
+		ActiveConnections: atomic.LoadInt64(&statsActiveConnections),
+		Draining:          IsDraining(),
+		MwmHistogram:      getMwmHistogram(),
+	}
+
+	if stats.PowCount > 0 {
+		stats.AveragePowDurationMs = float64(atomic.LoadUint64(&statsPowDurationMs)) / float64(stats.PowCount)
+	}
+
+	if waitCount := atomic.LoadUint64(&statsQueueWaitCount); waitCount > 0 {
+		stats.AverageQueueWaitMs = float64(atomic.LoadUint64(&statsQueueWaitMs)) / float64(waitCount)
+	}
+
+	stats.PowPerSecond, stats.RecentAverageMwm = powRate.get(time.Now())
+
+	return stats
+}
+
+// getStatsAndReset returns a snapshot of the current statistics and sets the counters to zero
+// The number of waiting requests and connected clients is not reset, it is a current value and not a counter.
+// The same applies to the POW rate, it always covers the last minute.
+func getStatsAndReset() common.Stats {
+	statsResetLock.Lock()
+	defer statsResetLock.Unlock()
+
+	stats := getStats()
+
+	atomic.StoreUint64(&statsPowCount, 0)
+	atomic.StoreUint64(&statsPowDurationMs, 0)
+	atomic.StoreUint64(&statsQueueWaitCount, 0)
+	atomic.StoreUint64(&statsQueueWaitMs, 0)
+
+	mwmHistogramLock.Lock()
+	mwmHistogram = make(map[int]uint64)
+	mostRequestedMwm = -1
+	mwmHistogramLock.Unlock()
+
+	return stats
+}
+
+// getUptimeSeconds returns the time since the start of the server in seconds
+func getUptimeSeconds() int64 {
+	return int64(time.Since(startTime).Seconds())
+}
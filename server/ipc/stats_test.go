@@ -0,0 +1,140 @@
+package ipcserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+func TestMwmHistogram(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	before := getStats().MwmHistogram
+
+	requests := map[int]int{9: 1, 13: 2, 14: 5}
+	for mwm, count := range requests {
+		for i := 0; i < count; i++ {
+			if _, _, err := powFunc(0, 0, "", "ABC9", mwm, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	after := getStats().MwmHistogram
+	for mwm, count := range requests {
+		if after[mwm]-before[mwm] != uint64(count) {
+			t.Errorf("MWM %d: %d requests counted, expected %d", mwm, after[mwm]-before[mwm], count)
+		}
+	}
+}
+
+func TestQueueWaitStats(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		time.Sleep(50 * time.Millisecond)
+		return "NONCE", nil
+	})
+	defer SetPowFunc(nil)
+
+	countBefore, waitBefore := atomic.LoadUint64(&statsQueueWaitCount), atomic.LoadUint64(&statsQueueWaitMs)
+
+	// The second request waits for the single worker
+	done := make(chan struct{})
+	go func() {
+		powFunc(0, 0, "", "ABC9", 14, false)
+		close(done)
+	}()
+	if _, _, err := powFunc(0, 0, "", "ABC9", 14, false); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if count := atomic.LoadUint64(&statsQueueWaitCount) - countBefore; count != 2 {
+		t.Errorf("%d requests counted, expected 2", count)
+	}
+	if wait := atomic.LoadUint64(&statsQueueWaitMs) - waitBefore; wait < 40 {
+		t.Errorf("Queue wait of %d ms is shorter than the POW of the other request", wait)
+	}
+	if getStats().AverageQueueWaitMs <= 0 {
+		t.Error("Average queue wait not reported")
+	}
+}
+
+func TestGetStatsAndReset(t *testing.T) {
+	getStatsAndReset()
+
+	// Every snapshot taken during the increments plus the final one has to contain all increments
+	const adders, increments = 4, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < adders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				addPowStats(2, 14)
+				addMwmStats(14)
+			}
+		}()
+	}
+
+	var powCount, mwmCount uint64
+	var powDurationMs float64
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		stats := getStatsAndReset()
+		powCount += stats.PowCount
+		powDurationMs += stats.AveragePowDurationMs * float64(stats.PowCount)
+		mwmCount += stats.MwmHistogram[14]
+	}
+
+	if powCount != adders*increments || mwmCount != adders*increments {
+		t.Errorf("%d POW requests and %d MWM requests counted, expected %d", powCount, mwmCount, adders*increments)
+	}
+	if powDurationMs != 2*adders*increments {
+		t.Errorf("Summed up POW duration of %v ms, expected %d ms", powDurationMs, 2*adders*increments)
+	}
+	if stats := getStats(); stats.PowCount != 0 || len(stats.MwmHistogram) != 0 {
+		t.Errorf("Counters not reset: %+v", stats)
+	}
+}
+
+func TestRateWindow(t *testing.T) {
+	start := time.Unix(1000, 0)
+	w := newRateWindow(start)
+
+	// 10 requests in the first 5 seconds, the rate is not extrapolated to the whole window
+	for i := 0; i < 10; i++ {
+		w.add(start.Add(time.Duration(i)*500*time.Millisecond), 10+i%2*4)
+	}
+	if perSecond, average := w.get(start.Add(5 * time.Second)); perSecond != 2 || average != 12 {
+		t.Errorf("Unexpected rate %v, average MWM %v, expected 2 and 12", perSecond, average)
+	}
+
+	// After a minute the window only covers the last 60 seconds
+	now := start.Add(90 * time.Second)
+	for i := 0; i < 30; i++ {
+		w.add(now.Add(-time.Duration(i)*time.Second), 14)
+	}
+	if perSecond, average := w.get(now); perSecond != 0.5 || average != 14 {
+		t.Errorf("Unexpected rate %v, average MWM %v, expected 0.5 and 14", perSecond, average)
+	}
+
+	// Without recent requests the rate drops to zero
+	if perSecond, _ := w.get(now.Add(2 * time.Minute)); perSecond != 0 {
+		t.Errorf("Unexpected rate %v without recent requests", perSecond)
+	}
+}
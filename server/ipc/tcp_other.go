@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package ipcserver
+
+import (
+	"syscall"
+)
+
+// setReuseAddr allows binding a TCP port that still has connections in TIME_WAIT, e.g. after a restart
+func setReuseAddr(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
+
+// setListenBacklog changes the backlog of a listening socket by calling listen again
+func setListenBacklog(fd uintptr, backlog int) error {
+	return syscall.Listen(int(fd), backlog)
+}
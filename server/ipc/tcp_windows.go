@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package ipcserver
+
+import (
+	"syscall"
+)
+
+// setReuseAddr does nothing on Windows, SO_REUSEADDR would allow other processes to take over the port
+func setReuseAddr(fd uintptr) error {
+	return nil
+}
+
+// setListenBacklog changes the backlog of a listening socket by calling listen again
+func setListenBacklog(fd uintptr, backlog int) error {
+	return syscall.Listen(syscall.Handle(fd), backlog)
+}
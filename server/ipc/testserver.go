@@ -0,0 +1,41 @@
+package ipcserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+)
+
+// NewTestServer starts an in-process diverDriver on a temporary Unix socket, backed by the given POW function.
+// It returns the path of the socket and a function that stops the server and removes the socket.
+// The POW function is set for the whole package (see SetPowFunc), so only one test server should run at a time.
+func NewTestServer(powFunc giota.PowFunc) (path string, stop func()) {
+	dir, err := ioutil.TempDir("", "diverDriver")
+	if err != nil {
+		panic(err)
+	}
+	path = filepath.Join(dir, "diverDriver.sock")
+
+	ln, err := Listen(path, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(err)
+	}
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+
+	SetPowFunc(powFunc)
+	server := NewServer(ln, config, "TestPow", "")
+	server.Start()
+
+	stop = func() {
+		server.Stop()
+		SetPowFunc(nil)
+		os.RemoveAll(dir)
+	}
+	return path, stop
+}
@@ -0,0 +1,160 @@
+package ipcserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/muxxer/diverdriver/common"
+	"github.com/muxxer/diverdriver/logs"
+)
+
+// NewTLSConfig loads the certificate of the server
+// If clientCAFile is set, clients have to authenticate with a certificate signed by this CA (mutual TLS)
+func NewTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		caCert, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("No certificates found in client CA file: %s", clientCAFile)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// Listen creates the listener for the path of the diverDriver (Unix socket path, "@name" for the abstract namespace on Linux,
+// "tcp://host:port", "tls://host:port", or a Windows named pipe "\\.\pipe\name")
+// The connections of "tls://" paths are secured by tlsConfig, which is required for them and rejected for all other paths.
+func Listen(path string, tlsConfig *tls.Config) (net.Listener, error) {
+	return ListenWithBacklog(path, tlsConfig, 0)
+}
+
+// ListenWithBacklog creates the listener like Listen with the given backlog for TCP (0 = default of the system)
+// A stale Unix socket file of a crashed diverDriver is removed, but a socket that is still in use is never replaced.
+func ListenWithBacklog(path string, tlsConfig *tls.Config, backlog int) (net.Listener, error) {
+	network, address := common.ParseDiverDriverPath(path)
+
+	useTLS := network == common.NetworkTLS
+	if useTLS {
+		if tlsConfig == nil {
+			return nil, errors.New("TLS certificate and key are required for a \"tls://\" path")
+		}
+		network = common.NetworkTCP
+	} else if tlsConfig != nil {
+		return nil, errors.New("TLS is only supported for a \"tls://\" path")
+	}
+
+	var ln net.Listener
+	var err error
+	switch network {
+	case common.NetworkPipe:
+		ln, err = listenPipe(address)
+	case common.NetworkTCP:
+		ln, err = listenTCP(address, backlog)
+	case common.NetworkUnix:
+		if err = common.CheckUnixSocketPath(address); err != nil {
+			return nil, err
+		}
+		// An abstract socket vanishes with its last reference, so there is never a stale one to remove
+		if !common.IsAbstractSocketPath(address) {
+			if err = removeStaleSocket(address); err != nil {
+				return nil, err
+			}
+		}
+		ln, err = net.Listen(network, address)
+	default:
+		ln, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if useTLS {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	return ln, nil
+}
+
+// listenTCP creates a TCP listener with SO_REUSEADDR, so a restarted diverDriver can bind the port immediately
+func listenTCP(address string, backlog int) (net.Listener, error) {
+	listenConfig := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) { sockErr = setReuseAddr(fd) }); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	ln, err := listenConfig.Listen(context.Background(), common.NetworkTCP, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if backlog > 0 {
+		rawConn, err := ln.(*net.TCPListener).SyscallConn()
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+
+		var listenErr error
+		if err := rawConn.Control(func(fd uintptr) { listenErr = setListenBacklog(fd, backlog) }); err != nil {
+			listenErr = err
+		}
+		if listenErr != nil {
+			ln.Close()
+			return nil, fmt.Errorf("Setting the listen backlog failed: %v", listenErr)
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the Unix socket file at the path, if no diverDriver is listening on it anymore
+// It returns an error if another process is still listening, or if the path is not a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("\"%v\" exists and is not a socket", path)
+	}
+
+	if c, err := net.DialTimeout(common.NetworkUnix, path, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("\"%v\" is in use by another process", path)
+	}
+
+	logs.Log.Infof("Removing stale socket \"%v\"", path)
+	return os.Remove(path)
+}
@@ -0,0 +1,51 @@
+package ipcserver
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/iotaledger/giota"
+)
+
+// maxTransactionValue is the total supply of IOTA, no transaction can move more
+const maxTransactionValue = 2779530283277761
+
+// ErrInvalidTransaction is sent for POW requests whose trytes are not a valid transaction,
+// if the server validates the transactions ("pow.validateTransaction")
+type ErrInvalidTransaction struct {
+	Field string // Field of the transaction that is invalid ("trytes" if the transaction could not be parsed)
+	Err   error
+}
+
+func (e *ErrInvalidTransaction) Error() string {
+	return fmt.Sprintf("Invalid transaction! Field: %s, %v", e.Field, e.Err)
+}
+
+func (e *ErrInvalidTransaction) Unwrap() error {
+	return e.Err
+}
+
+// validateTransaction parses the trytes into a transaction and rejects clearly invalid ones, before the POW is done
+// The characters of the trytes were already checked while decoding the request.
+// The trytes are converted into trits and every field is parsed, which costs far less than a POW but is done
+// by the connection handler for every request, so it is only enabled via "pow.validateTransaction".
+func validateTransaction(trytes giota.Trytes) error {
+	transaction, err := giota.NewTransaction(trytes)
+	if err != nil {
+		return &ErrInvalidTransaction{Field: "trytes", Err: err}
+	}
+
+	if err := transaction.Address.IsValid(); err != nil {
+		return &ErrInvalidTransaction{Field: "address", Err: err}
+	}
+
+	if transaction.Value > maxTransactionValue || transaction.Value < -maxTransactionValue {
+		return &ErrInvalidTransaction{Field: "value", Err: fmt.Errorf("%d exceeds the total supply", transaction.Value)}
+	}
+
+	if transaction.CurrentIndex > transaction.LastIndex {
+		return &ErrInvalidTransaction{Field: "currentIndex", Err: errors.New("greater than the last index")}
+	}
+
+	return nil
+}
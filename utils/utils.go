@@ -1,12 +1,100 @@
 package utils
 
-import "net/url"
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
 
+// IsValidRemoteURL returns true if the path is a http(s) URL of a remote POW server
+// Other URLs like "tcp://" or "tls://" are served by a diverDriver, and so are paths of Unix sockets.
+// The host has to be a hostname or an IP address (IPv6 in brackets), with an optional port.
 func IsValidRemoteURL(toTest string) bool {
-	uri, err := url.ParseRequestURI(toTest)
-	hostname := uri.Hostname()
-	if err != nil || hostname == "" {
+	uri, err := url.Parse(toTest)
+	if err != nil || uri.Opaque != "" || uri.User != nil {
 		return false
 	}
+	if uri.Scheme != "http" && uri.Scheme != "https" {
+		return false
+	}
+	return isValidHost(uri.Host)
+}
+
+// IsValidRemoteURLList returns true if the path is a comma separated list of remote POW server URLs (see IsValidRemoteURL)
+// A single URL is a list with one entry.
+func IsValidRemoteURLList(toTest string) bool {
+	for _, entry := range SplitRemoteURLList(toTest) {
+		if !IsValidRemoteURL(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// SplitRemoteURLList returns the entries of a comma separated list of remote POW server URLs without surrounding spaces
+func SplitRemoteURLList(list string) []string {
+	entries := strings.Split(list, ",")
+	for i, entry := range entries {
+		entries[i] = strings.TrimSpace(entry)
+	}
+	return entries
+}
+
+// isValidHost returns true if the host of an URL is a hostname, an IPv4 address or an IPv6 address in brackets,
+// followed by an optional port
+func isValidHost(host string) bool {
+	hostname, port := host, ""
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return false
+		}
+		rest := host[end+1:]
+		if rest != "" && !strings.HasPrefix(rest, ":") {
+			return false
+		}
+		hostname, port = host[1:end], strings.TrimPrefix(rest, ":")
+
+		// Only IPv6 addresses are written in brackets, optionally with a zone like "fe80::1%eth0"
+		address := strings.SplitN(hostname, "%", 2)[0]
+		if !strings.Contains(address, ":") || net.ParseIP(address) == nil {
+			return false
+		}
+	} else {
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			hostname, port = host[:i], host[i+1:]
+		}
+		if !isValidHostname(hostname) {
+			return false
+		}
+	}
+
+	if port != "" || strings.HasSuffix(host, ":") {
+		portNumber, err := strconv.Atoi(port)
+		if err != nil || portNumber < 1 || portNumber > 65535 {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidHostname returns true if the name consists of valid DNS labels (IPv4 addresses included)
+func isValidHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
 	return true
 }
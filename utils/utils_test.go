@@ -0,0 +1,84 @@
+package utils
+
+import "testing"
+
+func TestIsValidRemoteURL(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		// Unix sockets and pipes of a diverDriver
+		{"/tmp/diverDriver.sock", false},
+		{"diverDriver.sock", false},
+		{`\\.\pipe\diverDriver`, false},
+
+		// Network addresses of a diverDriver
+		{"tcp://127.0.0.1:5000", false},
+		{"tcp://[::1]:5000", false},
+		{"tcp://diverdriver.example.com:5000", false},
+		{"tls://localhost:5000", false},
+		{"tls://[::1]:5000", false},
+		{"tls://diverdriver.example.com:5000", false},
+
+		// IPv4
+		{"http://127.0.0.1", true},
+		{"http://127.0.0.1:14265", true},
+		{"https://192.168.1.10:443/pow", true},
+
+		// IPv6
+		{"http://[::1]", true},
+		{"http://[::1]:14265", true},
+		{"https://[2001:db8::1]:443/pow", true},
+		{"http://[fe80::1%25eth0]:14265", true},
+		{"http://::1:14265", false},
+		{"http://[::1", false},
+		{"http://[127.0.0.1]:14265", false},
+		{"http://[::1]x:14265", false},
+
+		// Hostnames
+		{"http://localhost:14265", true},
+		{"https://pow.example.com", true},
+		{"https://pow.example.com./", true},
+		{"http://-pow.example.com", false},
+		{"http://pow..example.com", false},
+
+		// Malformed
+		{"", false},
+		{"http://", false},
+		{"http:///path", false},
+		{"http:localhost", false},
+		{"ftp://pow.example.com", false},
+		{"http://localhost:", false},
+		{"http://localhost:0", false},
+		{"http://localhost:65536", false},
+		{"http://localhost:port", false},
+		{"http://user@localhost", false},
+		{"http://pow example.com", false},
+	}
+
+	for _, test := range tests {
+		if valid := IsValidRemoteURL(test.path); valid != test.expected {
+			t.Errorf("IsValidRemoteURL(%q) = %v, expected %v", test.path, valid, test.expected)
+		}
+	}
+}
+
+func TestIsValidRemoteURLList(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"http://127.0.0.1:14265", true},
+		{"http://node1:14265,https://node2/pow", true},
+		{"http://node1:14265, http://node2:14265", true},
+		{"http://node1:14265,", false},
+		{"http://node1:14265,/tmp/diverDriver.sock", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if result := IsValidRemoteURLList(test.path); result != test.expected {
+			t.Errorf("IsValidRemoteURLList(%q) = %v, expected %v", test.path, result, test.expected)
+		}
+	}
+}